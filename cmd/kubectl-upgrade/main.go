@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rancher/system-upgrade-controller/pkg/planctl"
+	"github.com/rancher/system-upgrade-controller/pkg/version"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// main is the entrypoint of the kubectl plugin. When installed in the PATH as kubectl-upgrade,
+// it is invoked as `kubectl upgrade`.
+func main() {
+	app := cli.NewApp()
+	app.Name = "kubectl-upgrade"
+	app.HelpName = "kubectl upgrade"
+	app.Usage = "inspect and operate on system-upgrade-controller Plans"
+	app.Version = fmt.Sprintf("%s (%s)", version.Version, version.GitCommit)
+	app.Commands = planctl.Subcommands()
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
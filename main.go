@@ -12,8 +12,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/rancher/system-upgrade-controller/pkg/planctl"
 	"github.com/rancher/system-upgrade-controller/pkg/upgrade"
 	"github.com/rancher/system-upgrade-controller/pkg/version"
 	"github.com/rancher/wrangler/v3/pkg/signals"
@@ -23,10 +25,17 @@ import (
 )
 
 var (
-	debug, leaderElect                  bool
+	debug, leaderElect, webhookEnabled  bool
+	otlpInsecure, archiveJobLogs        bool
 	kubeConfig, masterURL, nodeName     string
 	namespace, name, serviceAccountName string
-	threads                             int
+	threads, webhookPort                int
+	maxConcurrentNodes                  int
+	webhookCertDir, notifySecret        string
+	cloudEventsSink, cloudEventsSecret  string
+	otlpEndpoint, logFormat             string
+	planNamespaces, notifyURLs          cli.StringSlice
+	notifyAllowedURLs                   cli.StringSlice
 )
 
 func main() {
@@ -40,6 +49,13 @@ func main() {
 			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_DEBUG",
 			Destination: &debug,
 		},
+		cli.StringFlag{
+			Name:        "log-format",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_LOG_FORMAT",
+			Usage:       "format of log lines: text or json",
+			Value:       "text",
+			Destination: &logFormat,
+		},
 		cli.BoolFlag{
 			Name:        "leader-elect",
 			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_LEADER_ELECT",
@@ -59,7 +75,6 @@ func main() {
 		cli.StringFlag{
 			Name:        "name",
 			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_NAME",
-			Required:    true,
 			Destination: &name,
 		},
 		cli.StringFlag{
@@ -71,9 +86,87 @@ func main() {
 		cli.StringFlag{
 			Name:        "namespace",
 			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_NAMESPACE",
-			Required:    true,
 			Destination: &namespace,
 		},
+		cli.StringSliceFlag{
+			Name:   "plan-namespaces",
+			EnvVar: "SYSTEM_UPGRADE_CONTROLLER_PLAN_NAMESPACES",
+			Usage:  "namespaces to watch for Plans, or \"*\" for all namespaces; defaults to the controller namespace",
+			Value:  &planNamespaces,
+		},
+		cli.BoolFlag{
+			Name:        "webhook",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_WEBHOOK",
+			Usage:       "serve admission webhooks that validate and default Plans",
+			Destination: &webhookEnabled,
+		},
+		cli.IntFlag{
+			Name:        "webhook-port",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_WEBHOOK_PORT",
+			Value:       8443,
+			Destination: &webhookPort,
+		},
+		cli.StringFlag{
+			Name:        "webhook-cert-dir",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_WEBHOOK_CERT_DIR",
+			Usage:       "directory containing tls.crt and tls.key, such as a mounted Secret; a self-signed certificate is stored in a Secret and set as the caBundle of the webhook configurations if they do not exist",
+			Value:       "/tmp/system-upgrade-controller/webhook",
+			Destination: &webhookCertDir,
+		},
+		cli.StringSliceFlag{
+			Name:   "notify-urls",
+			EnvVar: "SYSTEM_UPGRADE_CONTROLLER_NOTIFY_URLS",
+			Usage:  "URLs that events emitted for all Plans are POSTed to, in addition to the notifications configured for each Plan",
+			Value:  &notifyURLs,
+		},
+		cli.StringFlag{
+			Name:        "notify-secret",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_NOTIFY_SECRET",
+			Usage:       "name of a Secret in the controller namespace holding a token, or username and password, for the notify URLs",
+			Destination: &notifySecret,
+		},
+		cli.StringSliceFlag{
+			Name:   "notify-allowed-urls",
+			EnvVar: "SYSTEM_UPGRADE_CONTROLLER_NOTIFY_ALLOWED_URLS",
+			Usage:  "URLs that notifications configured for Plans outside the controller namespace may be POSTed to, or under",
+			Value:  &notifyAllowedURLs,
+		},
+		cli.StringFlag{
+			Name:        "cloudevents-sink",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_CLOUDEVENTS_SINK",
+			Usage:       "URL that CloudEvents for the upgrade lifecycle of all Plans are POSTed to",
+			Destination: &cloudEventsSink,
+		},
+		cli.StringFlag{
+			Name:        "cloudevents-secret",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_CLOUDEVENTS_SECRET",
+			Usage:       "name of a Secret in the controller namespace holding a token, or username and password, for the CloudEvents sink",
+			Destination: &cloudEventsSecret,
+		},
+		cli.BoolFlag{
+			Name:        "archive-job-logs",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_ARCHIVE_JOB_LOGS",
+			Usage:       "archive the end of the logs of the Pods of failed Jobs to ConfigMaps",
+			Destination: &archiveJobLogs,
+		},
+		cli.IntFlag{
+			Name:        "max-concurrent-nodes",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_MAX_CONCURRENT_NODES",
+			Usage:       "maximum number of nodes that all Plans are applied on at once; 0 for no limit",
+			Destination: &maxConcurrentNodes,
+		},
+		cli.StringFlag{
+			Name:        "otlp-endpoint",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_OTLP_ENDPOINT,OTEL_EXPORTER_OTLP_TRACES_ENDPOINT,OTEL_EXPORTER_OTLP_ENDPOINT",
+			Usage:       "host:port or URL of an OTLP gRPC collector that spans for the rollout of each Plan are exported to",
+			Destination: &otlpEndpoint,
+		},
+		cli.BoolFlag{
+			Name:        "otlp-insecure",
+			EnvVar:      "SYSTEM_UPGRADE_CONTROLLER_OTLP_INSECURE",
+			Usage:       "do not use TLS when exporting spans to a host:port OTLP endpoint",
+			Destination: &otlpInsecure,
+		},
 		cli.StringFlag{
 			Name:        "service-account",
 			Hidden:      true,
@@ -86,7 +179,11 @@ func main() {
 			Destination: &threads,
 		},
 	}
+	// name and namespace are required by the controller, but not by the plan subcommands, so they are checked by Run
 	app.Action = Run
+	app.Commands = []cli.Command{
+		planctl.Command(),
+	}
 
 	if serviceAccountName != "" {
 		logrus.Warn("deprecated flag `service-account` is ignored")
@@ -102,11 +199,38 @@ func Run(_ *cli.Context) {
 		logrus.SetLevel(logrus.DebugLevel)
 		logrus.SetReportCaller(true)
 	}
+	formatter, err := upgrade.NewLogFormatter(logFormat)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.SetFormatter(formatter)
+	var missing []string
+	if name == "" {
+		missing = append(missing, "name")
+	}
+	if namespace == "" {
+		missing = append(missing, "namespace")
+	}
+	if len(missing) > 0 {
+		logrus.Fatalf("Required flags %q not set", strings.Join(missing, ", "))
+	}
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
 	if err != nil {
 		logrus.Fatal(err)
 	}
-	ctl, err := upgrade.NewController(cfg, namespace, name, nodeName, leaderElect, 2*time.Hour)
+	opts := []upgrade.Option{
+		upgrade.WithPlanNamespaces(planNamespaces...),
+		upgrade.WithNotifications(notifySecret, notifyURLs...),
+		upgrade.WithNotificationAllowedURLs(notifyAllowedURLs...),
+		upgrade.WithCloudEvents(cloudEventsSink, cloudEventsSecret),
+		upgrade.WithTracing(otlpEndpoint, otlpInsecure),
+		upgrade.WithJobLogArchive(archiveJobLogs),
+		upgrade.WithMaxConcurrentNodes(maxConcurrentNodes),
+	}
+	if webhookEnabled {
+		opts = append(opts, upgrade.WithWebhook(webhookPort, webhookCertDir))
+	}
+	ctl, err := upgrade.NewController(cfg, namespace, name, nodeName, leaderElect, 2*time.Hour, opts...)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -12,10 +12,24 @@ const (
 	// spec.concurrency and spec.upgrade.envs from the plan in the hash to track for upgrades.
 	AnnotationIncludeInDigest = GroupName + `/digest`
 
+	// AnnotationApprovedHash is set on plans requiring manual approval to approve the plan hash for which Jobs may be started.
+	AnnotationApprovedHash = GroupName + `/approved-hash`
+
+	// AnnotationRebootedSuffix is used for composing node annotations that record when a plan last cleared the reboot label
+	// of the node, after rebooting it. Reboot checks that reported the node as requiring a reboot before then are stale.
+	AnnotationRebootedSuffix = `rebooted.` + GroupName
+
+	// AnnotationStep is set on Jobs to the name of the most recent Plan step seen running, or that the Job failed at.
+	AnnotationStep = GroupName + `/step`
+
+	// LabelClusterPlan is the cluster plan being applied; jobs for cluster plans also carry the plan label.
+	LabelClusterPlan = GroupName + `/cluster-plan`
+
 	// LabelController is the name of the upgrade controller.
 	LabelController = GroupName + `/controller`
 
-	// LabelExclusive is set if the plan should not run concurrent with other plans.
+	// LabelExclusive is set to the exclusive group of the plan, if the plan should not run concurrent with other plans
+	// in the group, or false if it is not exclusive. Plans that are exclusive without a group are labeled true.
 	LabelExclusive = GroupName + `/exclusive`
 
 	// LabelNode is the node being upgraded.
@@ -29,8 +43,25 @@ const (
 
 	// LabelPlanSuffix is used for composing labels specific to a plan.
 	LabelPlanSuffix = `plan.` + GroupName
+
+	// LabelRebootCheck is the plan whose reboot sentinel is checked by a reboot check Pod.
+	LabelRebootCheck = GroupName + `/reboot-check`
+
+	// LabelRebootSuffix is used for composing labels that mark a node as requiring a reboot by a plan.
+	LabelRebootSuffix = `reboot.` + GroupName
+
+	// LabelRebootRequired is the value of the plan-specific reboot label on nodes that require a reboot.
+	LabelRebootRequired = `required`
 )
 
 func LabelPlanName(name string) string {
 	return path.Join(LabelPlanSuffix, name)
 }
+
+func LabelRebootName(name string) string {
+	return path.Join(LabelRebootSuffix, name)
+}
+
+func AnnotationRebootedName(name string) string {
+	return path.Join(AnnotationRebootedSuffix, name)
+}
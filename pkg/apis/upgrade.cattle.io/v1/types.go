@@ -4,6 +4,7 @@ package v1
 // SPDX-License-Identifier: Apache-2.0
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/kubereboot/kured/pkg/timewindow"
@@ -21,6 +22,8 @@ var (
 	PlanSpecValidated = condition.Cond("Validated")
 	// PlanComplete indicates that the latest version of the plan has completed on all selected nodes.
 	PlanComplete = condition.Cond("Complete")
+	// PlanAwaitingApproval indicates that the latest version of a plan requiring manual approval has not yet been approved.
+	PlanAwaitingApproval = condition.Cond("AwaitingApproval")
 )
 
 // +genclient
@@ -42,6 +45,28 @@ type Plan struct {
 	Status PlanStatus `json:"status,omitempty"`
 }
 
+// +genclient
+// +genclient:nonNamespaced
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.upgrade.image`
+// +kubebuilder:printcolumn:name="Channel",type=string,JSONPath=`.spec.channel`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.spec.version`
+// +kubebuilder:printcolumn:name="Complete",type=string,JSONPath=`.status.conditions[?(@.type=='Complete')].status`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.conditions[?(@.message!='')].message`
+// +kubebuilder:printcolumn:name="Applying",type=string,JSONPath=`.status.applying`,priority=10
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPlan is a cluster-scoped Plan. Jobs for ClusterPlans are created in the controller namespace,
+// and secrets are resolved from it.
+type ClusterPlan struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PlanSpec   `json:"spec,omitempty"`
+	Status PlanStatus `json:"status,omitempty"`
+}
+
 // PlanSpec represents the user-configurable details of a Plan.
 type PlanSpec struct {
 	// The maximum number of concurrent nodes to apply this update on.
@@ -64,15 +89,42 @@ type PlanSpec struct {
 	// Anything specified here is appended to the default of:
 	// - `{key: node.kubernetes.io/unschedulable, effect: NoSchedule, operator: Exists}`
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
-	// Jobs for exclusive plans cannot be run alongside any other exclusive plan.
+	// Jobs for exclusive plans cannot be run alongside any other exclusive plan in the same exclusive group.
 	Exclusive bool `json:"exclusive,omitempty"`
+	// Exclusive group of this Plan. Jobs for Plans in the same group cannot be run alongside each other on a Node,
+	// but may run alongside Jobs for Plans in other groups. Setting a group implies that the Plan is exclusive;
+	// exclusive Plans that do not set a group are all in the same default group.
+	ExclusiveGroup string `json:"exclusiveGroup,omitempty"`
 	// A time window in which to execute Jobs for this Plan.
-	// Jobs will not be generated outside this time window, but may continue executing into the window once started.
+	// Jobs will not be generated outside this time window, but may continue executing into the window once started,
+	// unless the window enforces its end.
 	Window *TimeWindowSpec `json:"window,omitempty"`
+	// Additional time windows in which to execute Jobs for this Plan.
+	// If more than one window is specified, Jobs may be generated while any of them is open.
+	Windows []TimeWindowSpec `json:"windows,omitempty"`
+	// Absolute time ranges in which Jobs will not be started for this Plan, even if a window is open.
+	// Jobs that were started before a blackout begins are allowed to continue.
+	Blackouts []BlackoutSpec `json:"blackouts,omitempty"`
+	// Name of a MaintenanceWindow whose windows and blackouts apply to this Plan.
+	// If specified, spec.window and spec.windows must not be set; blackouts from the Plan and the MaintenanceWindow are both applied.
+	// No Jobs are started while the MaintenanceWindow does not exist or is invalid, as reported by the Complete condition.
+	WindowRef string `json:"windowRef,omitempty"`
+	// Approval policy for new versions of this Plan; if not specified, Automatic is used.
+	// If Manual, Jobs are not started for a new latest hash until it has been approved, either by setting the
+	// `upgrade.cattle.io/approved-hash` annotation or `.status.approvedHash` to the value of `.status.latestHash`.
+	Approval ApprovalPolicy `json:"approval,omitempty"`
+	// If true, Jobs are not started on new Nodes for this Plan. Jobs for Nodes that the Plan is already being applied on are allowed to complete.
+	Paused bool `json:"paused,omitempty"`
+	// Jobs will not be started for this Plan before this time.
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// Jobs will not be started on new Nodes for this Plan after this time. If the Plan has not completed by then, it is marked as expired.
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
 	// The prepare init container, if specified, is run before cordon/drain which is run before the upgrade container.
 	Prepare *ContainerSpec `json:"prepare,omitempty"`
-	// The upgrade container; must be specified.
-	Upgrade *ContainerSpec `json:"upgrade"`
+	// Steps are run in order, as init containers after cordon/drain and before the upgrade container.
+	Steps []StepSpec `json:"steps,omitempty"`
+	// The upgrade container; must be specified unless the Plan only reboots Nodes.
+	Upgrade *ContainerSpec `json:"upgrade,omitempty"`
 	// If Cordon is true, the node is cordoned before the upgrade container is run.
 	// If drain is specified, the value for cordon is ignored, and the node is cordoned.
 	// If neither drain nor cordon are specified and the node is marked as schedulable=false it will not be marked as schedulable=true when the Job completes.
@@ -88,13 +140,58 @@ type PlanSpec struct {
 	// Label key-value pairs to apply to a node when the job for this plan completes successfully.
 	// Values may contain `$(LATEST_HASH)` or `$(LATEST_VERSION)`, which will be expanded from the plan status.
 	PostCompleteLabels map[string]string `json:"postCompleteLabels,omitempty"`
+	// Overrides applied to the Pod template of Jobs generated to apply this Plan, after the default template has been built.
+	PodTemplate *PodTemplateSpec `json:"podTemplate,omitempty"`
+	// Reboot the Node after the upgrade container completes, and wait for it to come back with a new boot ID
+	// before the Node is marked as upgraded. If not specified, the controller does not reboot the Node.
+	Reboot *RebootSpec `json:"reboot,omitempty"`
+	// HTTP endpoints that are sent a JSON payload when events are emitted for this Plan,
+	// in addition to any endpoints configured for the controller. Endpoints of Plans outside the
+	// controller namespace are only sent payloads if their URL is allowed by the controller.
+	Notifications []NotificationSpec `json:"notifications,omitempty"`
+	// Retry Jobs that fail on a Node, creating a new Job for the Node once the backoff has elapsed.
+	// If not specified, failed Jobs are not retried until they are deleted once their TTL expires.
+	Retry *RetrySpec `json:"retry,omitempty"`
+	// Policy for Nodes whose Job failed for the latest hash, and will not be retried; if not specified, Halt is used.
+	// With Halt, the Node holds its concurrency slot, even once the retry policy has given up on it and it is no longer applying, and the Plan does not complete until it is updated.
+	// With Skip, the Node is skipped, and the Plan continues on other Nodes.
+	OnNodeFailure NodeFailurePolicy `json:"onNodeFailure,omitempty"`
+	// The order in which Nodes are selected to apply this Plan. If not specified, Nodes are selected in an arbitrary
+	// but stable order, determined by a hash of the Node UID, Plan UID, and latest hash.
+	Order *OrderSpec `json:"order,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Time Zone",type=string,JSONPath=`.spec.timeZone`
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// MaintenanceWindow represents a set of time windows and blackouts, shared by the Plans that refer to it.
+type MaintenanceWindow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MaintenanceWindowSpec `json:"spec,omitempty"`
+}
+
+// MaintenanceWindowSpec represents the user-configurable details of a MaintenanceWindow.
+type MaintenanceWindowSpec struct {
+	// Time windows in which Plans referring to this MaintenanceWindow may start Jobs.
+	// If more than one window is specified, Jobs may be started while any of them is open.
+	Windows []TimeWindowSpec `json:"windows,omitempty"`
+	// Absolute time ranges in which Plans referring to this MaintenanceWindow will not start Jobs.
+	Blackouts []BlackoutSpec `json:"blackouts,omitempty"`
+	// Time zone for windows that do not specify one; if not specified UTC will be used.
+	TimeZone string `json:"timeZone,omitempty"`
 }
 
 // PlanStatus represents the resulting state from processing Plan events.
 type PlanStatus struct {
 	// `LatestResolved` indicates that the latest version as per the spec has been determined.
 	// `Validated` indicates that the plan spec has been validated.
-	// `Complete` indicates that the latest version of the plan has completed on all selected nodes. If any Jobs for the Plan fail to complete, this condition will remain false, and the reason and message will reflect the source of the error.
+	// `Complete` indicates that the latest version of the plan has completed on all selected nodes. If any Jobs for the Plan fail to complete, this condition will remain false, and the reason and message will reflect the source of the error. If the Plan skips Nodes whose Jobs failed, this condition becomes true with the `CompleteWithFailures` reason once the latest version has completed on all other selected nodes.
+	// `AwaitingApproval` indicates that the latest version of a plan requiring manual approval has not yet been approved.
 	// +optional
 	// +patchMergeKey=type
 	// +patchStrategy=merge
@@ -106,8 +203,52 @@ type PlanStatus struct {
 	LatestVersion string `json:"latestVersion,omitempty"`
 	// The hash of the most recently applied plan .spec.
 	LatestHash string `json:"latestHash,omitempty"`
+	// Time at which the rollout of the latest hash started, when the latest hash last changed.
+	RolloutStartedAt *metav1.Time `json:"rolloutStartedAt,omitempty"`
+	// The most recently approved hash, for plans requiring manual approval.
+	ApprovedHash string `json:"approvedHash,omitempty"`
 	// List of Node names that the Plan is currently being applied on.
 	Applying []string `json:"applying,omitempty"`
+	// Failures of Jobs for the latest hash, by Node. The entry for a Node is removed once the Plan has been applied on it.
+	// At most 1000 failures are recorded, the oldest being removed first, and only the 10 most recent include
+	// the termination message and logs of the failed container.
+	// +optional
+	// +listType=map
+	// +listMapKey=node
+	Failures []NodeFailure `json:"failures,omitempty"`
+	// List of Node names that the Plan has skipped, as their Job failed for the latest hash.
+	// Nodes are only skipped if the Plan node failure policy is Skip.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// NodeFailure describes the most recent failure of a Job applying a Plan on a Node.
+type NodeFailure struct {
+	// Name of the Node.
+	Node string `json:"node"`
+	// Name of the Job that failed.
+	Job string `json:"job"`
+	// The plan hash that the Job was applying.
+	Hash string `json:"hash,omitempty"`
+	// The plan step that the Job failed at, if known.
+	Step string `json:"step,omitempty"`
+	// Reason and message of the Failed condition of the Job.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Name of the container that failed, if known.
+	Container string `json:"container,omitempty"`
+	// Termination message of the container that failed, truncated.
+	TerminationMessage string `json:"terminationMessage,omitempty"`
+	// The last lines of the logs of the container that failed, truncated.
+	Logs string `json:"logs,omitempty"`
+	// Name of the ConfigMap in the Job namespace that the end of the logs of the Job Pod were archived to, if enabled.
+	LogsConfigMap string `json:"logsConfigMap,omitempty"`
+	// Time at which the Job failed.
+	FailedAt metav1.Time `json:"failedAt,omitempty"`
+	// Number of Jobs that have failed on the Node for the hash, including this one.
+	Attempts int32 `json:"attempts,omitempty"`
+	// Time at which the failed Job was deleted so that a new Job is created for the Node, as per the retry policy.
+	// Not set if the failure has not been retried.
+	RetriedAt *metav1.Time `json:"retriedAt,omitempty"`
 }
 
 // ContainerSpec is a simplified container template spec, used to configure the prepare and upgrade
@@ -124,6 +265,16 @@ type ContainerSpec struct {
 	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
 }
 
+// StepSpec describes a container run as one of the ordered steps of a Plan.
+type StepSpec struct {
+	// Name of the step, unique within the Plan.
+	// +kubebuilder:validation:Required
+	Name          string `json:"name"`
+	ContainerSpec `json:",inline"`
+	// Secrets to be mounted into the step container, in addition to those mounted for the Plan.
+	Secrets []SecretSpec `json:"secrets,omitempty"`
+}
+
 // HostPath volume to mount into the pod
 type VolumeSpec struct {
 	// Name of the Volume as it will appear within the Pod spec.
@@ -169,6 +320,149 @@ type SecretSpec struct {
 	DefaultMode *int32 `json:"defaultMode,omitempty"`
 }
 
+// PodTemplateSpec describes overrides to the Pod template of the Jobs generated for a Plan.
+// Labels and annotations are merged with those set by the controller, which take precedence.
+// Host namespace and host root settings default to true when not specified.
+type PodTemplateSpec struct {
+	// Labels to add to the Job Pod.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations to add to the Job Pod.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Use the host's IPC namespace.
+	HostIPC *bool `json:"hostIPC,omitempty"`
+	// Use the host's PID namespace.
+	HostPID *bool `json:"hostPID,omitempty"`
+	// Use the host's network namespace.
+	HostNetwork *bool `json:"hostNetwork,omitempty"`
+	// Mount the host root filesystem at `/host` in the Job Pod's containers.
+	HostRoot *bool `json:"hostRoot,omitempty"`
+	// DNS policy for the Job Pod. If not specified, `ClusterFirstWithHostNet` is used when the Pod uses the host network, and `ClusterFirst` otherwise.
+	DNSPolicy corev1.DNSPolicy `json:"dnsPolicy,omitempty"`
+	// RuntimeClass used to run the Job Pod.
+	RuntimeClassName *string `json:"runtimeClassName,omitempty"`
+	// Node selector requirements added to the Job Pod's required node affinity, alongside the requirement that pins the Pod to the Node being upgraded.
+	NodeAffinity []corev1.NodeSelectorRequirement `json:"nodeAffinity,omitempty"`
+	// Sidecar containers to run alongside the prepare, cordon/drain and upgrade containers.
+	// Sidecars are added as init containers with a restart policy of `Always`.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+}
+
+// ApprovalPolicy determines whether new versions of a Plan must be approved before Jobs are started.
+// +kubebuilder:validation:Enum=Automatic;Manual
+type ApprovalPolicy string
+
+const (
+	// ApprovalAutomatic starts Jobs for new versions of the Plan as soon as they are resolved.
+	ApprovalAutomatic ApprovalPolicy = "Automatic"
+	// ApprovalManual starts Jobs for new versions of the Plan only once they have been approved.
+	ApprovalManual ApprovalPolicy = "Manual"
+)
+
+// RebootPolicy determines whether the Node is rebooted after the upgrade container completes.
+// +kubebuilder:validation:Enum=Never;Always;IfRequired
+type RebootPolicy string
+
+const (
+	// RebootNever does not reboot the Node.
+	RebootNever RebootPolicy = "Never"
+	// RebootAlways reboots the Node after every successful upgrade.
+	RebootAlways RebootPolicy = "Always"
+	// RebootIfRequired reboots the Node only if the reboot sentinel file exists on the host.
+	RebootIfRequired RebootPolicy = "IfRequired"
+)
+
+// RebootSpec describes how the Node is rebooted after the upgrade container completes.
+type RebootSpec struct {
+	// Policy for rebooting the Node; if not specified, Never is used.
+	Policy RebootPolicy `json:"policy,omitempty"`
+	// Path on the host of the file whose existence indicates that a reboot is required, used with the IfRequired policy.
+	// If not specified, `/var/run/reboot-required` is used.
+	Sentinel string `json:"sentinel,omitempty"`
+	// Time to wait for the Node to come back with a new boot ID before the reboot is considered to have failed.
+	// The Node is then recorded as failed, and is not rebooted again until the plan hash changes.
+	// If not specified, 15 minutes is used.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// If Only is true, the Plan does not run an upgrade, and is applied only to Nodes that report the reboot sentinel.
+	// The sentinel is checked by a DaemonSet managed by the controller, and the policy is ignored.
+	Only bool `json:"only,omitempty"`
+}
+
+// NodeFailurePolicy determines whether a Plan continues on other Nodes once the Job for a Node has failed.
+// +kubebuilder:validation:Enum=Halt;Skip
+type NodeFailurePolicy string
+
+const (
+	// NodeFailureHalt keeps the failed Node on the applying list, so the Plan does not complete.
+	NodeFailureHalt NodeFailurePolicy = "Halt"
+	// NodeFailureSkip skips the failed Node, so the Plan continues on other Nodes, and completes with failures.
+	NodeFailureSkip NodeFailurePolicy = "Skip"
+)
+
+// NodeOrder determines the order in which Nodes are selected to apply a Plan.
+// +kubebuilder:validation:Enum=Name;Label;OldestVersion;LeastLoaded
+type NodeOrder string
+
+const (
+	// NodeOrderName selects Nodes in order of name.
+	NodeOrderName NodeOrder = "Name"
+	// NodeOrderLabel selects Nodes in order of the value of a label.
+	NodeOrderLabel NodeOrder = "Label"
+	// NodeOrderOldestVersion selects Nodes with the oldest kubelet version first.
+	NodeOrderOldestVersion NodeOrder = "OldestVersion"
+	// NodeOrderLeastLoaded selects Nodes with the fewest Pods first, not counting Pods owned by DaemonSets.
+	NodeOrderLeastLoaded NodeOrder = "LeastLoaded"
+)
+
+// OrderSpec describes the order in which Nodes are selected to apply a Plan.
+// Nodes that are equal in this order are selected in the default order, so that the order remains stable.
+type OrderSpec struct {
+	// Strategy for ordering Nodes.
+	// +kubebuilder:validation:Required
+	By NodeOrder `json:"by"`
+	// Key of the label whose value Nodes are ordered by, required with the Label strategy.
+	// Values are compared as integers if both are integers, and as strings otherwise. Nodes without the label are selected last.
+	Label string `json:"label,omitempty"`
+}
+
+// RetryOn identifies the kind of failure of a Job that is retried.
+// +kubebuilder:validation:Enum=Drain;Upgrade
+type RetryOn string
+
+const (
+	// RetryOnDrain retries Jobs that failed to drain the Node.
+	RetryOnDrain RetryOn = "Drain"
+	// RetryOnUpgrade retries Jobs that failed at any other point, including the upgrade container and steps.
+	RetryOnUpgrade RetryOn = "Upgrade"
+)
+
+// RetrySpec describes how Jobs that fail on a Node are retried.
+type RetrySpec struct {
+	// Maximum number of Jobs created on a Node for the same hash, including the first.
+	// If not specified, 3 is used.
+	// +kubebuilder:validation:Minimum=1
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+	// Time to wait after a Job fails before a new Job is created for the Node.
+	// If not specified, 1 minute is used.
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+	// Kinds of failure to retry. If not specified, both Drain and Upgrade failures are retried.
+	On []RetryOn `json:"on,omitempty"`
+}
+
+// NotificationSpec describes an HTTP endpoint that is notified of events emitted for a Plan.
+type NotificationSpec struct {
+	// URL that the JSON payload is POSTed to. Must be an http or https URL.
+	// +kubebuilder:validation:Required
+	URL string `json:"url"`
+	// Name of a Secret holding credentials for the endpoint, in the Plan namespace, or the controller namespace for ClusterPlans.
+	// If the Secret has a `token` key, it is sent as a bearer token; otherwise, if it has `username` and `password` keys,
+	// they are sent using basic authentication.
+	SecretName string `json:"secretName,omitempty"`
+	// Reasons of the events to send. If not specified, Resolved, SyncJob, JobFailed, JobComplete, Complete and Waiting events are sent.
+	Events []string `json:"events,omitempty"`
+}
+
 // +kubebuilder:validation:Enum={"0","su","sun","sunday","1","mo","mon","monday","2","tu","tue","tuesday","3","we","wed","wednesday","4","th","thu","thursday","5","fr","fri","friday","6","sa","sat","saturday"}
 type Day string
 
@@ -183,16 +477,84 @@ type TimeWindowSpec struct {
 	EndTime string `json:"endTime,omitempty"`
 	// Time zone for the time window; if not specified UTC will be used.
 	TimeZone string `json:"timeZone,omitempty"`
+	// If set to true, Jobs will not be started on new Nodes once the time window has closed, even if Jobs are still running on other Nodes.
+	EnforceEnd bool `json:"enforceEnd,omitempty"`
+	// If set to true along with enforceEnd, Jobs that have been created but not yet started when the time window closes
+	// are suspended until the time window next opens.
+	SuspendPending bool `json:"suspendPending,omitempty"`
 }
 
 func (tws *TimeWindowSpec) Contains(t time.Time) bool {
-	days := make([]string, len(tws.Days))
-	for i, day := range tws.Days {
-		days[i] = string(day)
-	}
-	tw, err := timewindow.New(days, tws.StartTime, tws.EndTime, tws.TimeZone)
+	tw, err := tws.timeWindow()
 	if err != nil {
 		return false
 	}
 	return tw.Contains(t)
 }
+
+// NextOpen returns the first time, no earlier than the given time, that the time window is open. A window that is not
+// open opens next either at its start time, or at midnight on one of its days if it spans midnight, so only those times
+// are checked, on each day up to the same day of the following week. If the window cannot be parsed, or does not open
+// within that time, false is returned.
+func (tws *TimeWindowSpec) NextOpen(t time.Time) (time.Time, bool) {
+	tw, err := tws.timeWindow()
+	if err != nil {
+		return time.Time{}, false
+	}
+	if tw.Contains(t) {
+		return t, true
+	}
+	location, err := time.LoadLocation(tws.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	start, err := parseTimeOfDay(tws.StartTime, location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	local := t.In(location)
+	for day := 0; day <= 7; day++ {
+		midnight := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, location)
+		opens := time.Date(local.Year(), local.Month(), local.Day()+day, start.Hour(), start.Minute(), start.Second(), 0, location)
+		for _, next := range []time.Time{midnight, opens} {
+			if next.After(t) && tw.Contains(next) {
+				return next.In(t.Location()), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTimeOfDay parses the start or end time of a time window, in the formats accepted by timewindow.New.
+func parseTimeOfDay(s string, location *time.Location) (time.Time, error) {
+	for _, layout := range []string{"15:04", "15:04:05", "03:04pm", "15", "03pm", "3pm"} {
+		if t, err := time.ParseInLocation(layout, s, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time format: %s", s)
+}
+
+func (tws *TimeWindowSpec) timeWindow() (*timewindow.TimeWindow, error) {
+	days := make([]string, len(tws.Days))
+	for i, day := range tws.Days {
+		days[i] = string(day)
+	}
+	return timewindow.New(days, tws.StartTime, tws.EndTime, tws.TimeZone)
+}
+
+// BlackoutSpec describes an absolute time range in which a Plan should not be processed.
+type BlackoutSpec struct {
+	// Start of the blackout.
+	// +kubebuilder:validation:Required
+	Start metav1.Time `json:"start"`
+	// End of the blackout.
+	// +kubebuilder:validation:Required
+	End metav1.Time `json:"end"`
+	// Reason for the blackout, included in events and status messages.
+	Reason string `json:"reason,omitempty"`
+}
+
+func (bs *BlackoutSpec) Contains(t time.Time) bool {
+	return !t.Before(bs.Start.Time) && t.Before(bs.End.Time)
+}
@@ -40,3 +40,37 @@ func NewPlan(namespace, name string, obj Plan) *Plan {
 	obj.Namespace = namespace
 	return &obj
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPlanList is a list of ClusterPlan resources
+type ClusterPlanList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ClusterPlan `json:"items"`
+}
+
+func NewClusterPlan(namespace, name string, obj ClusterPlan) *ClusterPlan {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("ClusterPlan").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// MaintenanceWindowList is a list of MaintenanceWindow resources
+type MaintenanceWindowList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []MaintenanceWindow `json:"items"`
+}
+
+func NewMaintenanceWindow(namespace, name string, obj MaintenanceWindow) *MaintenanceWindow {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("MaintenanceWindow").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
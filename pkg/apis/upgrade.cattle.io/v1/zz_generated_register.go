@@ -28,7 +28,9 @@ import (
 )
 
 var (
-	PlanResourceName = "plans"
+	ClusterPlanResourceName       = "clusterplans"
+	MaintenanceWindowResourceName = "maintenancewindows"
+	PlanResourceName              = "plans"
 )
 
 // SchemeGroupVersion is group version used to register these objects
@@ -52,6 +54,10 @@ var (
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&ClusterPlan{},
+		&ClusterPlanList{},
+		&MaintenanceWindow{},
+		&MaintenanceWindowList{},
 		&Plan{},
 		&PlanList{},
 	)
@@ -15,6 +15,8 @@ func main() {
 			"upgrade.cattle.io": {
 				Types: []interface{}{
 					v1.Plan{},
+					v1.ClusterPlan{},
+					v1.MaintenanceWindow{},
 				},
 				GenerateTypes:   true,
 				GenerateClients: true,
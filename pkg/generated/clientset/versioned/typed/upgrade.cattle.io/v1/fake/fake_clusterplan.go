@@ -0,0 +1,48 @@
+/*
+Copyright 2019 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by codegen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradecattleiov1 "github.com/rancher/system-upgrade-controller/pkg/generated/clientset/versioned/typed/upgrade.cattle.io/v1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeClusterPlans implements ClusterPlanInterface
+type fakeClusterPlans struct {
+	*gentype.FakeClientWithList[*v1.ClusterPlan, *v1.ClusterPlanList]
+	Fake *FakeUpgradeV1
+}
+
+func newFakeClusterPlans(fake *FakeUpgradeV1) upgradecattleiov1.ClusterPlanInterface {
+	return &fakeClusterPlans{
+		gentype.NewFakeClientWithList[*v1.ClusterPlan, *v1.ClusterPlanList](
+			fake.Fake,
+			"",
+			v1.SchemeGroupVersion.WithResource("clusterplans"),
+			v1.SchemeGroupVersion.WithKind("ClusterPlan"),
+			func() *v1.ClusterPlan { return &v1.ClusterPlan{} },
+			func() *v1.ClusterPlanList { return &v1.ClusterPlanList{} },
+			func(dst, src *v1.ClusterPlanList) { dst.ListMeta = src.ListMeta },
+			func(list *v1.ClusterPlanList) []*v1.ClusterPlan { return gentype.ToPointerSlice(list.Items) },
+			func(list *v1.ClusterPlanList, items []*v1.ClusterPlan) { list.Items = gentype.FromPointerSlice(items) },
+		),
+		fake,
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by codegen. DO NOT EDIT.
+
+package fake
+
+import (
+	v1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradecattleiov1 "github.com/rancher/system-upgrade-controller/pkg/generated/clientset/versioned/typed/upgrade.cattle.io/v1"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// fakeMaintenanceWindows implements MaintenanceWindowInterface
+type fakeMaintenanceWindows struct {
+	*gentype.FakeClientWithList[*v1.MaintenanceWindow, *v1.MaintenanceWindowList]
+	Fake *FakeUpgradeV1
+}
+
+func newFakeMaintenanceWindows(fake *FakeUpgradeV1) upgradecattleiov1.MaintenanceWindowInterface {
+	return &fakeMaintenanceWindows{
+		gentype.NewFakeClientWithList[*v1.MaintenanceWindow, *v1.MaintenanceWindowList](
+			fake.Fake,
+			"",
+			v1.SchemeGroupVersion.WithResource("maintenancewindows"),
+			v1.SchemeGroupVersion.WithKind("MaintenanceWindow"),
+			func() *v1.MaintenanceWindow { return &v1.MaintenanceWindow{} },
+			func() *v1.MaintenanceWindowList { return &v1.MaintenanceWindowList{} },
+			func(dst, src *v1.MaintenanceWindowList) { dst.ListMeta = src.ListMeta },
+			func(list *v1.MaintenanceWindowList) []*v1.MaintenanceWindow {
+				return gentype.ToPointerSlice(list.Items)
+			},
+			func(list *v1.MaintenanceWindowList, items []*v1.MaintenanceWindow) {
+				list.Items = gentype.FromPointerSlice(items)
+			},
+		),
+		fake,
+	}
+}
@@ -28,6 +28,14 @@ type FakeUpgradeV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeUpgradeV1) ClusterPlans() v1.ClusterPlanInterface {
+	return newFakeClusterPlans(c)
+}
+
+func (c *FakeUpgradeV1) MaintenanceWindows() v1.MaintenanceWindowInterface {
+	return newFakeMaintenanceWindows(c)
+}
+
 func (c *FakeUpgradeV1) Plans(namespace string) v1.PlanInterface {
 	return newFakePlans(c, namespace)
 }
@@ -18,4 +18,8 @@ limitations under the License.
 
 package v1
 
+type ClusterPlanExpansion interface{}
+
+type MaintenanceWindowExpansion interface{}
+
 type PlanExpansion interface{}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by codegen. DO NOT EDIT.
+
+package v1
+
+import (
+	context "context"
+
+	upgradecattleiov1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	scheme "github.com/rancher/system-upgrade-controller/pkg/generated/clientset/versioned/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	gentype "k8s.io/client-go/gentype"
+)
+
+// MaintenanceWindowsGetter has a method to return a MaintenanceWindowInterface.
+// A group's client should implement this interface.
+type MaintenanceWindowsGetter interface {
+	MaintenanceWindows() MaintenanceWindowInterface
+}
+
+// MaintenanceWindowInterface has methods to work with MaintenanceWindow resources.
+type MaintenanceWindowInterface interface {
+	Create(ctx context.Context, maintenanceWindow *upgradecattleiov1.MaintenanceWindow, opts metav1.CreateOptions) (*upgradecattleiov1.MaintenanceWindow, error)
+	Update(ctx context.Context, maintenanceWindow *upgradecattleiov1.MaintenanceWindow, opts metav1.UpdateOptions) (*upgradecattleiov1.MaintenanceWindow, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*upgradecattleiov1.MaintenanceWindow, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*upgradecattleiov1.MaintenanceWindowList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (result *upgradecattleiov1.MaintenanceWindow, err error)
+	MaintenanceWindowExpansion
+}
+
+// maintenanceWindows implements MaintenanceWindowInterface
+type maintenanceWindows struct {
+	*gentype.ClientWithList[*upgradecattleiov1.MaintenanceWindow, *upgradecattleiov1.MaintenanceWindowList]
+}
+
+// newMaintenanceWindows returns a MaintenanceWindows
+func newMaintenanceWindows(c *UpgradeV1Client) *maintenanceWindows {
+	return &maintenanceWindows{
+		gentype.NewClientWithList[*upgradecattleiov1.MaintenanceWindow, *upgradecattleiov1.MaintenanceWindowList](
+			"maintenancewindows",
+			c.RESTClient(),
+			scheme.ParameterCodec,
+			"",
+			func() *upgradecattleiov1.MaintenanceWindow { return &upgradecattleiov1.MaintenanceWindow{} },
+			func() *upgradecattleiov1.MaintenanceWindowList { return &upgradecattleiov1.MaintenanceWindowList{} },
+		),
+	}
+}
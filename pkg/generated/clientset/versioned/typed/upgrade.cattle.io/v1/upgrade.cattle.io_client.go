@@ -28,6 +28,8 @@ import (
 
 type UpgradeV1Interface interface {
 	RESTClient() rest.Interface
+	ClusterPlansGetter
+	MaintenanceWindowsGetter
 	PlansGetter
 }
 
@@ -36,6 +38,14 @@ type UpgradeV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *UpgradeV1Client) ClusterPlans() ClusterPlanInterface {
+	return newClusterPlans(c)
+}
+
+func (c *UpgradeV1Client) MaintenanceWindows() MaintenanceWindowInterface {
+	return newMaintenanceWindows(c)
+}
+
 func (c *UpgradeV1Client) Plans(namespace string) PlanInterface {
 	return newPlans(c, namespace)
 }
@@ -31,6 +31,8 @@ func init() {
 }
 
 type Interface interface {
+	ClusterPlan() ClusterPlanController
+	MaintenanceWindow() MaintenanceWindowController
 	Plan() PlanController
 }
 
@@ -44,6 +46,14 @@ type version struct {
 	controllerFactory controller.SharedControllerFactory
 }
 
+func (v *version) ClusterPlan() ClusterPlanController {
+	return generic.NewNonNamespacedController[*v1.ClusterPlan, *v1.ClusterPlanList](schema.GroupVersionKind{Group: "upgrade.cattle.io", Version: "v1", Kind: "ClusterPlan"}, "clusterplans", v.controllerFactory)
+}
+
+func (v *version) MaintenanceWindow() MaintenanceWindowController {
+	return generic.NewNonNamespacedController[*v1.MaintenanceWindow, *v1.MaintenanceWindowList](schema.GroupVersionKind{Group: "upgrade.cattle.io", Version: "v1", Kind: "MaintenanceWindow"}, "maintenancewindows", v.controllerFactory)
+}
+
 func (v *version) Plan() PlanController {
 	return generic.NewController[*v1.Plan, *v1.PlanList](schema.GroupVersionKind{Group: "upgrade.cattle.io", Version: "v1", Kind: "Plan"}, "plans", true, v.controllerFactory)
 }
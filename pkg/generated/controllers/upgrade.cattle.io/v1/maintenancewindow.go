@@ -0,0 +1,39 @@
+/*
+Copyright 2019 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by codegen. DO NOT EDIT.
+
+package v1
+
+import (
+	v1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+)
+
+// MaintenanceWindowController interface for managing MaintenanceWindow resources.
+type MaintenanceWindowController interface {
+	generic.NonNamespacedControllerInterface[*v1.MaintenanceWindow, *v1.MaintenanceWindowList]
+}
+
+// MaintenanceWindowClient interface for managing MaintenanceWindow resources in Kubernetes.
+type MaintenanceWindowClient interface {
+	generic.NonNamespacedClientInterface[*v1.MaintenanceWindow, *v1.MaintenanceWindowList]
+}
+
+// MaintenanceWindowCache interface for retrieving MaintenanceWindow resources in memory.
+type MaintenanceWindowCache interface {
+	generic.NonNamespacedCacheInterface[*v1.MaintenanceWindow]
+}
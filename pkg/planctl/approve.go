@@ -0,0 +1,64 @@
+package planctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	"github.com/urfave/cli"
+)
+
+func approveCommand() cli.Command {
+	return cli.Command{
+		Name:      "approve",
+		Usage:     "approve the latest hash of a Plan that requires manual approval",
+		ArgsUsage: "<plan>",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "hash",
+				Usage: "the hash to approve; if not set, the current latest hash of the Plan is approved",
+			},
+		}, flags...),
+		Action: approve,
+	}
+}
+
+func approve(c *cli.Context) error {
+	ctx := context.Background()
+	name, err := planName(c)
+	if err != nil {
+		return err
+	}
+	cl, err := newClients(c)
+	if err != nil {
+		return err
+	}
+	plan, err := cl.getPlan(ctx, name)
+	if err != nil {
+		return err
+	}
+	hash := c.String("hash")
+	if hash == "" {
+		hash = plan.Status.LatestHash
+	}
+	if hash == "" {
+		return cli.NewExitError(fmt.Sprintf("%s does not have a latest hash to approve", name), 1)
+	}
+	if hash != plan.Status.LatestHash {
+		fmt.Fprintf(c.App.ErrWriter, "Warning: hash %s is not the latest hash %s\n", hash, plan.Status.LatestHash)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{upgradeapi.AnnotationApprovedHash: hash},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := cl.patchPlan(ctx, name, patch); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Approved %s. Hash: %s\n", name, hash)
+	return nil
+}
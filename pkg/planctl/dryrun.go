@@ -0,0 +1,130 @@
+package planctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/urfave/cli"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+func dryRunCommand() cli.Command {
+	return cli.Command{
+		Name:      "dry-run",
+		Usage:     "print the nodes that a Plan would be applied to, in order and by batch, and the Jobs that would be created",
+		ArgsUsage: "<plan>",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "filename, f",
+				Usage: "read the Plan or ClusterPlan from a manifest, instead of from the cluster",
+			},
+			cli.BoolFlag{
+				Name:  "no-jobs",
+				Usage: "print only the batches, without the rendered Jobs",
+			},
+		}, flags...),
+		Action: dryRun,
+	}
+}
+
+func dryRun(c *cli.Context) error {
+	ctx := context.Background()
+	cl, err := newClients(c)
+	if err != nil {
+		return err
+	}
+	var plan *upgradeapiv1.Plan
+	if filename := c.String("filename"); filename != "" {
+		plan, err = cl.readPlan(ctx, filename)
+	} else if c.NArg() == 1 {
+		plan, err = cl.getPlan(ctx, c.Args().First())
+	} else {
+		return cli.NewExitError("a plan name or --filename is required", 1)
+	}
+	if err != nil {
+		return err
+	}
+	nodeCache, nodeIndexer, err := cl.nodeCache(ctx)
+	if err != nil {
+		return err
+	}
+	batches, err := selectBatches(plan, cl.controllerNamespace, nodeCache, nodeIndexer, cl.nodeLoads(ctx))
+	if err != nil {
+		return err
+	}
+	return printDryRun(c.App.Writer, plan, batches, cl.controllerNamespace, cl.controllerName, !c.Bool("no-jobs"))
+}
+
+// selectBatches repeatedly selects nodes for the plan as the generating handler would, marking the nodes of each
+// batch as complete before selecting the next. Nodes that the plan is already applying to are in the first batch.
+func selectBatches(plan *upgradeapiv1.Plan, controllerNamespace string, nodeCache corectlv1.NodeCache, nodeIndexer cache.Indexer, nodeLoads upgradeplan.NodeLoads) ([][]*corev1.Node, error) {
+	plan = plan.DeepCopy()
+	var batches [][]*corev1.Node
+	for {
+		batch, err := upgradeplan.SelectConcurrentNodes(plan, controllerNamespace, nodeCache, nodeLoads)
+		if err != nil || len(batch) == 0 {
+			return batches, err
+		}
+		batches = append(batches, batch)
+		for _, node := range batch {
+			node = node.DeepCopy()
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[upgradejob.LabelPlanName(plan, controllerNamespace)] = plan.Status.LatestHash
+			delete(node.Labels, upgradejob.LabelRebootName(plan, controllerNamespace))
+			if err := nodeIndexer.Update(node); err != nil {
+				return nil, err
+			}
+		}
+		plan.Status.Applying = nil
+	}
+}
+
+func printDryRun(w io.Writer, plan *upgradeapiv1.Plan, batches [][]*corev1.Node, controllerNamespace, controllerName string, jobs bool) error {
+	fmt.Fprintf(w, "# Plan %s/%s: version %q, hash %s\n", plan.Namespace, plan.Name, plan.Status.LatestVersion, plan.Status.LatestHash)
+	if plan.UID == "" {
+		fmt.Fprintln(w, "# Plan has not been created; the order of nodes within the plan will differ once it is")
+	}
+	if len(batches) == 0 {
+		fmt.Fprintln(w, "# No nodes selected")
+		return nil
+	}
+	for i, batch := range batches {
+		names := make([]string, len(batch))
+		for j, node := range batch {
+			names[j] = node.Name
+		}
+		fmt.Fprintf(w, "# Batch %d: %s\n", i+1, strings.Join(names, ", "))
+	}
+	if !jobs {
+		return nil
+	}
+	for _, batch := range batches {
+		for _, node := range batch {
+			if err := printJob(w, upgradejob.New(plan, node, controllerNamespace, controllerName)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func printJob(w io.Writer, job *batchv1.Job) error {
+	job.APIVersion, job.Kind = batchv1.SchemeGroupVersion.String(), "Job"
+	b, err := yaml.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "---\n%s", b)
+	return err
+}
@@ -0,0 +1,150 @@
+package planctl
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+var _ = Describe("DryRun", func() {
+	var (
+		plan        *upgradeapiv1.Plan
+		nodeIndexer cache.Indexer
+		nodeCache   *generic.NonNamespacedCache[*corev1.Node]
+	)
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade", UID: types.UID("test-plan-uid")},
+			Spec: upgradeapiv1.PlanSpec{
+				Concurrency: 2,
+				NodeSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"upgrade": "true"},
+				},
+				Upgrade: &upgradeapiv1.ContainerSpec{Image: "test/image"},
+			},
+			Status: upgradeapiv1.PlanStatus{
+				LatestVersion: "v1.0.0",
+				LatestHash:    "test-hash",
+			},
+		}
+		nodeIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for i := 0; i < 5; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			Expect(nodeIndexer.Add(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: name,
+					UID:  types.UID(name + "-uid"),
+					Labels: map[string]string{
+						corev1.LabelHostname: name,
+						"upgrade":            "true",
+					},
+				},
+			})).To(Succeed())
+		}
+		Expect(nodeIndexer.Add(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node-upgraded",
+				Labels: map[string]string{
+					corev1.LabelHostname:                  "node-upgraded",
+					"upgrade":                             "true",
+					upgradeapi.LabelPlanName("test-plan"): "test-hash",
+				},
+			},
+		})).To(Succeed())
+		nodeCache = generic.NewNonNamespacedCache[*corev1.Node](nodeIndexer, corev1.Resource("nodes"))
+	})
+
+	It("should select all nodes that are not up to date in batches of the plan concurrency", func() {
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batches).To(HaveLen(3))
+		Expect(batches[0]).To(HaveLen(2))
+		Expect(batches[1]).To(HaveLen(2))
+		Expect(batches[2]).To(HaveLen(1))
+		var names []string
+		for _, batch := range batches {
+			for _, node := range batch {
+				names = append(names, node.Name)
+			}
+		}
+		Expect(names).To(ConsistOf("node-0", "node-1", "node-2", "node-3", "node-4"))
+		Expect(plan.Status.Applying).To(BeEmpty())
+	})
+
+	It("should select nodes the plan is applying to in the first batch", func() {
+		plan.Status.Applying = []string{"node-3"}
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batches).ToNot(BeEmpty())
+		Expect(batches[0]).To(ContainElement(HaveField("Name", "node-3")))
+	})
+
+	It("should select nodes in the order requested by the plan", func() {
+		plan.Spec.Order = &upgradeapiv1.OrderSpec{By: upgradeapiv1.NodeOrderName}
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batches).To(HaveLen(3))
+		Expect(batches[0]).To(HaveExactElements(HaveField("Name", "node-0"), HaveField("Name", "node-1")))
+		Expect(batches[2]).To(HaveExactElements(HaveField("Name", "node-4")))
+	})
+
+	It("should select the least loaded nodes first", func() {
+		plan.Spec.Order = &upgradeapiv1.OrderSpec{By: upgradeapiv1.NodeOrderLeastLoaded}
+		loads := func() (map[string]int, error) {
+			return map[string]int{"node-0": 5, "node-1": 4, "node-2": 3, "node-3": 2, "node-4": 1}, nil
+		}
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, loads)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batches).To(HaveLen(3))
+		Expect(batches[0]).To(HaveExactElements(HaveField("Name", "node-4"), HaveField("Name", "node-3")))
+		Expect(batches[2]).To(HaveExactElements(HaveField("Name", "node-0")))
+	})
+
+	It("should select nodes by label value, with unlabeled nodes last", func() {
+		plan.Spec.Order = &upgradeapiv1.OrderSpec{By: upgradeapiv1.NodeOrderLabel, Label: "priority"}
+		for name, priority := range map[string]string{"node-1": "10", "node-3": "2", "node-4": "1"} {
+			obj, _, err := nodeIndexer.GetByKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			node := obj.(*corev1.Node).DeepCopy()
+			node.Labels["priority"] = priority
+			Expect(nodeIndexer.Update(node)).To(Succeed())
+		}
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batches).To(HaveLen(3))
+		Expect(batches[0]).To(HaveExactElements(HaveField("Name", "node-4"), HaveField("Name", "node-3")))
+		Expect(batches[1]).To(ContainElement(HaveField("Name", "node-1")))
+	})
+
+	It("should select nodes with the oldest kubelet version first", func() {
+		plan.Spec.Order = &upgradeapiv1.OrderSpec{By: upgradeapiv1.NodeOrderOldestVersion}
+		for name, version := range map[string]string{"node-0": "v1.32.1+k3s1", "node-1": "v1.31.4+k3s1", "node-2": "v1.32.0+k3s1", "node-3": "v1.32.1+k3s1", "node-4": "v1.32.1+k3s1"} {
+			obj, _, err := nodeIndexer.GetByKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			node := obj.(*corev1.Node).DeepCopy()
+			node.Status.NodeInfo.KubeletVersion = version
+			Expect(nodeIndexer.Update(node)).To(Succeed())
+		}
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batches[0]).To(HaveExactElements(HaveField("Name", "node-1"), HaveField("Name", "node-2")))
+	})
+
+	It("should print the batches and jobs", func() {
+		batches, err := selectBatches(plan, "system-upgrade", nodeCache, nodeIndexer, nil)
+		Expect(err).ToNot(HaveOccurred())
+		out := &bytes.Buffer{}
+		Expect(printDryRun(out, plan, batches, "system-upgrade", "system-upgrade-controller", true)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("# Batch 3: "))
+		Expect(bytes.Count(out.Bytes(), []byte("\nkind: Job\n"))).To(Equal(5))
+	})
+})
@@ -0,0 +1,88 @@
+package planctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	"github.com/urfave/cli"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func historyCommand() cli.Command {
+	return cli.Command{
+		Name:      "history",
+		Usage:     "print the Jobs that have been created for a Plan, oldest first",
+		ArgsUsage: "<plan>",
+		Flags:     flags,
+		Action:    history,
+	}
+}
+
+func history(c *cli.Context) error {
+	ctx := context.Background()
+	name, err := planName(c)
+	if err != nil {
+		return err
+	}
+	cl, err := newClients(c)
+	if err != nil {
+		return err
+	}
+	plan, err := cl.getPlan(ctx, name)
+	if err != nil {
+		return err
+	}
+	jobs, err := cl.listJobs(ctx, plan)
+	if err != nil {
+		return err
+	}
+	selector, err := upgradeplan.NodeSelector(plan)
+	if err != nil {
+		return err
+	}
+	nodes, err := cl.kcs.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	printHistory(c.App.Writer, jobs, applyingNodes(plan, nodes.Items))
+	return nil
+}
+
+// printHistory prints a table of the Jobs. Note that finished Jobs are deleted by the controller once their
+// TTL has passed, so the history only goes back as far as the oldest retained Job. The names of the nodes that the
+// plan is applying on are given to tell Jobs suspended until a window opens from Jobs that are yet to start.
+func printHistory(w io.Writer, jobs []batchv1.Job, applying map[string]bool) {
+	if len(jobs) == 0 {
+		fmt.Fprintln(w, "No Jobs found")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tNODE\tVERSION\tSTATUS\tCREATED\tFINISHED")
+	for i := range jobs {
+		job := &jobs[i]
+		status, finished := jobPhase(job, applying[job.Labels[upgradeapi.LabelNode]]), "<none>"
+		switch {
+		case upgradejob.ConditionComplete.IsTrue(job):
+			finished = formatTime(upgradejob.ConditionComplete.GetLastTransitionTime(job))
+		case upgradejob.ConditionFailed.IsTrue(job):
+			status, finished = status+": "+upgradejob.ConditionFailed.GetReason(job), formatTime(upgradejob.ConditionFailed.GetLastTransitionTime(job))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", job.Name, job.Labels[upgradeapi.LabelNode], job.Labels[upgradeapi.LabelVersion],
+			status, formatTime(job.CreationTimestamp.Time), finished)
+	}
+	tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "<none>"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,67 @@
+package planctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	"sigs.k8s.io/yaml"
+)
+
+func nodesCommand() cli.Command {
+	return cli.Command{
+		Name:      "nodes",
+		Usage:     "print each node selected by a Plan, with its plan hash label, and the phase of its Job for the latest hash",
+		ArgsUsage: "<plan>",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "output, o",
+				Value: "table",
+				Usage: "output format: table, json or yaml",
+			},
+		}, flags...),
+		Action: nodes,
+	}
+}
+
+func nodes(c *cli.Context) error {
+	output := c.String("output")
+	if output != "table" && output != "json" && output != "yaml" {
+		return cli.NewExitError(fmt.Sprintf("unsupported output format %q", output), 1)
+	}
+	_, progress, err := loadProgress(context.Background(), c)
+	if err != nil {
+		return err
+	}
+	return printNodes(c.App.Writer, progress, output)
+}
+
+func printNodes(w io.Writer, progress []nodeProgress, output string) error {
+	if progress == nil {
+		progress = []nodeProgress{}
+	}
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(progress)
+	case "yaml":
+		b, err := yaml.Marshal(progress)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tHASH\tUP-TO-DATE\tSTATE\tJOB\tPHASE\tREASON")
+	for _, p := range progress {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.Node, valueOrNone(p.Hash), strconv.FormatBool(p.UpToDate), p.State,
+			valueOrNone(p.Job), valueOrNone(p.JobPhase), valueOrNone(p.FailureReason))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,63 @@
+package planctl
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("Nodes", func() {
+	progress := []nodeProgress{{
+		Node:     "node-done",
+		Hash:     "test-hash",
+		UpToDate: true,
+		State:    nodeDone,
+		Job:      "apply-test-plan-on-node-done-with-test-hash",
+		JobPhase: "Complete",
+	}, {
+		Node:          "node-failed",
+		State:         nodeFailed,
+		Job:           "apply-test-plan-on-node-failed-with-test-hash",
+		JobPhase:      "Failed",
+		FailureReason: "BackoffLimitExceeded: Job has reached the specified backoff limit",
+	}}
+
+	It("should print a table", func() {
+		out := &bytes.Buffer{}
+		Expect(printNodes(out, progress, "table")).To(Succeed())
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		Expect(lines).To(HaveLen(3))
+		Expect(string(lines[0])).To(MatchRegexp(`^NODE\s+HASH\s+UP-TO-DATE\s+STATE\s+JOB\s+PHASE\s+REASON$`))
+		Expect(string(lines[1])).To(MatchRegexp(`^node-done\s+test-hash\s+true\s+done\s+\S+\s+Complete\s+<none>$`))
+		Expect(string(lines[2])).To(MatchRegexp(`^node-failed\s+<none>\s+false\s+failed\s+\S+\s+Failed\s+BackoffLimitExceeded: Job has reached the specified backoff limit$`))
+	})
+
+	It("should print JSON", func() {
+		out := &bytes.Buffer{}
+		Expect(printNodes(out, progress, "json")).To(Succeed())
+		var decoded []map[string]interface{}
+		Expect(json.Unmarshal(out.Bytes(), &decoded)).To(Succeed())
+		Expect(decoded).To(HaveLen(2))
+		Expect(decoded[0]).To(HaveKeyWithValue("upToDate", true))
+		Expect(decoded[1]).To(HaveKeyWithValue("upToDate", false))
+		Expect(decoded[1]).ToNot(HaveKey("hash"))
+		Expect(decoded[1]).To(HaveKeyWithValue("jobPhase", "Failed"))
+	})
+
+	It("should print YAML", func() {
+		out := &bytes.Buffer{}
+		Expect(printNodes(out, progress, "yaml")).To(Succeed())
+		var decoded []nodeProgress
+		Expect(yaml.Unmarshal(out.Bytes(), &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(progress))
+	})
+
+	It("should print an empty list when no nodes are selected", func() {
+		out := &bytes.Buffer{}
+		Expect(printNodes(out, nil, "json")).To(Succeed())
+		Expect(out.String()).To(Equal("[]\n"))
+	})
+})
@@ -0,0 +1,65 @@
+package planctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+func pauseCommand() cli.Command {
+	return cli.Command{
+		Name:      "pause",
+		Usage:     "stop a Plan from starting Jobs on new nodes; Jobs that have already started are allowed to complete",
+		ArgsUsage: "<plan>",
+		Flags:     flags,
+		Action: func(c *cli.Context) error {
+			return setPaused(c, true)
+		},
+	}
+}
+
+func resumeCommand() cli.Command {
+	return cli.Command{
+		Name:      "resume",
+		Usage:     "allow a paused Plan to start Jobs on new nodes",
+		ArgsUsage: "<plan>",
+		Flags:     flags,
+		Action: func(c *cli.Context) error {
+			return setPaused(c, false)
+		},
+	}
+}
+
+func setPaused(c *cli.Context, paused bool) error {
+	ctx := context.Background()
+	name, err := planName(c)
+	if err != nil {
+		return err
+	}
+	cl, err := newClients(c)
+	if err != nil {
+		return err
+	}
+	// unset the field when resuming, rather than explicitly setting it to false
+	var value interface{}
+	if paused {
+		value = true
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"paused": value},
+	})
+	if err != nil {
+		return err
+	}
+	if err := cl.patchPlan(ctx, name, patch); err != nil {
+		return err
+	}
+	if paused {
+		fmt.Fprintf(c.App.Writer, "Paused %s\n", name)
+	} else {
+		fmt.Fprintf(c.App.Writer, "Resumed %s\n", name)
+	}
+	return nil
+}
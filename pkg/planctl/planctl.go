@@ -0,0 +1,267 @@
+package planctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/rancher/system-upgrade-controller/pkg/generated/clientset/versioned"
+	upgradenode "github.com/rancher/system-upgrade-controller/pkg/upgrade/node"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	"github.com/urfave/cli"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+)
+
+var flags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "kubeconfig",
+		EnvVar: "KUBECONFIG",
+		Usage:  "path to the kubeconfig file; if not set, the default loading rules are used",
+	},
+	cli.StringFlag{
+		Name:  "namespace, n",
+		Usage: "namespace of the Plan; if not set, the namespace of the current context is used",
+	},
+	cli.BoolFlag{
+		Name:  "cluster",
+		Usage: "read a ClusterPlan instead of a Plan",
+	},
+	cli.StringFlag{
+		Name:   "controller-namespace",
+		EnvVar: "SYSTEM_UPGRADE_CONTROLLER_NAMESPACE",
+		Value:  "system-upgrade",
+		Usage:  "namespace of the controller, in which Jobs for ClusterPlans are created",
+	},
+	cli.StringFlag{
+		Name:   "controller-name",
+		EnvVar: "SYSTEM_UPGRADE_CONTROLLER_NAME",
+		Value:  "system-upgrade-controller",
+		Usage:  "name of the controller, used to label Jobs",
+	},
+}
+
+// Command returns the `plan` command, with subcommands for operating on Plans without running the controller.
+func Command() cli.Command {
+	return cli.Command{
+		Name:        "plan",
+		Usage:       "inspect and operate on Plans",
+		Subcommands: Subcommands(),
+	}
+}
+
+// Subcommands returns the subcommands of the `plan` command. These are also the commands of the kubectl plugin.
+func Subcommands() []cli.Command {
+	return []cli.Command{
+		statusCommand(),
+		nodesCommand(),
+		pauseCommand(),
+		resumeCommand(),
+		approveCommand(),
+		historyCommand(),
+		renderJobCommand(),
+		dryRunCommand(),
+	}
+}
+
+// clients holds the clients and options shared by the plan subcommands.
+type clients struct {
+	kcs       kubernetes.Interface
+	upgrade   versioned.Interface
+	namespace string
+	cluster   bool
+
+	controllerNamespace string
+	controllerName      string
+}
+
+func newClients(c *cli.Context) (*clients, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = c.String("kubeconfig")
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace := c.String("namespace")
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	kcs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	upgrade, err := versioned.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &clients{
+		kcs:                 kcs,
+		upgrade:             upgrade,
+		namespace:           namespace,
+		cluster:             c.Bool("cluster"),
+		controllerNamespace: c.String("controller-namespace"),
+		controllerName:      c.String("controller-name"),
+	}, nil
+}
+
+// getPlan returns the named Plan, or ClusterPlan as a Plan in the controller namespace.
+func (cl *clients) getPlan(ctx context.Context, name string) (*upgradeapiv1.Plan, error) {
+	if cl.cluster {
+		clusterPlan, err := cl.upgrade.UpgradeV1().ClusterPlans().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return upgradeplan.FromClusterPlan(clusterPlan, cl.controllerNamespace), nil
+	}
+	return cl.upgrade.UpgradeV1().Plans(cl.namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// patchPlan applies a merge patch to the named Plan, or ClusterPlan.
+func (cl *clients) patchPlan(ctx context.Context, name string, patch []byte) error {
+	var err error
+	if cl.cluster {
+		_, err = cl.upgrade.UpgradeV1().ClusterPlans().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	} else {
+		_, err = cl.upgrade.UpgradeV1().Plans(cl.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	return err
+}
+
+// listJobs returns the Jobs created for the plan, oldest first. Jobs for a ClusterPlan are distinguished
+// from Jobs for a Plan of the same name in the controller namespace by the cluster-plan label.
+func (cl *clients) listJobs(ctx context.Context, plan *upgradeapiv1.Plan) ([]batchv1.Job, error) {
+	selector := labels.SelectorFromSet(labels.Set{upgradeapi.LabelPlan: plan.Name})
+	op := selection.DoesNotExist
+	if cl.cluster {
+		op = selection.Exists
+	}
+	requirementClusterPlan, err := labels.NewRequirement(upgradeapi.LabelClusterPlan, op, nil)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := cl.kcs.BatchV1().Jobs(plan.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.Add(*requirementClusterPlan).String()})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(jobs.Items, func(i, j int) bool {
+		return jobs.Items[i].CreationTimestamp.Before(&jobs.Items[j].CreationTimestamp)
+	})
+	return jobs.Items, nil
+}
+
+// readPlan reads a Plan or ClusterPlan from a manifest file, and resolves its latest version and hash
+// as the controller would, so that it can be evaluated before it is applied.
+func (cl *clients) readPlan(ctx context.Context, path string) (*upgradeapiv1.Plan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	typeMeta := &metav1.TypeMeta{}
+	if err := yaml.Unmarshal(b, typeMeta); err != nil {
+		return nil, err
+	}
+	var plan *upgradeapiv1.Plan
+	switch typeMeta.Kind {
+	case "Plan":
+		plan = &upgradeapiv1.Plan{}
+		if err := yaml.Unmarshal(b, plan); err != nil {
+			return nil, err
+		}
+		if plan.Namespace == "" {
+			plan.Namespace = cl.namespace
+		}
+	case "ClusterPlan":
+		clusterPlan := &upgradeapiv1.ClusterPlan{}
+		if err := yaml.Unmarshal(b, clusterPlan); err != nil {
+			return nil, err
+		}
+		plan = upgradeplan.FromClusterPlan(clusterPlan, cl.controllerNamespace)
+	default:
+		return nil, fmt.Errorf("%s does not contain a Plan or ClusterPlan", path)
+	}
+
+	plan.Status.LatestVersion = upgradeplan.MungeVersion(plan.Spec.Version)
+	if plan.Spec.Version == "" && plan.Spec.Channel != "" {
+		systemNS, err := cl.kcs.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		latest, err := upgradeplan.ResolveChannel(ctx, plan.Spec.Channel, "", string(systemNS.UID))
+		if err != nil {
+			return nil, err
+		}
+		plan.Status.LatestVersion = upgradeplan.MungeVersion(latest)
+	}
+	secrets, err := cl.kcs.CoreV1().Secrets(plan.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range secrets.Items {
+		if err := indexer.Add(&secrets.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+	secretCache := generic.NewCache[*corev1.Secret](indexer, corev1.Resource("secrets"))
+	if err := upgradeplan.Validate(plan, secretCache); err != nil {
+		return nil, err
+	}
+	plan.Status, err = upgradeplan.DigestStatus(plan, secretCache)
+	return plan, err
+}
+
+// planName returns the name of the plan from the command arguments.
+func planName(c *cli.Context) (string, error) {
+	if c.NArg() != 1 {
+		return "", cli.NewExitError("a plan name is required", 1)
+	}
+	return c.Args().First(), nil
+}
+
+// nodeCache returns a NodeCache over the nodes currently in the cluster, along with its indexer
+// so that the cached nodes can be modified.
+func (cl *clients) nodeCache(ctx context.Context) (*generic.NonNamespacedCache[*corev1.Node], cache.Indexer, error) {
+	nodes, err := cl.kcs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i := range nodes.Items {
+		if err := indexer.Add(&nodes.Items[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return generic.NewNonNamespacedCache[*corev1.Node](indexer, corev1.Resource("nodes")), indexer, nil
+}
+
+// nodeLoads returns the number of Pods on each node that have not finished and are not owned by a DaemonSet.
+// Pods are only listed once, so every batch of a dry run is ordered by the current loads.
+func (cl *clients) nodeLoads(ctx context.Context) upgradeplan.NodeLoads {
+	var loads map[string]int
+	return func() (map[string]int, error) {
+		if loads != nil {
+			return loads, nil
+		}
+		pods, err := cl.kcs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		loads = upgradenode.Loads(pods.Items)
+		return loads, nil
+	}
+}
@@ -0,0 +1,13 @@
+package planctl
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlanctl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Planctl Suite")
+}
@@ -0,0 +1,53 @@
+package planctl
+
+import (
+	"context"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	"github.com/urfave/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func renderJobCommand() cli.Command {
+	return cli.Command{
+		Name:      "render-job",
+		Usage:     "print the Job that would be created to apply the latest version of a Plan on a node",
+		ArgsUsage: "<plan>",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "node",
+				Usage: "name of the node to render the Job for (required)",
+			},
+		}, flags...),
+		Action: renderJob,
+	}
+}
+
+func renderJob(c *cli.Context) error {
+	ctx := context.Background()
+	name, err := planName(c)
+	if err != nil {
+		return err
+	}
+	if c.String("node") == "" {
+		return cli.NewExitError("--node is required", 1)
+	}
+	cl, err := newClients(c)
+	if err != nil {
+		return err
+	}
+	plan, err := cl.getPlan(ctx, name)
+	if err != nil {
+		return err
+	}
+	node, err := cl.kcs.CoreV1().Nodes().Get(ctx, c.String("node"), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	job := upgradejob.New(plan, node, cl.controllerNamespace, cl.controllerName)
+	if cl.cluster {
+		job.Labels[upgradeapi.LabelClusterPlan] = plan.Name
+	}
+	return printJob(c.App.Writer, job)
+}
@@ -0,0 +1,226 @@
+package planctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	upgradenode "github.com/rancher/system-upgrade-controller/pkg/upgrade/node"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	"github.com/urfave/cli"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeState is the progress of a plan on a node, as reported by the status subcommand.
+type nodeState string
+
+const (
+	nodeDone     nodeState = "done"
+	nodeApplying nodeState = "applying"
+	nodePending  nodeState = "pending"
+	nodeFailed   nodeState = "failed"
+	nodeDisabled nodeState = "disabled"
+	nodeSkipped  nodeState = "skipped"
+)
+
+// nodeProgress is the progress of a plan on a single node, along with the Job for the latest hash, if any.
+// The failure reason is the reason and message of the Failed condition of the Job.
+type nodeProgress struct {
+	Node          string    `json:"node"`
+	Hash          string    `json:"hash,omitempty"`
+	UpToDate      bool      `json:"upToDate"`
+	State         nodeState `json:"state"`
+	Job           string    `json:"job,omitempty"`
+	JobPhase      string    `json:"jobPhase,omitempty"`
+	FailureReason string    `json:"failureReason,omitempty"`
+
+	job *batchv1.Job
+}
+
+func statusCommand() cli.Command {
+	return cli.Command{
+		Name:      "status",
+		Usage:     "print the progress of a Plan on the nodes that it selects",
+		ArgsUsage: "<plan>",
+		Flags:     flags,
+		Action:    status,
+	}
+}
+
+func status(c *cli.Context) error {
+	plan, progress, err := loadProgress(context.Background(), c)
+	if err != nil {
+		return err
+	}
+	printStatus(c.App.Writer, plan, progress)
+	return nil
+}
+
+// loadProgress gets the plan named by the command arguments, and its progress on the nodes that it selects.
+func loadProgress(ctx context.Context, c *cli.Context) (*upgradeapiv1.Plan, []nodeProgress, error) {
+	name, err := planName(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	cl, err := newClients(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := cl.getPlan(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	selector, err := upgradeplan.NodeSelector(plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes, err := cl.kcs.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, nil, err
+	}
+	jobs, err := cl.listJobs(ctx, plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan, planProgress(plan, nodes.Items, jobs, cl.controllerNamespace, cl.controllerName), nil
+}
+
+// planProgress returns the progress of the plan on each of the nodes, in order. Nodes are done once they are labeled
+// with the latest hash, or for plans that only reboot, once they no longer require a reboot. A node is failed if the
+// Job for the latest hash has failed, even if it is still listed as applying, or if the plan has given up on it, and
+// skipped if the plan has skipped it.
+func planProgress(plan *upgradeapiv1.Plan, nodes []corev1.Node, jobs []batchv1.Job, controllerNamespace, controllerName string) []nodeProgress {
+	jobsByName := map[string]*batchv1.Job{}
+	for i := range jobs {
+		jobsByName[jobs[i].Name] = &jobs[i]
+	}
+	applying := applyingNodes(plan, nodes)
+	halted := upgradeplan.HaltedNodes(plan)
+	progress := make([]nodeProgress, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		label := node.Labels[upgradejob.LabelPlanName(plan, controllerNamespace)]
+		p := nodeProgress{
+			Node:     node.Name,
+			Hash:     label,
+			UpToDate: label == plan.Status.LatestHash,
+			job:      jobsByName[upgradejob.New(plan, node, controllerNamespace, controllerName).Name],
+		}
+		if p.job != nil {
+			p.Job, p.JobPhase = p.job.Name, jobPhase(p.job, applying[node.Name])
+			if upgradejob.ConditionFailed.IsTrue(p.job) {
+				p.FailureReason = upgradejob.ConditionFailed.GetReason(p.job)
+				if message := upgradejob.ConditionFailed.GetMessage(p.job); message != "" {
+					p.FailureReason += ": " + message
+				}
+			}
+		}
+		switch {
+		case label == "disabled":
+			p.State = nodeDisabled
+		case slices.Contains(plan.Status.Skipped, node.Name):
+			p.State = nodeSkipped
+		case p.FailureReason != "" || slices.Contains(halted, node.Name):
+			p.State = nodeFailed
+		case applying[node.Name]:
+			p.State = nodeApplying
+		case upgradejob.RebootOnly(plan) && node.Labels[upgradejob.LabelRebootName(plan, controllerNamespace)] != upgradeapi.LabelRebootRequired:
+			p.State = nodeDone
+		case !upgradejob.RebootOnly(plan) && p.UpToDate:
+			p.State = nodeDone
+		default:
+			p.State = nodePending
+		}
+		progress[i] = p
+	}
+	return progress
+}
+
+func printStatus(w io.Writer, plan *upgradeapiv1.Plan, progress []nodeProgress) {
+	complete := upgradeapiv1.PlanComplete
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Plan:\t%s/%s\n", plan.Namespace, plan.Name)
+	fmt.Fprintf(tw, "Version:\t%s\n", plan.Status.LatestVersion)
+	fmt.Fprintf(tw, "Hash:\t%s\n", plan.Status.LatestHash)
+	if plan.Spec.Approval == upgradeapiv1.ApprovalManual {
+		approved := "awaiting approval"
+		if upgradeplan.Approved(plan) {
+			approved = "approved"
+		}
+		fmt.Fprintf(tw, "Approval:\t%s (%s)\n", plan.Spec.Approval, approved)
+	}
+	if plan.Spec.Paused {
+		fmt.Fprintf(tw, "Paused:\t%t\n", plan.Spec.Paused)
+	}
+	fmt.Fprintf(tw, "Complete:\t%s", complete.GetStatus(plan))
+	if reason := complete.GetReason(plan); reason != "" {
+		fmt.Fprintf(tw, " (%s)", reason)
+	}
+	if message := complete.GetMessage(plan); message != "" {
+		fmt.Fprintf(tw, ": %s", message)
+	}
+	fmt.Fprintln(tw)
+	tw.Flush()
+
+	counts := map[nodeState]int{}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tSTATE\tJOB")
+	for _, p := range progress {
+		counts[p.State]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Node, p.State, valueOrNone(p.Job))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d done, %d applying, %d pending, %d failed", counts[nodeDone], counts[nodeApplying], counts[nodePending], counts[nodeFailed])
+	if counts[nodeSkipped] > 0 {
+		fmt.Fprintf(w, ", %d skipped", counts[nodeSkipped])
+	}
+	if counts[nodeDisabled] > 0 {
+		fmt.Fprintf(w, ", %d disabled", counts[nodeDisabled])
+	}
+	fmt.Fprintln(w)
+}
+
+// applyingNodes returns the names of the nodes that the plan is applying on; the plan lists them by hostname.
+func applyingNodes(plan *upgradeapiv1.Plan, nodes []corev1.Node) map[string]bool {
+	applying := map[string]bool{}
+	for i := range nodes {
+		if slices.Contains(plan.Status.Applying, upgradenode.Hostname(&nodes[i])) {
+			applying[nodes[i].Name] = true
+		}
+	}
+	return applying
+}
+
+// jobPhase returns the phase of the Job: Complete or Failed once it has finished, Suspended if the controller has
+// suspended it until a window opens, Running if it has active pods, and otherwise Pending. Jobs are created paused,
+// and are unpaused once their node is applying, unless they are suspended; so a paused Job is only suspended if its
+// node is applying.
+func jobPhase(job *batchv1.Job, applying bool) string {
+	switch {
+	case upgradejob.ConditionComplete.IsTrue(job):
+		return "Complete"
+	case upgradejob.ConditionFailed.IsTrue(job):
+		return "Failed"
+	case applying && job.Spec.Parallelism != nil && *job.Spec.Parallelism == 0:
+		return "Suspended"
+	case job.Status.Active > 0:
+		return "Running"
+	default:
+		return "Pending"
+	}
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,130 @@
+package planctl
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Status", func() {
+	var (
+		plan  *upgradeapiv1.Plan
+		nodes []corev1.Node
+	)
+	newNode := func(name, hash string) corev1.Node {
+		node := corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{corev1.LabelHostname: name},
+			},
+		}
+		if hash != "" {
+			node.Labels[upgradeapi.LabelPlanName("test-plan")] = hash
+		}
+		return node
+	}
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade"},
+			Spec: upgradeapiv1.PlanSpec{
+				Concurrency: 2,
+				Upgrade:     &upgradeapiv1.ContainerSpec{Image: "test/image"},
+			},
+			Status: upgradeapiv1.PlanStatus{
+				LatestVersion: "v1.0.0",
+				LatestHash:    "test-hash",
+				Applying:      []string{"node-applying", "node-failed"},
+			},
+		}
+		nodes = []corev1.Node{
+			newNode("node-done", "test-hash"),
+			newNode("node-applying", "old-hash"),
+			newNode("node-failed", ""),
+			newNode("node-pending", "old-hash"),
+			newNode("node-disabled", "disabled"),
+		}
+	})
+
+	It("should report the progress of the plan on each node", func() {
+		failedJob := upgradejob.New(plan, &nodes[2], "system-upgrade", "system-upgrade-controller")
+		failedJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"}}
+		applyingJob := upgradejob.New(plan, &nodes[1], "system-upgrade", "system-upgrade-controller")
+
+		progress := planProgress(plan, nodes, []batchv1.Job{*failedJob, *applyingJob}, "system-upgrade", "system-upgrade-controller")
+		Expect(progress).To(HaveLen(5))
+		Expect(progress[0].State).To(Equal(nodeDone))
+		Expect(progress[0].UpToDate).To(BeTrue())
+		Expect(progress[0].Job).To(BeEmpty())
+		Expect(progress[1].State).To(Equal(nodeApplying))
+		Expect(progress[1].UpToDate).To(BeFalse())
+		Expect(progress[1].Hash).To(Equal("old-hash"))
+		Expect(progress[1].JobPhase).To(Equal("Pending"))
+		Expect(progress[1].Job).To(Equal(applyingJob.Name))
+		Expect(progress[2].State).To(Equal(nodeFailed))
+		Expect(progress[2].JobPhase).To(Equal("Failed"))
+		Expect(progress[2].FailureReason).To(Equal("BackoffLimitExceeded: Job has reached the specified backoff limit"))
+		Expect(progress[3].State).To(Equal(nodePending))
+		Expect(progress[4].State).To(Equal(nodeDisabled))
+
+		out := &bytes.Buffer{}
+		printStatus(out, plan, progress)
+		Expect(out.String()).To(ContainSubstring("Plan:      system-upgrade/test-plan\n"))
+		Expect(out.String()).To(ContainSubstring("1 done, 1 applying, 1 pending, 1 failed, 1 disabled\n"))
+	})
+
+	It("should report paused Jobs as suspended only once their node is applying", func() {
+		// Jobs are created paused, and only kept paused once their node is applying while the window is closed
+		suspendedJob := upgradejob.New(plan, &nodes[1], "system-upgrade", "system-upgrade-controller")
+		*suspendedJob.Spec.Parallelism = 0
+		createdJob := upgradejob.New(plan, &nodes[3], "system-upgrade", "system-upgrade-controller")
+		Expect(*createdJob.Spec.Parallelism).To(BeZero())
+
+		progress := planProgress(plan, nodes, []batchv1.Job{*suspendedJob, *createdJob}, "system-upgrade", "system-upgrade-controller")
+		Expect(progress[1].JobPhase).To(Equal("Suspended"))
+		Expect(progress[3].JobPhase).To(Equal("Pending"))
+
+		out := &bytes.Buffer{}
+		printHistory(out, []batchv1.Job{*suspendedJob, *createdJob}, applyingNodes(plan, nodes))
+		Expect(out.String()).To(MatchRegexp(suspendedJob.Name + `\s+node-applying\s+v1.0.0\s+Suspended\s`))
+		Expect(out.String()).To(MatchRegexp(createdJob.Name + `\s+node-pending\s+v1.0.0\s+Pending\s`))
+	})
+
+	It("should report nodes that the plan has skipped", func() {
+		plan.Status.Skipped = []string{"node-failed"}
+
+		progress := planProgress(plan, nodes, nil, "system-upgrade", "system-upgrade-controller")
+		Expect(progress[2].State).To(Equal(nodeSkipped))
+
+		out := &bytes.Buffer{}
+		printStatus(out, plan, progress)
+		Expect(out.String()).To(ContainSubstring("1 done, 1 applying, 1 pending, 0 failed, 1 skipped, 1 disabled\n"))
+	})
+
+	It("should report nodes that the plan has given up on as failed once their Job is gone", func() {
+		plan.Spec.Retry = &upgradeapiv1.RetrySpec{MaxAttempts: 1}
+		plan.Status.Applying = []string{"node-applying"}
+		plan.Status.Failures = []upgradeapiv1.NodeFailure{{Node: "node-failed", Hash: "test-hash", Attempts: 1}}
+
+		progress := planProgress(plan, nodes, nil, "system-upgrade", "system-upgrade-controller")
+		Expect(progress[2].State).To(Equal(nodeFailed))
+	})
+
+	It("should report nodes of plans that only reboot as done once they no longer require a reboot", func() {
+		plan.Spec.Upgrade = nil
+		plan.Spec.Reboot = &upgradeapiv1.RebootSpec{Only: true}
+		plan.Status.Applying = nil
+		nodes = []corev1.Node{newNode("node-done", ""), newNode("node-pending", "")}
+		nodes[1].Labels[upgradeapi.LabelRebootName("test-plan")] = upgradeapi.LabelRebootRequired
+
+		progress := planProgress(plan, nodes, nil, "system-upgrade", "system-upgrade-controller")
+		Expect(progress[0].State).To(Equal(nodeDone))
+		Expect(progress[1].State).To(Equal(nodePending))
+	})
+})
@@ -0,0 +1,185 @@
+package budget
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// PollInterval is how often a plan that is waiting for the budget should check it again.
+const PollInterval = 15 * time.Second
+
+// waiters that have not checked the budget for this long are assumed to no longer want nodes, in case they were not
+// released; for example, because the controller missed the deletion of their plan.
+const waiterTTL = 4 * PollInterval
+
+// nodes admitted for a plan are counted against the budget for at most this long before its status lists them, so that
+// they are not given to other plans while its status is being updated.
+const admissionTTL = PollInterval
+
+// admission is the nodes newly admitted for a plan, that its status may not list yet.
+type admission struct {
+	nodes []string
+	at    time.Time
+}
+
+// waiter is a plan that is waiting for the budget to start applying on new nodes.
+type waiter struct {
+	since time.Time
+	seen  time.Time
+}
+
+// Budget limits the total number of nodes that plans are applied on at once, across all plans.
+// Plans that are waiting for the budget are given nodes in the order that they started waiting, and once any plan
+// is waiting, each plan is limited to a fair share of the budget. The nodes that plans are applying on are read from
+// their status on every call, so only the plans that are waiting, and nodes just admitted, are remembered.
+type Budget struct {
+	max int
+	now func() time.Time
+
+	mu       sync.Mutex
+	admitted map[string]admission
+	waiting  map[string]waiter
+}
+
+// New returns a Budget that allows plans to be applied on at most max nodes at once. If max is not positive,
+// nil is returned, and all nodes are admitted.
+func New(max int) *Budget {
+	if max <= 0 {
+		return nil
+	}
+	return &Budget{
+		max:      max,
+		now:      time.Now,
+		admitted: map[string]admission{},
+		waiting:  map[string]waiter{},
+	}
+}
+
+// Admit returns the nodes, out of those selected for the plan identified by key, that the plan may be applied on.
+// Nodes that the plan is already applying on are always admitted; new nodes are admitted while the budget allows.
+// Plans maps the key of each plan to the nodes that its status lists as applying, which are counted against the budget.
+// A plan that is not given all of its selected nodes is waiting, and should call Admit again after PollInterval,
+// or Release once it no longer wants new nodes.
+func (b *Budget) Admit(key string, applying, selected []string, plans map[string][]string) []string {
+	if b == nil {
+		return selected
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	for k, w := range b.waiting {
+		if _, ok := plans[k]; (!ok && k != key) || now.Sub(w.seen) > waiterTTL {
+			delete(b.waiting, k)
+		}
+	}
+
+	for k, a := range b.admitted {
+		if _, ok := plans[k]; !ok || now.Sub(a.at) > admissionTTL || k == key || containsAll(plans[k], a.nodes) {
+			delete(b.admitted, k)
+		}
+	}
+
+	// nodes that other plans are applying on, or have just been admitted for, are already counted against the budget
+	inUse := map[string]bool{}
+	active := map[string]bool{}
+	for k, nodes := range plans {
+		if k == key {
+			continue
+		}
+		nodes = append(slices.Clone(nodes), b.admitted[k].nodes...)
+		if len(nodes) == 0 {
+			continue
+		}
+		active[k] = true
+		for _, node := range nodes {
+			inUse[node] = true
+		}
+	}
+
+	var admitted, pending []string
+	for _, node := range selected {
+		if slices.Contains(applying, node) {
+			admitted = append(admitted, node)
+		} else {
+			pending = append(pending, node)
+		}
+	}
+	held := len(admitted)
+	for _, node := range admitted {
+		inUse[node] = true
+	}
+
+	// each plan that has been waiting longer than this one reserves a node, so that it is not starved
+	self, isWaiting := b.waiting[key]
+	reserved, waiting := 0, 0
+	for k, w := range b.waiting {
+		if k == key {
+			continue
+		}
+		active[k] = true
+		waiting++
+		if !isWaiting || w.since.Before(self.since) {
+			reserved++
+		}
+	}
+	available := b.max - len(inUse) - reserved
+
+	// once other plans are waiting, the budget is shared between all plans that are applying or waiting
+	if waiting > 0 {
+		share := max(1, b.max/(len(active)+1))
+		available = min(available, share-held)
+	}
+
+	for _, node := range pending {
+		switch {
+		case inUse[node]:
+			admitted = append(admitted, node)
+		case available > 0:
+			admitted = append(admitted, node)
+			available--
+		}
+	}
+	// keep the order in which the nodes were selected
+	admitted = slices.DeleteFunc(slices.Clone(selected), func(node string) bool {
+		return !slices.Contains(admitted, node)
+	})
+
+	if added := slices.DeleteFunc(slices.Clone(admitted), func(node string) bool {
+		return slices.Contains(applying, node)
+	}); len(added) > 0 {
+		b.admitted[key] = admission{nodes: added, at: now}
+	}
+	if len(admitted) < len(selected) {
+		if !isWaiting {
+			self.since = now
+		}
+		self.seen = now
+		b.waiting[key] = self
+	} else {
+		delete(b.waiting, key)
+	}
+	return admitted
+}
+
+// Release records that the plan identified by key is no longer waiting for the budget; for example, because it has
+// been paused or deleted. Nodes that the plan is still applying on remain counted against the budget by other plans.
+func (b *Budget) Release(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.waiting, key)
+}
+
+// containsAll returns true if all of the nodes are in the list.
+func containsAll(list, nodes []string) bool {
+	for _, node := range nodes {
+		if !slices.Contains(list, node) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+package budget
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBudget(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Budget Suite")
+}
@@ -0,0 +1,141 @@
+package budget
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Budget", func() {
+	var (
+		b   *Budget
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+		b = New(2)
+		b.now = func() time.Time { return now }
+	})
+
+	It("should admit all nodes when there is no limit", func() {
+		Expect(New(0).Admit("a", nil, []string{"n1", "n2", "n3"}, nil)).To(Equal([]string{"n1", "n2", "n3"}))
+	})
+
+	It("should limit the total number of nodes across plans", func() {
+		plans := map[string][]string{"a": nil, "b": nil}
+		Expect(b.Admit("a", nil, []string{"n1", "n2"}, plans)).To(Equal([]string{"n1", "n2"}))
+		plans["a"] = []string{"n1", "n2"}
+		Expect(b.Admit("b", nil, []string{"n3", "n4"}, plans)).To(BeEmpty())
+	})
+
+	It("should count nodes just admitted before the status of the plan lists them", func() {
+		plans := map[string][]string{"a": nil, "b": nil}
+		Expect(b.Admit("a", nil, []string{"n1", "n2"}, plans)).To(Equal([]string{"n1", "n2"}))
+		Expect(b.Admit("b", nil, []string{"n3", "n4"}, plans)).To(BeEmpty())
+
+		// the status of plan a is never updated; the nodes are released once the admission expires
+		now = now.Add(admissionTTL + time.Second)
+		Expect(b.Admit("b", nil, []string{"n3", "n4"}, plans)).To(Equal([]string{"n3", "n4"}))
+	})
+
+	It("should count nodes from the status of plans, such as after a restart", func() {
+		plans := map[string][]string{"a": {"n1"}, "b": nil}
+		Expect(b.Admit("b", nil, []string{"n3", "n4"}, plans)).To(Equal([]string{"n3"}))
+	})
+
+	It("should always admit nodes that the plan is already applying on", func() {
+		plans := map[string][]string{"a": {"n1", "n2"}, "b": {"n3"}}
+		Expect(b.Admit("b", []string{"n3"}, []string{"n3", "n4"}, plans)).To(Equal([]string{"n3"}))
+	})
+
+	It("should not count nodes that another plan is already applying on", func() {
+		plans := map[string][]string{"a": {"n1", "n2"}, "b": nil}
+		Expect(b.Admit("b", nil, []string{"n2", "n3"}, plans)).To(Equal([]string{"n2"}))
+	})
+
+	It("should release nodes once plans complete, or are no longer listed", func() {
+		plans := map[string][]string{"a": nil, "b": nil}
+		Expect(b.Admit("a", nil, []string{"n1", "n2"}, plans)).To(HaveLen(2))
+		plans["a"] = []string{"n1", "n2"}
+		Expect(b.Admit("b", nil, []string{"n3", "n4"}, plans)).To(BeEmpty())
+
+		plans["a"] = []string{"n2"}
+		Expect(b.Admit("b", nil, []string{"n3", "n4"}, plans)).To(Equal([]string{"n3"}))
+
+		delete(plans, "a")
+		Expect(b.Admit("b", []string{"n3"}, []string{"n3", "n4"}, plans)).To(Equal([]string{"n3", "n4"}))
+	})
+
+	It("should give freed nodes to the plan that has been waiting longest", func() {
+		plans := map[string][]string{"a": nil, "b": nil, "c": nil}
+		Expect(b.Admit("a", nil, []string{"n1", "n2"}, plans)).To(Equal([]string{"n1", "n2"}))
+		plans["a"] = []string{"n1", "n2"}
+		Expect(b.Admit("b", nil, []string{"n3"}, plans)).To(BeEmpty())
+		now = now.Add(time.Second)
+		Expect(b.Admit("c", nil, []string{"n4"}, plans)).To(BeEmpty())
+
+		// n1 completes; plan a may not take the freed node while b and c are waiting
+		now = now.Add(time.Second)
+		Expect(b.Admit("a", []string{"n1", "n2"}, []string{"n2", "n5"}, plans)).To(Equal([]string{"n2"}))
+		plans["a"] = []string{"n2"}
+		Expect(b.Admit("c", nil, []string{"n4"}, plans)).To(BeEmpty())
+		Expect(b.Admit("b", nil, []string{"n3"}, plans)).To(Equal([]string{"n3"}))
+		plans["b"] = []string{"n3"}
+
+		// n2 completes; c started waiting before a
+		Expect(b.Admit("a", []string{"n2"}, []string{"n5"}, plans)).To(BeEmpty())
+		plans["a"] = nil
+		Expect(b.Admit("c", nil, []string{"n4"}, plans)).To(Equal([]string{"n4"}))
+	})
+
+	It("should share the budget between waiting plans", func() {
+		b = New(4)
+		b.now = func() time.Time { return now }
+		plans := map[string][]string{"a": nil, "b": nil}
+		Expect(b.Admit("a", nil, []string{"n1", "n2", "n3", "n4", "n5"}, plans)).To(HaveLen(4))
+		plans["a"] = []string{"n1", "n2", "n3", "n4"}
+		Expect(b.Admit("b", nil, []string{"n6", "n7"}, plans)).To(BeEmpty())
+
+		// two of the nodes complete; plan a is held to its share of the budget
+		Expect(b.Admit("a", []string{"n1", "n2", "n3", "n4"}, []string{"n3", "n4", "n5"}, plans)).To(Equal([]string{"n3", "n4"}))
+		plans["a"] = []string{"n3", "n4"}
+		Expect(b.Admit("b", nil, []string{"n6", "n7"}, plans)).To(Equal([]string{"n6", "n7"}))
+	})
+
+	It("should release waiting plans", func() {
+		plans := map[string][]string{"a": {"n1"}, "b": nil, "c": nil}
+		Expect(b.Admit("b", nil, []string{"n2", "n3"}, plans)).To(Equal([]string{"n2"}))
+		plans["b"] = []string{"n2"}
+		Expect(b.Admit("c", nil, []string{"n4"}, plans)).To(BeEmpty())
+
+		// plan b is paused while waiting, so its place is given to c once a completes
+		b.Release("b")
+		plans["a"] = nil
+		Expect(b.Admit("c", nil, []string{"n4"}, plans)).To(Equal([]string{"n4"}))
+	})
+
+	It("should forget waiting plans that are no longer listed", func() {
+		plans := map[string][]string{"a": {"n1"}, "b": nil, "c": nil}
+		Expect(b.Admit("b", nil, []string{"n2", "n3"}, plans)).To(Equal([]string{"n2"}))
+		plans["b"] = []string{"n2"}
+		Expect(b.Admit("c", nil, []string{"n3"}, plans)).To(BeEmpty())
+
+		// plan b is deleted; it no longer reserves a node ahead of plan c
+		delete(plans, "b")
+		Expect(b.Admit("c", nil, []string{"n3"}, plans)).To(Equal([]string{"n3"}))
+	})
+
+	It("should forget waiting plans that stop checking the budget", func() {
+		plans := map[string][]string{"a": nil, "b": nil, "c": nil}
+		Expect(b.Admit("a", nil, []string{"n1", "n2"}, plans)).To(HaveLen(2))
+		plans["a"] = []string{"n1", "n2"}
+		Expect(b.Admit("b", nil, []string{"n3"}, plans)).To(BeEmpty())
+
+		now = now.Add(waiterTTL + time.Second)
+		Expect(b.Admit("a", []string{"n1", "n2"}, []string{"n2"}, plans)).To(Equal([]string{"n2"}))
+		plans["a"] = []string{"n2"}
+		Expect(b.Admit("c", nil, []string{"n4"}, plans)).To(Equal([]string{"n4"}))
+	})
+})
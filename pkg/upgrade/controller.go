@@ -2,18 +2,28 @@ package upgrade
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
+	"slices"
+	"sync"
 	"time"
 
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
 	"github.com/rancher/system-upgrade-controller/pkg/crds"
 	upgradectl "github.com/rancher/system-upgrade-controller/pkg/generated/controllers/upgrade.cattle.io"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/budget"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/notify"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/tracing"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/webhook"
 	"github.com/rancher/system-upgrade-controller/pkg/version"
 	"github.com/rancher/wrangler/v3/pkg/apply"
 	"github.com/rancher/wrangler/v3/pkg/crd"
-	batchctl "github.com/rancher/wrangler/v3/pkg/generated/controllers/batch"
 	corectl "github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
+	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/v3/pkg/leader"
 	"github.com/rancher/wrangler/v3/pkg/schemes"
 	"github.com/rancher/wrangler/v3/pkg/start"
@@ -21,23 +31,52 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 )
 
 const (
 	// readyDuration time to wait for CRDs to be ready.
 	readyDuration = time.Minute * 1
+	// rebootPollInterval time to wait between checks for a Node to come back from a reboot.
+	rebootPollInterval = time.Second * 15
+	// webhookReadHeaderTimeout time to wait for the headers of webhook requests.
+	webhookReadHeaderTimeout = time.Second * 10
+	// webhookCABundleInterval time to wait between checks of the caBundle of the webhook configurations.
+	webhookCABundleInterval = time.Minute * 1
+	// webhookCertSecretSuffix suffix of the name of the Secret holding the self-signed webhook certificate.
+	webhookCertSecretSuffix = "-webhook-tls"
+	// tracingShutdownTimeout time to wait for spans to be exported when the controller stops.
+	tracingShutdownTimeout = time.Second * 5
+	// failureLogLines number of lines of the logs of a failed container that are reported.
+	failureLogLines = 20
+	// failureLogBytes maximum length of the logs of a failed container that are reported.
+	failureLogBytes = 2048
+	// failureMessageBytes maximum length of the termination message of a failed container that is reported.
+	failureMessageBytes = 1024
+	// archiveLogBytes maximum length of the logs of a Job Pod that are archived to a ConfigMap, which is limited to 1MiB.
+	archiveLogBytes = 768 * 1024
 )
 
 var (
 	ErrPlanNotReady                = errors.New("plan is not valid and resolved")
 	ErrOutsideWindow               = errors.New("current time is not within configured window")
+	ErrInBlackout                  = errors.New("current time is within configured blackout")
+	ErrAwaitingApproval            = errors.New("latest hash has not been approved")
+	ErrNotBefore                   = errors.New("current time is before configured notBefore")
+	ErrPaused                      = errors.New("plan is paused")
+	ErrExpired                     = errors.New("current time is after configured notAfter")
+	ErrWaitingForBudget            = errors.New("maximum number of concurrent nodes across plans has been reached")
+	ErrClusterPlanConflict         = errors.New("cluster plan has the same name as a plan in the controller namespace")
 	ErrControllerNameRequired      = errors.New("controller name is required")
 	ErrControllerNamespaceRequired = errors.New("controller namespace is required")
+	ErrUnsupportedLogFormat        = errors.New("unsupported log format: must be text or json")
 )
 
 type Controller struct {
@@ -48,18 +87,148 @@ type Controller struct {
 	cfg *rest.Config
 	kcs *kubernetes.Clientset
 
-	clusterID   string
-	leaderElect bool
+	clusterID      string
+	leaderElect    bool
+	planNamespaces []string
+	webhookPort    int
+	webhookCertDir string
+	archiveLogs    bool
 
-	coreFactory    *corectl.Factory
-	batchFactory   *batchctl.Factory
-	upgradeFactory *upgradectl.Factory
+	maxConcurrentNodes int
+	budget             *budget.Budget
+
+	resync          time.Duration
+	now             func() time.Time
+	podLoads        cache.SharedIndexInformer
+	podLoadsErr     error
+	podLoadsStarted sync.Once
+
+	notifyEndpoints   []notify.Endpoint
+	notifyAllowedURLs []string
+	cloudEventsSink   *notify.Endpoint
+	notifier          *notify.Notifier
+
+	otlpEndpoint string
+	otlpInsecure bool
+	tracer       *tracing.Tracer
+
+	coreFactory        *corectl.Factory
+	webhookFactories   map[string]*corectl.Factory
+	namespaceFactories map[string]*namespaceFactory
+	upgradeFactory     *upgradectl.Factory
 
 	apply    apply.Apply
 	recorder record.EventRecorder
 }
 
-func NewController(cfg *rest.Config, namespace, name, nodeName string, leaderElect bool, resync time.Duration) (ctl *Controller, err error) {
+// NewLogFormatter returns the formatter for log lines in the given format, which is either text or json.
+func NewLogFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "text":
+		return &logrus.TextFormatter{}, nil
+	case "json":
+		return &logrus.JSONFormatter{}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedLogFormat, format)
+}
+
+// Option configures optional behavior of the Controller.
+type Option func(*Controller)
+
+// WithPlanNamespaces sets the namespaces in which Plans are watched; Jobs are created, and Secrets resolved, in the Plan's namespace.
+// If not set, only Plans in the controller namespace are watched. If any namespace is "*", Plans in all namespaces are watched.
+func WithPlanNamespaces(namespaces ...string) Option {
+	return func(ctl *Controller) {
+		for _, namespace := range namespaces {
+			if namespace == "*" {
+				ctl.planNamespaces = []string{metav1.NamespaceAll}
+				return
+			}
+			if namespace != "" && !slices.Contains(ctl.planNamespaces, namespace) {
+				ctl.planNamespaces = append(ctl.planNamespaces, namespace)
+			}
+		}
+	}
+}
+
+// WithWebhook serves the admission webhooks for Plans and ClusterPlans on the given port, with the serving certificate
+// loaded from the given directory. If the directory does not contain a certificate, a self-signed certificate is created.
+func WithWebhook(port int, certDir string) Option {
+	return func(ctl *Controller) {
+		ctl.webhookPort = port
+		ctl.webhookCertDir = certDir
+	}
+}
+
+// WithNotifications sends events emitted for all Plans and ClusterPlans to the given URLs, in addition to the endpoints
+// configured for each plan. If secretName is set, credentials are read from the Secret in the controller namespace.
+func WithNotifications(secretName string, urls ...string) Option {
+	return func(ctl *Controller) {
+		for _, url := range urls {
+			if url == "" {
+				continue
+			}
+			ctl.notifyEndpoints = append(ctl.notifyEndpoints, notify.Endpoint{
+				NotificationSpec: upgradeapiv1.NotificationSpec{URL: url, SecretName: secretName},
+				SecretNamespace:  ctl.Namespace,
+			})
+		}
+	}
+}
+
+// WithNotificationAllowedURLs allows notifications configured for Plans outside the controller namespace to be sent to,
+// or under, the given URLs. Such notifications are not sent to other URLs, so that tenants cannot make the controller
+// send requests to arbitrary hosts.
+func WithNotificationAllowedURLs(urls ...string) Option {
+	return func(ctl *Controller) {
+		for _, url := range urls {
+			if url != "" {
+				ctl.notifyAllowedURLs = append(ctl.notifyAllowedURLs, url)
+			}
+		}
+	}
+}
+
+// WithCloudEvents sends CloudEvents for the upgrade lifecycle of all Plans and ClusterPlans to the given sink URL.
+// If secretName is set, credentials are read from the Secret in the controller namespace.
+func WithCloudEvents(sink, secretName string) Option {
+	return func(ctl *Controller) {
+		if sink == "" {
+			return
+		}
+		ctl.cloudEventsSink = &notify.Endpoint{
+			NotificationSpec: upgradeapiv1.NotificationSpec{URL: sink, SecretName: secretName},
+			SecretNamespace:  ctl.Namespace,
+		}
+	}
+}
+
+// WithJobLogArchive archives the end of the logs of the Pods of failed Jobs to ConfigMaps in the Job namespace.
+// The ConfigMaps are owned by the Plan, so they are deleted along with it.
+func WithJobLogArchive(enabled bool) Option {
+	return func(ctl *Controller) {
+		ctl.archiveLogs = enabled
+	}
+}
+
+// WithTracing exports OpenTelemetry spans for the rollout of each Plan hash to the OTLP gRPC collector at the given
+// endpoint, which is either host:port or a URL. If insecure is set, TLS is not used for host:port endpoints.
+func WithTracing(endpoint string, insecure bool) Option {
+	return func(ctl *Controller) {
+		ctl.otlpEndpoint = endpoint
+		ctl.otlpInsecure = insecure
+	}
+}
+
+// WithMaxConcurrentNodes limits the total number of nodes that Plans and ClusterPlans are applied on at once.
+// Once the limit is reached, plans wait for nodes applying other plans to complete, and are given nodes in turn.
+func WithMaxConcurrentNodes(max int) Option {
+	return func(ctl *Controller) {
+		ctl.maxConcurrentNodes = max
+	}
+}
+
+func NewController(cfg *rest.Config, namespace, name, nodeName string, leaderElect bool, resync time.Duration, opts ...Option) (ctl *Controller, err error) {
 	if namespace == "" {
 		return nil, ErrControllerNamespaceRequired
 	}
@@ -87,12 +256,29 @@ func NewController(cfg *rest.Config, namespace, name, nodeName string, leaderEle
 		NodeName:    nodeName,
 		cfg:         cfg,
 		leaderElect: leaderElect,
+		resync:      resync,
+		now:         time.Now,
+		tracer:      tracing.Noop(),
+	}
+	for _, opt := range opts {
+		opt(ctl)
+	}
+	ctl.budget = budget.New(ctl.maxConcurrentNodes)
+	if len(ctl.planNamespaces) == 0 {
+		ctl.planNamespaces = []string{namespace}
+	}
+
+	// Plans in more than one namespace are watched cluster-wide, and filtered by the handlers
+	factoryNamespace := namespace
+	if len(ctl.planNamespaces) > 1 || ctl.planNamespaces[0] != namespace {
+		factoryNamespace = metav1.NamespaceAll
 	}
 
 	ctl.kcs, err = kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
+	// Nodes are cluster-scoped, so they are cached in all namespaces regardless
 	ctl.coreFactory, err = corectl.NewFactoryFromConfigWithOptions(cfg, &corectl.FactoryOptions{
 		Namespace: namespace,
 		Resync:    resync,
@@ -100,15 +286,27 @@ func NewController(cfg *rest.Config, namespace, name, nodeName string, leaderEle
 	if err != nil {
 		return nil, err
 	}
-	ctl.batchFactory, err = batchctl.NewFactoryFromConfigWithOptions(cfg, &batchctl.FactoryOptions{
-		Namespace: namespace,
-		Resync:    resync,
-	})
-	if err != nil {
-		return nil, err
+	// namespaced resources are only cached in the namespaces that Plans are watched in, and the controller namespace
+	ctl.namespaceFactories = map[string]*namespaceFactory{}
+	ctl.webhookFactories = map[string]*corectl.Factory{}
+	for _, cacheNamespace := range ctl.cacheNamespaces() {
+		ctl.namespaceFactories[cacheNamespace], err = newNamespaceFactory(cfg, cacheNamespace, resync)
+		if err != nil {
+			return nil, err
+		}
+		// the webhook is served by every replica, so its caches are started outside of leader election
+		if ctl.webhookPort > 0 {
+			ctl.webhookFactories[cacheNamespace], err = corectl.NewFactoryFromConfigWithOptions(cfg, &corectl.FactoryOptions{
+				Namespace: cacheNamespace,
+				Resync:    resync,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	ctl.upgradeFactory, err = upgradectl.NewFactoryFromConfigWithOptions(cfg, &corectl.FactoryOptions{
-		Namespace: namespace,
+		Namespace: factoryNamespace,
 		Resync:    resync,
 	})
 	if err != nil {
@@ -124,6 +322,14 @@ func NewController(cfg *rest.Config, namespace, name, nodeName string, leaderEle
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: ctl.kcs.CoreV1().Events(metav1.NamespaceAll)})
 	ctl.recorder = eventBroadcaster.NewRecorder(schemes.All, corev1.EventSource{Component: ctl.Name, Host: ctl.NodeName})
 
+	// events emitted for plans are also sent to notification endpoints
+	ctl.notifier = notify.New(ctl.Namespace, ctl.Name, ctl.secretCache(), ctl.notifyEndpoints...)
+	ctl.notifier.AllowURLs(ctl.notifyAllowedURLs...)
+	ctl.recorder = ctl.notifier.Recorder(ctl.recorder)
+	if ctl.cloudEventsSink != nil {
+		ctl.notifier.WithCloudEvents(*ctl.cloudEventsSink)
+	}
+
 	return ctl, nil
 }
 
@@ -148,6 +354,12 @@ func (ctl *Controller) Start(ctx context.Context, threads int) error {
 		return err
 	}
 
+	if ctl.otlpEndpoint != "" {
+		if err := ctl.startTracing(ctx); err != nil {
+			return err
+		}
+	}
+
 	// register our handlers
 	if err := ctl.handleJobs(ctx); err != nil {
 		return err
@@ -155,20 +367,49 @@ func (ctl *Controller) Start(ctx context.Context, threads int) error {
 	if err := ctl.handleNodes(ctx); err != nil {
 		return err
 	}
+	if err := ctl.handlePods(ctx); err != nil {
+		return err
+	}
 	if err := ctl.handlePlans(ctx); err != nil {
 		return err
 	}
 	if err := ctl.handleSecrets(ctx); err != nil {
 		return err
 	}
+	if err := ctl.handleMaintenanceWindows(ctx); err != nil {
+		return err
+	}
 
 	appName := fmt.Sprintf("%s %s (%s)", version.Program, version.Version, version.GitCommit)
 	run := func(ctx context.Context) {
-		if err := start.All(ctx, threads, ctl.coreFactory, ctl.batchFactory, ctl.upgradeFactory); err != nil {
+		factories := []start.Starter{ctl.coreFactory, ctl.upgradeFactory}
+		for _, factory := range ctl.namespaceFactories {
+			factories = append(factories, factory.core, factory.apps, factory.batch)
+		}
+		if err := start.All(ctx, threads, factories...); err != nil {
 			ctl.recorder.Eventf(nodeRef, corev1.EventTypeWarning, "StartFailed", "%s failed to start controllers for %s/%s: %v", appName, ctl.Namespace, ctl.Name, err)
 			logrus.Panicf("Failed to start controllers: %v", err)
 		}
 		ctl.recorder.Eventf(nodeRef, corev1.EventTypeNormal, "Started", "%s running as %s/%s", appName, ctl.Namespace, ctl.Name)
+		go ctl.notifier.Run(ctx)
+	}
+
+	// the webhook Service selects all replicas, so the webhook is served whether or not this replica is the leader
+	if ctl.webhookPort > 0 {
+		secrets := namespacedSecretCache{}
+		var factories []start.Starter
+		for namespace, factory := range ctl.webhookFactories {
+			secrets[namespace] = factory.Core().V1().Secret().Cache()
+			factories = append(factories, factory)
+		}
+		if err := start.All(ctx, threads, factories...); err != nil {
+			return err
+		}
+		go func() {
+			if err := ctl.serveWebhook(ctx, secrets); err != nil {
+				logrus.Panicf("Failed to serve webhook: %v", err)
+			}
+		}()
 	}
 
 	if ctl.leaderElect {
@@ -181,6 +422,99 @@ func (ctl *Controller) Start(ctx context.Context, threads int) error {
 	return nil
 }
 
+// startTracing starts exporting spans to the OTLP collector, until the context is cancelled.
+func (ctl *Controller) startTracing(ctx context.Context) error {
+	provider, err := tracing.NewProvider(ctx, ctl.Name, ctl.otlpEndpoint, ctl.otlpInsecure)
+	if err != nil {
+		return err
+	}
+	ctl.tracer = tracing.New(provider)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("Failed to export spans: %v", err)
+		}
+	}()
+	logrus.Infof("Exporting spans to %s", ctl.otlpEndpoint)
+	return nil
+}
+
+// serveWebhook serves the admission webhooks until the context is cancelled.
+// The serving certificate is valid for the controller Service, which has the same name as the controller.
+// It is loaded from the webhook certificate directory if present; otherwise a self-signed certificate is
+// shared by all replicas through a Secret, and set as the caBundle of the webhook configurations.
+func (ctl *Controller) serveWebhook(ctx context.Context, secrets corectlv1.SecretCache) error {
+	serviceName := ctl.Name + "." + ctl.Namespace + ".svc"
+	cert, err := webhook.LoadCert(ctl.webhookCertDir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		certPEM, keyPEM, err := webhook.EnsureCertSecret(ctx, ctl.kcs.CoreV1().Secrets(ctl.Namespace), ctl.Name+webhookCertSecretSuffix, serviceName, ctl.Name+"."+ctl.Namespace, ctl.Name)
+		if err != nil {
+			return err
+		}
+		if cert, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			return err
+		}
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			if err := webhook.PatchCABundle(ctx, ctl.kcs.AdmissionregistrationV1(), ctl.Name, certPEM); err != nil {
+				logrus.Warnf("Failed to update caBundle of webhook configurations %s: %v", ctl.Name, err)
+			}
+		}, webhookCABundleInterval)
+	case err != nil:
+		return err
+	default:
+		logrus.Infof("Loaded webhook certificate from %s", ctl.webhookCertDir)
+	}
+	handler := webhook.New(ctl.Namespace, ctl.watchesNamespace, secrets)
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", ctl.webhookPort),
+		Handler:           handler.ServeMux(),
+		ReadHeaderTimeout: webhookReadHeaderTimeout,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logrus.Infof("Serving webhook for %s on port %d", serviceName, ctl.webhookPort)
+	if err := server.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// watchesNamespace returns true if Plans in the given namespace are handled by this controller.
+func (ctl *Controller) watchesNamespace(namespace string) bool {
+	return ctl.planNamespaces[0] == metav1.NamespaceAll || slices.Contains(ctl.planNamespaces, namespace)
+}
+
+// listPlans returns the sources of Plans in all namespaces handled by this controller, and of all ClusterPlans.
+func (ctl *Controller) listPlans() ([]planSource, error) {
+	planList, err := ctl.upgradeFactory.Upgrade().V1().Plan().Cache().List(metav1.NamespaceAll, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	clusterPlanList, err := ctl.upgradeFactory.Upgrade().V1().ClusterPlan().Cache().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]planSource, 0, len(planList)+len(clusterPlanList))
+	for _, plan := range planList {
+		if ctl.watchesNamespace(plan.Namespace) {
+			sources = append(sources, ctl.planSource(plan))
+		}
+	}
+	for _, clusterPlan := range clusterPlanList {
+		sources = append(sources, ctl.clusterPlanSource(clusterPlan))
+	}
+	return sources, nil
+}
+
 func (ctl *Controller) registerCRD(ctx context.Context) error {
 	crds, err := crds.List()
 	if err != nil {
@@ -0,0 +1,175 @@
+package upgrade
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradectl "github.com/rancher/system-upgrade-controller/pkg/generated/controllers/upgrade.cattle.io"
+	corectl "github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+var _ = Describe("Controller", func() {
+	var ctl *Controller
+
+	BeforeEach(func() {
+		var err error
+		ctl = &Controller{Namespace: "system-upgrade", planNamespaces: []string{"system-upgrade"}}
+		// the factory is never started; its caches are filled directly through the informer indexers
+		ctl.upgradeFactory, err = upgradectl.NewFactoryFromConfigWithOptions(&rest.Config{Host: "https://127.0.0.1:6443"}, &corectl.FactoryOptions{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	addPlan := func(namespace, name string) {
+		informer := ctl.upgradeFactory.Upgrade().V1().Plan().Informer()
+		Expect(informer.GetIndexer().Add(&upgradeapiv1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})).To(Succeed())
+	}
+
+	addClusterPlan := func(name string) {
+		informer := ctl.upgradeFactory.Upgrade().V1().ClusterPlan().Informer()
+		Expect(informer.GetIndexer().Add(&upgradeapiv1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+	}
+
+	keys := func() []string {
+		sources, err := ctl.listPlans()
+		Expect(err).ToNot(HaveOccurred())
+		var keys []string
+		for _, source := range sources {
+			keys = append(keys, source.key())
+		}
+		return keys
+	}
+
+	Describe("watchesNamespace", func() {
+		It("should only watch the controller namespace by default", func() {
+			Expect(ctl.watchesNamespace("system-upgrade")).To(BeTrue())
+			Expect(ctl.watchesNamespace("tenant")).To(BeFalse())
+		})
+
+		It("should watch the listed namespaces", func() {
+			ctl.planNamespaces = []string{"system-upgrade", "tenant"}
+			Expect(ctl.watchesNamespace("system-upgrade")).To(BeTrue())
+			Expect(ctl.watchesNamespace("tenant")).To(BeTrue())
+			Expect(ctl.watchesNamespace("other")).To(BeFalse())
+		})
+
+		It("should watch all namespaces", func() {
+			ctl.planNamespaces = []string{metav1.NamespaceAll}
+			Expect(ctl.watchesNamespace("system-upgrade")).To(BeTrue())
+			Expect(ctl.watchesNamespace("other")).To(BeTrue())
+		})
+	})
+
+	Describe("cacheNamespaces", func() {
+		It("should cache the controller namespace by default", func() {
+			Expect(ctl.cacheNamespaces()).To(Equal([]string{"system-upgrade"}))
+		})
+
+		It("should cache the listed namespaces and the controller namespace", func() {
+			ctl.planNamespaces = []string{"tenant", "other"}
+			Expect(ctl.cacheNamespaces()).To(Equal([]string{"system-upgrade", "tenant", "other"}))
+		})
+
+		It("should cache all namespaces", func() {
+			ctl.planNamespaces = []string{metav1.NamespaceAll}
+			Expect(ctl.cacheNamespaces()).To(Equal([]string{metav1.NamespaceAll}))
+		})
+	})
+
+	Describe("secretCache", func() {
+		addSecret := func(namespace, name string) {
+			informer := ctl.factoryFor(namespace).core.Core().V1().Secret().Informer()
+			Expect(informer.GetIndexer().Add(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}})).To(Succeed())
+		}
+
+		newFactories := func(namespaces ...string) {
+			ctl.namespaceFactories = map[string]*namespaceFactory{}
+			for _, namespace := range namespaces {
+				factory, err := newNamespaceFactory(&rest.Config{Host: "https://127.0.0.1:6443"}, namespace, 0)
+				Expect(err).ToNot(HaveOccurred())
+				ctl.namespaceFactories[namespace] = factory
+			}
+		}
+
+		It("should read Secrets from the cache of their namespace", func() {
+			newFactories("system-upgrade", "tenant")
+			addSecret("system-upgrade", "credentials")
+			addSecret("tenant", "credentials")
+			secrets := ctl.secretCache()
+
+			secret, err := secrets.Get("tenant", "credentials")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secret.Namespace).To(Equal("tenant"))
+			list, err := secrets.List(metav1.NamespaceAll, labels.Everything())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(list).To(HaveLen(2))
+		})
+
+		It("should not find Secrets in namespaces that are not cached", func() {
+			newFactories("system-upgrade")
+			_, err := ctl.secretCache().Get("other", "credentials")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should read Secrets in all namespaces from a single cache", func() {
+			newFactories(metav1.NamespaceAll)
+			addSecret("other", "credentials")
+			_, err := ctl.secretCache().Get("other", "credentials")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("listPlans", func() {
+		BeforeEach(func() {
+			addPlan("system-upgrade", "k3s-server")
+			addPlan("tenant", "k3s-server")
+			addPlan("other", "k3s-agent")
+			addClusterPlan("k3s-agent")
+		})
+
+		It("should list Plans in watched namespaces and all ClusterPlans", func() {
+			ctl.planNamespaces = []string{"system-upgrade", "tenant"}
+			Expect(keys()).To(ConsistOf(
+				"Plan/system-upgrade/k3s-server",
+				"Plan/tenant/k3s-server",
+				"ClusterPlan/system-upgrade/k3s-agent",
+			))
+		})
+
+		It("should list Plans in all namespaces", func() {
+			ctl.planNamespaces = []string{metav1.NamespaceAll}
+			Expect(keys()).To(ConsistOf(
+				"Plan/system-upgrade/k3s-server",
+				"Plan/tenant/k3s-server",
+				"Plan/other/k3s-agent",
+				"ClusterPlan/system-upgrade/k3s-agent",
+			))
+		})
+
+		It("should place ClusterPlans in the controller namespace", func() {
+			sources, err := ctl.listPlans()
+			Expect(err).ToNot(HaveOccurred())
+			var clusterPlans int
+			for _, source := range sources {
+				if source.clusterPlan {
+					clusterPlans++
+					Expect(source.plan.Namespace).To(Equal("system-upgrade"))
+					Expect(source.object).To(BeAssignableToTypeOf(&upgradeapiv1.ClusterPlan{}))
+				}
+			}
+			Expect(clusterPlans).To(Equal(1))
+		})
+
+		It("should key sources as their deletion is keyed", func() {
+			// the budget releases deleted plans by these keys
+			Expect(keys()).To(ContainElements(
+				planSourceKey("Plan", "system-upgrade/k3s-server"),
+				planSourceKey("ClusterPlan", ctl.Namespace+"/k3s-agent"),
+			))
+		})
+	})
+})
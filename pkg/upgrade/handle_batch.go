@@ -4,14 +4,23 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
 	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
 	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	upgradenode "github.com/rancher/system-upgrade-controller/pkg/upgrade/node"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/notify"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/tracing"
 	batchctlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/batch/v1"
+	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/name"
 	"github.com/sirupsen/logrus"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -23,14 +32,13 @@ import (
 
 // job events (successful completions) cause the node the job ran on to be labeled as per the plan
 func (ctl *Controller) handleJobs(ctx context.Context) error {
-	plans := ctl.upgradeFactory.Upgrade().V1().Plan()
 	nodes := ctl.coreFactory.Core().V1().Node()
-	jobs := ctl.batchFactory.Batch().V1().Job()
 
-	jobs.OnChange(ctx, ctl.Name, func(_ string, obj *batchv1.Job) (*batchv1.Job, error) {
+	handler := func(_ string, obj *batchv1.Job) (*batchv1.Job, error) {
 		if obj == nil {
 			return obj, nil
 		}
+		jobs := ctl.jobs(obj.Namespace)
 		jobSelector := labels.SelectorFromSet(labels.Set{
 			upgradeapi.LabelController: ctl.Name,
 		})
@@ -51,7 +59,7 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 			return obj, deleteJob(jobs, obj, metav1.DeletePropagationBackground)
 		}
 		// get the plan being applied
-		plan, err := plans.Get(obj.Namespace, planName, metav1.GetOptions{})
+		source, err := ctl.jobPlanSource(obj, planName)
 		switch {
 		case apierrors.IsNotFound(err):
 			// plan is gone, delete
@@ -59,20 +67,23 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 		case err != nil:
 			return obj, err
 		}
+		plan := source.plan
 		// if this job was applying a different version then just delete it
 		// this has the side-effect of only ever retaining one job per node during the TTL window
 		if planVersion != plan.Status.LatestVersion {
 			return obj, deleteJob(jobs, obj, metav1.DeletePropagationBackground)
 		}
+		logger := source.logger("jobs").WithField("job", obj.Name)
 		// trigger the plan when we're done, might free up a concurrency slot
-		logrus.Debugf("Enqueing sync of Plan %s/%s from Job %s/%s", obj.Namespace, planName, obj.Namespace, obj.Name)
-		defer plans.Enqueue(obj.Namespace, planName)
+		logger.Debug("Enqueuing sync of Plan from Job")
+		defer source.enqueue()
 		// identify the node that this job is targeting
 		nodeName, ok := obj.Labels[upgradeapi.LabelNode]
 		if !ok {
 			// malformed, just delete it and move on
 			return obj, deleteJob(jobs, obj, metav1.DeletePropagationBackground)
 		}
+		logger = logger.WithField("node", nodeName)
 		// get the node that the plan is being applied to
 		node, err := nodes.Cache().Get(nodeName)
 		switch {
@@ -82,23 +93,99 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 		case err != nil:
 			return obj, err
 		}
+		// record the plan step that the job is running, or has failed at
+		pod, err := latestJobPod(ctl.pods(obj.Namespace).Cache(), obj)
+		if err != nil {
+			return obj, err
+		}
+		if pod != nil {
+			if step := upgradejob.CurrentStep(plan, pod); step != "" && obj.Annotations[upgradeapi.AnnotationStep] != step {
+				job := obj.DeepCopy()
+				if job.Annotations == nil {
+					job.Annotations = map[string]string{}
+				}
+				job.Annotations[upgradeapi.AnnotationStep] = step
+				if job, err = jobs.Update(job); err != nil {
+					return obj, err
+				}
+				obj = job
+			}
+		}
 		// if the job has failed enqueue-or-delete it depending on the TTL window
 		if upgradejob.ConditionFailed.IsTrue(obj) {
 			failedTime := upgradejob.ConditionFailed.GetLastTransitionTime(obj)
 			if failedTime.IsZero() {
 				return obj, fmt.Errorf("condition %q missing field %q", upgradejob.ConditionFailed, "LastTransitionTime")
 			}
-			message := fmt.Sprintf("Job %s/%s failed on Node %s: %s: %s",
-				obj.Namespace, obj.Name, nodeName,
+			failedOn := "Node " + nodeName
+			if step, ok := obj.Annotations[upgradeapi.AnnotationStep]; ok {
+				failedOn += " at step " + step
+			}
+			message := fmt.Sprintf("Job %s/%s failed on %s: %s: %s",
+				obj.Namespace, obj.Name, failedOn,
 				upgradejob.ConditionFailed.GetReason(obj),
 				upgradejob.ConditionFailed.GetMessage(obj),
 			)
-			ctl.recorder.Eventf(plan, corev1.EventTypeWarning, "JobFailed", "%s", message)
+			// the termination message and logs are only read once, as the Pod may be gone by the time the Job is synced again.
+			// retried Jobs have the same name, so a failure is only new if the Job failed at a different time.
+			// the failure is only reported when it is first seen, as finished Jobs are synced again, such as once their TTL expires.
+			failure := upgradeplan.NodeFailure(plan, nodeName)
+			if failure == nil || failure.Job != obj.Name || !failure.FailedAt.Time.Equal(failedTime) {
+				hash := obj.Labels[upgradejob.LabelPlanName(plan, ctl.Namespace)]
+				attempts := int32(1)
+				if failure != nil && failure.Hash == hash {
+					attempts = max(failure.Attempts, 1) + 1
+				}
+				newFailure := ctl.jobFailure(ctx, logger, source, obj, pod, nodeName, failedTime, attempts)
+				upgradeplan.SetNodeFailure(plan, newFailure)
+				failure = &newFailure
+				failedMessage := message + retryMessage(plan, failure)
+				ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "JobFailed", "%s%s", failedMessage, failureDetails(failure))
+				ctl.cloudEvent(source, notify.CloudEventNodeFailed, nodeName, failedMessage+failureDetails(failure), failure.FailedAt.Time)
+				ctl.tracer.Job(source.tracingPlan(), obj, nodeName, hash, tracing.OutcomeFailed, failedMessage, failedTime)
+			}
+			// the job has already been deleted to retry it, and will be re-created by the generating handler
+			if failure.RetriedAt != nil {
+				if err := deleteJob(jobs, obj, metav1.DeletePropagationBackground); !apierrors.IsNotFound(err) {
+					return obj, err
+				}
+				return obj, nil
+			}
+			message += retryMessage(plan, failure)
 			upgradeapiv1.PlanComplete.SetError(plan, "JobFailed", errors.New(message))
-			if plan, err = plans.UpdateStatus(plan); err != nil {
+			// if the failure is to be retried, delete the job once the backoff has elapsed. recording the retry in the
+			// plan status causes the generating handler to re-create the job.
+			if retryAt, ok := upgradeplan.RetryAt(plan, failure); ok {
+				if interval := time.Until(retryAt); interval > 0 {
+					if err := source.updateStatus(plan); err != nil {
+						return obj, err
+					}
+					logger.Debugf("Enqueuing sync of Job in %v, waiting for retry backoff", interval)
+					jobs.EnqueueAfter(obj.Namespace, obj.Name, interval)
+					return obj, nil
+				}
+				if err := deleteJob(jobs, obj, metav1.DeletePropagationBackground); err != nil && !apierrors.IsNotFound(err) {
+					return obj, err
+				}
+				attempt := fmt.Sprintf("attempt %d of %d", failure.Attempts+1, upgradeplan.RetryMaxAttempts(plan))
+				logger.Infof("Retrying Job, %s", attempt)
+				ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "JobRetry", "Retrying Job %s/%s on Node %s, %s", obj.Namespace, obj.Name, nodeName, attempt)
+				now := metav1.Now()
+				failure.RetriedAt = &now
+				upgradeapiv1.PlanComplete.SetError(plan, "JobRetry", fmt.Errorf("Retrying Job %s/%s on Node %s, %s", obj.Namespace, obj.Name, nodeName, attempt))
+				return obj, source.updateStatus(plan)
+			}
+			// once the retry policy has given up on the node, it is no longer applying; this frees its slot in the
+			// controller-wide limit, while halted nodes still count against the plan concurrency.
+			if upgradeplan.GaveUp(plan, failure) {
+				plan.Status.Applying = slices.DeleteFunc(plan.Status.Applying, func(applying string) bool {
+					return applying == upgradenode.Hostname(node)
+				})
+			}
+			if err := source.updateStatus(plan); err != nil {
 				return obj, err
 			}
-			return obj, enqueueOrDelete(jobs, obj, failedTime)
+			return obj, enqueueOrDelete(logger, jobs, obj, failedTime)
 		}
 		// if the job has completed tag the node then enqueue-or-delete depending on the TTL window
 		if upgradejob.ConditionComplete.IsTrue(obj) {
@@ -106,8 +193,53 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 			if completeTime.IsZero() {
 				return obj, fmt.Errorf("condition %q missing field %q", upgradejob.ConditionComplete, "LastTransitionTime")
 			}
-			planLabel := upgradeapi.LabelPlanName(planName)
+			// if the job scheduled a reboot, wait for the node to come back with a new boot ID before it is labeled
+			if pod != nil && upgradejob.RebootEnabled(plan) {
+				if bootID, ok := upgradejob.ScheduledReboot(pod); ok && (node.Status.NodeInfo.BootID == bootID || !upgradenode.IsReady(node)) {
+					timeout := upgradejob.RebootTimeout(plan)
+					if interval := time.Now().Sub(completeTime); interval < timeout {
+						logger.Debugf("Enqueuing sync of Job in %v, waiting for Node to reboot", rebootPollInterval)
+						ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "RebootWaiting", "Job completed on Node %s, waiting up to %s for reboot", node.Name, timeout)
+						jobs.EnqueueAfter(obj.Namespace, obj.Name, rebootPollInterval)
+						return obj, nil
+					}
+					message := fmt.Sprintf("Node %s did not come back from reboot within %s", node.Name, timeout)
+					// the node is failed rather than rebooted again by a new Job, and is not retried until the plan hash changes;
+					// it is no longer applying, and is halted or skipped as per the node failure policy.
+					hash := obj.Labels[upgradejob.LabelPlanName(plan, ctl.Namespace)]
+					if failure := upgradeplan.NodeFailure(plan, nodeName); failure == nil || failure.Job != obj.Name || failure.Reason != upgradeplan.FailureRebootTimeout || failure.Hash != hash {
+						ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "RebootTimeout", "%s", message)
+						ctl.cloudEvent(source, notify.CloudEventNodeFailed, nodeName, message, completeTime.Add(timeout))
+						ctl.tracer.Job(source.tracingPlan(), obj, node.Name, hash, tracing.OutcomeRebootTimeout, message, time.Now(),
+							tracing.Event{Name: "JobComplete", Time: completeTime})
+						attempts := int32(1)
+						if failure != nil && failure.Hash == hash {
+							attempts = max(failure.Attempts, 1) + 1
+						}
+						upgradeplan.SetNodeFailure(plan, upgradeapiv1.NodeFailure{
+							Node:      nodeName,
+							Job:       obj.Name,
+							Hash:      hash,
+							Reason:    upgradeplan.FailureRebootTimeout,
+							Message:   message,
+							Container: upgradejob.RebootContainerName,
+							FailedAt:  metav1.NewTime(completeTime.Add(timeout)),
+							Attempts:  attempts,
+						})
+					}
+					plan.Status.Applying = slices.DeleteFunc(plan.Status.Applying, func(applying string) bool {
+						return applying == upgradenode.Hostname(node)
+					})
+					upgradeapiv1.PlanComplete.SetError(plan, "RebootTimeout", errors.New(message))
+					if err := source.updateStatus(plan); err != nil {
+						return obj, err
+					}
+					return obj, enqueueOrDelete(logger, jobs, obj, completeTime)
+				}
+			}
+			planLabel := upgradejob.LabelPlanName(plan, ctl.Namespace)
 			if planHash, ok := obj.Labels[planLabel]; ok {
+				labeled := node.Labels[planLabel] == planHash
 				var delay time.Duration
 				if plan.Spec.PostCompleteDelay != nil {
 					delay = plan.Spec.PostCompleteDelay.Duration
@@ -117,11 +249,10 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 				// the job's TTLSecondsAfterFinished is guaranteed to be set to a larger value
 				// than the plan's requested delay.
 				if interval := time.Now().Sub(completeTime); interval < delay {
-					logrus.Debugf("Enqueing sync of Job %s/%s in %v", obj.Namespace, obj.Name, delay-interval)
-					ctl.recorder.Eventf(plan, corev1.EventTypeNormal, "JobCompleteWaiting", "Job completed on Node %s, waiting %s PostCompleteDelay", node.Name, delay)
+					logger.Debugf("Enqueuing sync of Job in %v, waiting for PostCompleteDelay", delay-interval)
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "JobCompleteWaiting", "Job completed on Node %s, waiting %s PostCompleteDelay", node.Name, delay)
 					jobs.EnqueueAfter(obj.Namespace, obj.Name, delay-interval)
 				} else {
-					ctl.recorder.Eventf(plan, corev1.EventTypeNormal, "JobComplete", "Job completed on Node %s", node.Name)
 					labelVars := map[string]string{
 						"LATEST_VERSION": plan.Status.LatestVersion,
 						"LATEST_HASH":    plan.Status.LatestHash,
@@ -130,6 +261,18 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 						node.Labels[k] = expansion.Expand(v, expansion.MappingFuncFor(labelVars))
 					}
 					node.Labels[planLabel] = planHash
+					// the node no longer requires a reboot once it has been rebooted, and reboot checks reported
+					// before the Job completed are ignored, so that it is not rebooted again on stale readiness.
+					if upgradejob.RebootEnabled(plan) {
+						annotationRebooted := upgradejob.AnnotationRebootedName(plan, ctl.Namespace)
+						if rebootedAt, _ := time.Parse(time.RFC3339, node.Annotations[annotationRebooted]); rebootedAt.Before(completeTime) {
+							if node.Annotations == nil {
+								node.Annotations = map[string]string{}
+							}
+							node.Annotations[annotationRebooted] = completeTime.UTC().Format(time.RFC3339)
+							delete(node.Labels, upgradejob.LabelRebootName(plan, ctl.Namespace))
+						}
+					}
 				}
 				// mark the node as schedulable even if the delay has not elapsed, so that
 				// workloads can resume scheduling.
@@ -139,8 +282,26 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 				if node, err = nodes.Update(node); err != nil {
 					return obj, err
 				}
+				// the job is reported complete, and its span ends, when the node is first labeled with the hash
+				if !labeled && node.Labels[planLabel] == planHash {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "JobComplete", "Job completed on Node %s", node.Name)
+					ctl.cloudEvent(source, notify.CloudEventNodeCompleted, node.Name, "Job completed on Node "+node.Name, completeTime)
+					ctl.tracer.Job(source.tracingPlan(), obj, node.Name, planHash, tracing.OutcomeComplete, "", time.Now(),
+						tracing.Event{Name: "JobComplete", Time: completeTime})
+				}
+				// the node is no longer failed once the plan has been applied on it
+				if node.Labels[planLabel] == planHash && upgradeplan.ClearNodeFailure(plan, node.Name) {
+					if err := source.updateStatus(plan); err != nil {
+						return obj, err
+					}
+					if ctl.archiveLogs {
+						if err := ctl.deleteArchivedLogs(ctx, source, obj.Namespace, node.Name, ""); err != nil {
+							logger.Warnf("Failed to delete archived logs of failed Jobs on Node %s: %v", node.Name, err)
+						}
+					}
+				}
 			}
-			return obj, enqueueOrDelete(jobs, obj, completeTime)
+			return obj, enqueueOrDelete(logger, jobs, obj, completeTime)
 		}
 		// if the job is hasn't failed or completed but the job Node is not on the applying list, consider it running out-of-turn and delete it
 		if i := sort.SearchStrings(plan.Status.Applying, nodeName); i == len(plan.Status.Applying) ||
@@ -148,12 +309,241 @@ func (ctl *Controller) handleJobs(ctx context.Context) error {
 			return obj, deleteJob(jobs, obj, metav1.DeletePropagationBackground)
 		}
 		return obj, nil
-	})
+	}
+	for _, factory := range ctl.namespaceFactories {
+		factory.batch.Batch().V1().Job().OnChange(ctx, ctl.Name, handler)
+	}
+
+	return nil
+}
+
+// jobFailure returns the failure of the Job on the node, with the termination message and the last lines of the logs
+// of the container that failed. If enabled, the end of the logs of the Job Pod is archived to a ConfigMap.
+// Errors reading the logs are logged rather than returned, as the failure is still reported without them.
+func (ctl *Controller) jobFailure(ctx context.Context, logger *logrus.Entry, source planSource, job *batchv1.Job, pod *corev1.Pod, nodeName string, failedTime time.Time, attempts int32) upgradeapiv1.NodeFailure {
+	failure := upgradeapiv1.NodeFailure{
+		Node:     nodeName,
+		Job:      job.Name,
+		Hash:     job.Labels[upgradejob.LabelPlanName(source.plan, ctl.Namespace)],
+		Step:     job.Annotations[upgradeapi.AnnotationStep],
+		Reason:   upgradejob.ConditionFailed.GetReason(job),
+		Message:  upgradejob.Truncate(upgradejob.ConditionFailed.GetMessage(job), failureMessageBytes),
+		FailedAt: metav1.NewTime(failedTime),
+		Attempts: attempts,
+	}
+	if pod == nil {
+		return failure
+	}
+	if container, terminated, previous := upgradejob.FailedContainer(pod); container != "" {
+		failure.Container = container
+		failure.TerminationMessage = upgradejob.Truncate(strings.TrimSpace(terminated.Message), failureMessageBytes)
+		tailLines := int64(failureLogLines)
+		logs, err := ctl.kcs.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: container, TailLines: &tailLines, Previous: previous}).DoRaw(ctx)
+		if err != nil {
+			logger.Warnf("Failed to read logs of container %s of Pod %s: %v", container, pod.Name, err)
+		} else {
+			failure.Logs = upgradejob.TruncateTail(string(logs), failureLogBytes)
+		}
+	}
+	if ctl.archiveLogs {
+		configMapName, err := ctl.archiveJobLogs(ctx, logger, source, job, pod, attempts)
+		if err != nil {
+			logger.Warnf("Failed to archive logs of Pod %s: %v", pod.Name, err)
+		} else {
+			failure.LogsConfigMap = configMapName
+		}
+	}
+	return failure
+}
+
+// archiveJobLogs creates a ConfigMap in the Job namespace holding the end of the logs of each container of the Job Pod
+// that has started, and of the previous instance of each container that has restarted, returning its name. The
+// ConfigMap is owned by the plan, and labeled as the Job is. Retried Jobs have the same name, so the ConfigMap is named
+// for the attempt; ConfigMaps archived for earlier failures on the node are deleted once it is created.
+func (ctl *Controller) archiveJobLogs(ctx context.Context, logger *logrus.Entry, source planSource, job *batchv1.Job, pod *corev1.Pod, attempts int32) (string, error) {
+	type containerLogs struct {
+		key     string
+		options corev1.PodLogOptions
+	}
+	var containers []containerLogs
+	for _, status := range append(slices.Clone(pod.Status.InitContainerStatuses), pod.Status.ContainerStatuses...) {
+		if status.LastTerminationState.Terminated != nil {
+			containers = append(containers, containerLogs{key: status.Name + ".previous.log", options: corev1.PodLogOptions{Container: status.Name, Previous: true}})
+		}
+		if status.State.Running != nil || status.State.Terminated != nil {
+			containers = append(containers, containerLogs{key: status.Name + ".log", options: corev1.PodLogOptions{Container: status.Name}})
+		}
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name.SafeConcatName(job.Name, "logs", strconv.Itoa(int(attempts))),
+			Namespace:       job.Namespace,
+			Labels:          map[string]string{},
+			OwnerReferences: []metav1.OwnerReference{ownerReference(source)},
+		},
+		Data: map[string]string{},
+	}
+	for _, label := range []string{upgradeapi.LabelController, upgradeapi.LabelPlan, upgradeapi.LabelClusterPlan, upgradeapi.LabelNode, upgradeapi.LabelVersion} {
+		if value, ok := job.Labels[label]; ok {
+			configMap.Labels[label] = value
+		}
+	}
+	for _, container := range containers {
+		logs, err := ctl.readLogsTail(ctx, pod, &container.options, archiveLogBytes/len(containers))
+		if err != nil {
+			return "", err
+		}
+		configMap.Data[container.key] = logs
+	}
+	if _, err := ctl.kcs.CoreV1().ConfigMaps(configMap.Namespace).Create(ctx, configMap, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+	if err := ctl.deleteArchivedLogs(ctx, source, job.Namespace, job.Labels[upgradeapi.LabelNode], configMap.Name); err != nil {
+		logger.Warnf("Failed to delete archived logs of earlier Jobs on Node %s: %v", job.Labels[upgradeapi.LabelNode], err)
+	}
+	return configMap.Name, nil
+}
+
+// readLogsTail returns the last max bytes of the logs of the Pod, starting at a line boundary if possible.
+// The logs are streamed, so that only the end of long logs is held in memory.
+func (ctl *Controller) readLogsTail(ctx context.Context, pod *corev1.Pod, options *corev1.PodLogOptions, max int) (string, error) {
+	stream, err := ctl.kcs.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	// one byte more than the limit is kept, so that truncated logs are marked as such
+	tail, err := readTail(stream, max+1)
+	if err != nil {
+		return "", err
+	}
+	return upgradejob.TruncateTail(string(tail), max), nil
+}
 
+// readTail returns the last max bytes read from the reader.
+func readTail(r io.Reader, max int) ([]byte, error) {
+	var (
+		tail  = make([]byte, 0, 2*max)
+		chunk = make([]byte, 32*1024)
+	)
+	for {
+		n, err := r.Read(chunk)
+		tail = append(tail, chunk[:n]...)
+		if len(tail) > max {
+			tail = append(tail[:0], tail[len(tail)-max:]...)
+		}
+		if errors.Is(err, io.EOF) {
+			return tail, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// deleteArchivedLogs deletes the ConfigMaps that the logs of Jobs for the plan on the node were archived to, other
+// than the named one, if any. Only the logs of the latest failure on each node are kept, and none once the plan has
+// been applied on it.
+func (ctl *Controller) deleteArchivedLogs(ctx context.Context, source planSource, namespace, nodeName, keep string) error {
+	selector := labels.Set{
+		upgradeapi.LabelController: ctl.Name,
+		upgradeapi.LabelPlan:       source.plan.Name,
+		upgradeapi.LabelNode:       nodeName,
+	}
+	configMaps, err := ctl.kcs.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	for _, configMap := range configMaps.Items {
+		owned := slices.ContainsFunc(configMap.OwnerReferences, func(ref metav1.OwnerReference) bool {
+			return ref.UID == source.plan.UID
+		})
+		if configMap.Name == keep || !owned {
+			continue
+		}
+		if err := ctl.kcs.CoreV1().ConfigMaps(namespace).Delete(ctx, configMap.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
 	return nil
 }
 
-func enqueueOrDelete(jobController batchctlv1.JobController, job *batchv1.Job, lastTransitionTime time.Time) error {
+// ownerReference returns a reference to the Plan or ClusterPlan that the plan is reconciled from.
+func ownerReference(source planSource) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: upgradeapiv1.SchemeGroupVersion.String(),
+		Kind:       source.kind(),
+		Name:       source.plan.Name,
+		UID:        source.plan.UID,
+	}
+}
+
+// retryMessage returns a description of whether the failure is retried, as per the plan retry policy, to be appended
+// to the event message. Nothing is returned for plans without a retry policy.
+func retryMessage(plan *upgradeapiv1.Plan, failure *upgradeapiv1.NodeFailure) string {
+	if plan.Spec.Retry == nil {
+		return ""
+	}
+	maxAttempts := upgradeplan.RetryMaxAttempts(plan)
+	if retryAt, ok := upgradeplan.RetryAt(plan, failure); ok {
+		return fmt.Sprintf("; retrying after %s, attempt %d of %d", retryAt.UTC().Format(time.RFC3339), failure.Attempts+1, maxAttempts)
+	}
+	if failure.Attempts < maxAttempts {
+		return fmt.Sprintf("; not retrying %s failure after %d of %d attempts", upgradeplan.FailureKind(failure), failure.Attempts, maxAttempts)
+	}
+	return fmt.Sprintf("; not retrying after %d of %d attempts", failure.Attempts, maxAttempts)
+}
+
+// failureDetails returns the termination message and logs of the failure, to be appended to the event message.
+func failureDetails(failure *upgradeapiv1.NodeFailure) string {
+	var details string
+	if failure.TerminationMessage != "" {
+		details += fmt.Sprintf("\nTermination message of container %s: %s", failure.Container, failure.TerminationMessage)
+	}
+	if failure.Logs != "" {
+		details += fmt.Sprintf("\nLogs of container %s:\n%s", failure.Container, failure.Logs)
+	}
+	if failure.LogsConfigMap != "" {
+		details += fmt.Sprintf("\nLogs archived to ConfigMap %s", failure.LogsConfigMap)
+	}
+	return details
+}
+
+// jobPlanSource returns the source of the Plan that the Job is applying.
+// Jobs for ClusterPlans are labeled with the name of the ClusterPlan.
+func (ctl *Controller) jobPlanSource(job *batchv1.Job, planName string) (planSource, error) {
+	if clusterPlanName, ok := job.Labels[upgradeapi.LabelClusterPlan]; ok {
+		clusterPlan, err := ctl.upgradeFactory.Upgrade().V1().ClusterPlan().Get(clusterPlanName, metav1.GetOptions{})
+		if err != nil {
+			return planSource{}, err
+		}
+		return ctl.clusterPlanSource(clusterPlan), nil
+	}
+	plan, err := ctl.upgradeFactory.Upgrade().V1().Plan().Get(job.Namespace, planName, metav1.GetOptions{})
+	if err != nil {
+		return planSource{}, err
+	}
+	return ctl.planSource(plan), nil
+}
+
+// latestJobPod returns the most recently created Pod for the Job, or nil if there are none.
+func latestJobPod(podCache corectlv1.PodCache, job *batchv1.Job) (*corev1.Pod, error) {
+	pods, err := podCache.List(job.Namespace, labels.SelectorFromSet(labels.Set{
+		batchv1.JobNameLabel: job.Name,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	var latest *corev1.Pod
+	for _, pod := range pods {
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+	return latest, nil
+}
+
+func enqueueOrDelete(logger *logrus.Entry, jobController batchctlv1.JobController, job *batchv1.Job, lastTransitionTime time.Time) error {
 	var ttlSecondsAfterFinished time.Duration
 
 	if job.Spec.TTLSecondsAfterFinished == nil {
@@ -169,7 +559,7 @@ func enqueueOrDelete(jobController batchctlv1.JobController, job *batchv1.Job, l
 		ttlSecondsAfterFinished = time.Second * time.Duration(*job.Spec.TTLSecondsAfterFinished)
 	}
 	if interval := time.Now().Sub(lastTransitionTime); interval < ttlSecondsAfterFinished {
-		logrus.Debugf("Enqueing sync of Job %s/%s in %v", job.Namespace, job.Name, ttlSecondsAfterFinished-interval)
+		logger.Debugf("Enqueuing sync of Job in %v, waiting for TTLSecondsAfterFinished", ttlSecondsAfterFinished-interval)
 		jobController.EnqueueAfter(job.Namespace, job.Name, ttlSecondsAfterFinished-interval)
 		return nil
 	}
@@ -0,0 +1,21 @@
+package upgrade
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("readTail", func() {
+	It("should return short logs unchanged", func() {
+		Expect(readTail(strings.NewReader("line 1\nline 2\n"), 100)).To(Equal([]byte("line 1\nline 2\n")))
+	})
+
+	It("should keep the end of long logs", func() {
+		logs := strings.Repeat("a", 100*1024) + "the end\n"
+		tail, err := readTail(strings.NewReader(logs), 8)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(tail)).To(Equal("the end\n"))
+	})
+})
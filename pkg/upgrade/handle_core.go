@@ -2,31 +2,37 @@ package upgrade
 
 import (
 	"context"
+	"time"
 
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	upgradereboot "github.com/rancher/system-upgrade-controller/pkg/upgrade/reboot"
 	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
 // node events with labels that match a plan's selectors (potentially) trigger that plan
 func (ctl *Controller) handleNodes(ctx context.Context) error {
-	plans := ctl.upgradeFactory.Upgrade().V1().Plan()
-
 	ctl.coreFactory.Core().V1().Node().OnChange(ctx, ctl.Name, func(_ string, obj *corev1.Node) (*corev1.Node, error) {
 		if obj == nil {
 			return obj, nil
 		}
-		planList, err := plans.Cache().List(ctl.Namespace, labels.Everything())
+		sources, err := ctl.listPlans()
 		if err != nil {
 			return obj, err
 		}
-		for _, plan := range planList {
+		for _, source := range sources {
+			plan := source.plan
 			if selector, err := metav1.LabelSelectorAsSelector(plan.Spec.NodeSelector); err != nil {
 				return obj, err
 			} else if selector.Matches(labels.Set(obj.Labels)) {
-				logrus.Debugf("Enqueing sync of Plan %s/%s from Node %s", plan.Namespace, plan.Name, obj.Name)
-				plans.Enqueue(plan.Namespace, plan.Name)
+				source.logger("nodes").WithField("node", obj.Name).Debug("Enqueuing sync of Plan from Node")
+				source.enqueue()
 			}
 		}
 		return obj, nil
@@ -37,29 +43,110 @@ func (ctl *Controller) handleNodes(ctx context.Context) error {
 
 // secret events referred to by a plan (potentially) trigger that plan
 func (ctl *Controller) handleSecrets(ctx context.Context) error {
-	plans := ctl.upgradeFactory.Upgrade().V1().Plan()
-
-	ctl.coreFactory.Core().V1().Secret().OnChange(ctx, ctl.Name, func(_ string, obj *corev1.Secret) (*corev1.Secret, error) {
+	handler := func(_ string, obj *corev1.Secret) (*corev1.Secret, error) {
 		if obj == nil {
 			return obj, nil
 		}
-		planList, err := plans.Cache().List(ctl.Namespace, labels.Everything())
+		sources, err := ctl.listPlans()
 		if err != nil {
 			return obj, err
 		}
-		for _, plan := range planList {
-			for _, secret := range plan.Spec.Secrets {
-				if obj.Name == secret.Name {
+		for _, source := range sources {
+			plan := source.plan
+			for _, secret := range upgradeplan.Secrets(plan) {
+				if obj.Namespace == plan.Namespace && obj.Name == secret.Name {
 					if !secret.IgnoreUpdates {
-						logrus.Debugf("Enqueing sync of Plan %s/%s from Secret %s/%s", plan.Namespace, plan.Name, obj.Namespace, obj.Name)
-						plans.Enqueue(plan.Namespace, plan.Name)
+						source.logger("secrets").WithField("secret", obj.Name).Debug("Enqueuing sync of Plan from Secret")
+						source.enqueue()
 						continue
 					}
 				}
 			}
 		}
 		return obj, nil
-	})
+	}
+	for _, factory := range ctl.namespaceFactories {
+		factory.core.Core().V1().Secret().OnChange(ctx, ctl.Name, handler)
+	}
+
+	return nil
+}
+
+// pod events for pods created by a job (potentially) trigger that job
+func (ctl *Controller) handlePods(ctx context.Context) error {
+	handler := func(_ string, obj *corev1.Pod) (*corev1.Pod, error) {
+		if obj == nil {
+			return obj, nil
+		}
+		// avoid commandeering pods from other controllers
+		if obj.Labels[upgradeapi.LabelController] != ctl.Name {
+			return obj, nil
+		}
+		if jobName, ok := obj.Labels[batchv1.JobNameLabel]; ok {
+			podLogger(obj).WithField("job", jobName).Debug("Enqueuing sync of Job from Pod")
+			ctl.jobs(obj.Namespace).Enqueue(obj.Namespace, jobName)
+		}
+		// reboot check pods report whether their node requires a reboot, which is reflected in a plan-specific node label
+		if planName, ok := obj.Labels[upgradeapi.LabelRebootCheck]; ok && obj.Spec.NodeName != "" {
+			return obj, ctl.syncRebootRequired(planName, obj)
+		}
+		return obj, nil
+	}
+	for _, factory := range ctl.namespaceFactories {
+		factory.core.Core().V1().Pod().OnChange(ctx, ctl.Name, handler)
+	}
 
 	return nil
 }
+
+// syncRebootRequired labels or unlabels the reboot check Pod's node, depending on whether it reports that a reboot is required.
+func (ctl *Controller) syncRebootRequired(planName string, pod *corev1.Pod) error {
+	nodes := ctl.coreFactory.Core().V1().Node()
+
+	node, err := nodes.Cache().Get(pod.Spec.NodeName)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	labelName := upgradejob.LabelName(pod.Namespace, planName, ctl.Namespace)
+	labelReboot := upgradeapi.LabelRebootName(labelName)
+	_, labeled := node.Labels[labelReboot]
+	// the label is cleared once the node has been rebooted, and is not set again until the check Pod is probed after that
+	rebootedAt, _ := time.Parse(time.RFC3339, node.Annotations[upgradeapi.AnnotationRebootedName(labelName)])
+	required := upgradereboot.IsRequired(pod, rebootedAt)
+	if required == labeled {
+		return nil
+	}
+	logger := podLogger(pod).WithFields(logrus.Fields{"plan": planName, "node": node.Name})
+	node = node.DeepCopy()
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if required {
+		logger.Info("Node requires reboot")
+		node.Labels[labelReboot] = upgradeapi.LabelRebootRequired
+	} else {
+		logger.Info("Node no longer requires reboot")
+		delete(node.Labels, labelReboot)
+	}
+	_, err = nodes.Update(node)
+	return err
+}
+
+// podLogger returns a log entry for the pod handler, with fields that identify the pod and the plan that created it, if any.
+func podLogger(pod *corev1.Pod) *logrus.Entry {
+	fields := logrus.Fields{
+		"handler":   "pods",
+		"namespace": pod.Namespace,
+		"pod":       pod.Name,
+	}
+	if planName, ok := pod.Labels[upgradeapi.LabelPlan]; ok {
+		fields["plan"] = planName
+	}
+	if nodeName := pod.Spec.NodeName; nodeName != "" {
+		fields["node"] = nodeName
+	}
+	return logrus.WithFields(fields)
+}
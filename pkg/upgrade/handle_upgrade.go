@@ -7,193 +7,764 @@ import (
 	"strings"
 	"time"
 
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
 	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
 	upgradectlv1 "github.com/rancher/system-upgrade-controller/pkg/generated/controllers/upgrade.cattle.io/v1"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/budget"
 	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
 	upgradenode "github.com/rancher/system-upgrade-controller/pkg/upgrade/node"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/notify"
 	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	upgradereboot "github.com/rancher/system-upgrade-controller/pkg/upgrade/reboot"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/tracing"
+	"github.com/rancher/wrangler/v3/pkg/apply"
+	batchctlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/batch/v1"
 	"github.com/rancher/wrangler/v3/pkg/generic"
 	"github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
 )
 
 func (ctl *Controller) handlePlans(ctx context.Context) error {
-	jobs := ctl.batchFactory.Batch().V1().Job()
+	jobs := ctl.jobs(ctl.Namespace)
 	nodes := ctl.coreFactory.Core().V1().Node()
 	plans := ctl.upgradeFactory.Upgrade().V1().Plan()
-	secrets := ctl.coreFactory.Core().V1().Secret()
-	secretsCache := secrets.Cache()
-	recorder := ctl.recorder
+	clusterPlans := ctl.upgradeFactory.Upgrade().V1().ClusterPlan()
+	secrets := ctl.factoryFor(ctl.Namespace).core.Core().V1().Secret()
+	jobApply := ctl.apply.WithCacheTypes(nodes, secrets).WithGVK(jobs.GroupVersionKind()).WithDynamicLookup().WithNoDelete()
+	generatingHandlerOptions := &generic.GeneratingHandlerOptions{
+		AllowClusterScoped:            true,
+		NoOwnerReference:              true,
+		UniqueApplyForResourceVersion: true,
+	}
 
 	// process plan events, mutating status accordingly
 	upgradectlv1.RegisterPlanStatusHandler(ctx, plans, "", ctl.Name,
 		func(obj *upgradeapiv1.Plan, status upgradeapiv1.PlanStatus) (upgradeapiv1.PlanStatus, error) {
-			if obj == nil {
+			if obj == nil || !ctl.watchesNamespace(obj.Namespace) {
 				return status, nil
 			}
-			logrus.Debugf("PLAN STATUS HANDLER: plan=%s/%s@%s, status=%+v", obj.Namespace, obj.Name, obj.ResourceVersion, status)
-
-			// ensure that the complete status is present
-			complete := upgradeapiv1.PlanComplete
-			complete.CreateUnknownIfNotExists(obj)
+			return ctl.syncPlanStatus(ctx, status, ctl.planSource(obj))
+		},
+	)
 
-			// validate plan, and generate events for transitions
-			validated := upgradeapiv1.PlanSpecValidated
-			validated.CreateUnknownIfNotExists(obj)
-			if err := upgradeplan.Validate(obj, secretsCache); err != nil {
-				if !validated.IsFalse(obj) {
-					recorder.Eventf(obj, corev1.EventTypeWarning, "ValidateFailed", "Failed to validate plan: %v", err)
-				}
-				validated.SetError(obj, "Error", err)
-				return upgradeplan.DigestStatus(obj, secretsCache)
+	// process plan events by creating jobs to apply the plan
+	upgradectlv1.RegisterPlanGeneratingHandler(ctx, plans, jobApply, "", ctl.Name,
+		func(obj *upgradeapiv1.Plan, status upgradeapiv1.PlanStatus) ([]runtime.Object, upgradeapiv1.PlanStatus, error) {
+			if obj == nil || !ctl.watchesNamespace(obj.Namespace) {
+				return nil, status, nil
 			}
-			if !validated.IsTrue(obj) {
-				recorder.Event(obj, corev1.EventTypeNormal, "Validated", "Plan is valid")
+			return ctl.syncPlanJobs(ctx, status, ctl.planSource(obj))
+		},
+		generatingHandlerOptions,
+	)
+
+	// process cluster plan events, mutating status accordingly.
+	// cluster plans are reconciled as plans in the controller namespace.
+	upgradectlv1.RegisterClusterPlanStatusHandler(ctx, clusterPlans, "", ctl.Name,
+		func(obj *upgradeapiv1.ClusterPlan, status upgradeapiv1.PlanStatus) (upgradeapiv1.PlanStatus, error) {
+			if obj == nil {
+				return status, nil
 			}
-			validated.SetError(obj, "PlanIsValid", nil)
+			return ctl.syncPlanStatus(ctx, status, ctl.clusterPlanSource(obj))
+		},
+	)
 
-			// resolve version from spec or channel, and generate events for transitions
-			resolved := upgradeapiv1.PlanLatestResolved
-			resolved.CreateUnknownIfNotExists(obj)
-			// raise error if neither version nor channel are set. this is handled separate from other validation.
-			if obj.Spec.Version == "" && obj.Spec.Channel == "" {
-				if !resolved.IsFalse(obj) {
-					recorder.Event(obj, corev1.EventTypeWarning, "ResolveFailed", upgradeapiv1.ErrPlanUnresolvable.Error())
-				}
-				resolved.SetError(obj, "Error", upgradeapiv1.ErrPlanUnresolvable)
-				return upgradeplan.DigestStatus(obj, secretsCache)
-			}
-			// use static version from spec if set
-			if obj.Spec.Version != "" {
-				latest := upgradeplan.MungeVersion(obj.Spec.Version)
-				if !resolved.IsTrue(obj) || obj.Status.LatestVersion != latest {
-					// Version has changed, set complete to false and emit event
-					recorder.Eventf(obj, corev1.EventTypeNormal, "Resolved", "Resolved latest version from Spec.Version: %s", latest)
-					complete.False(obj)
-					complete.Message(obj, "")
-					complete.Reason(obj, "Resolved")
-				}
-				obj.Status.LatestVersion = latest
-				resolved.SetError(obj, "Version", nil)
-				return upgradeplan.DigestStatus(obj, secretsCache)
-			}
-			// re-enqueue a sync at the next channel polling interval, if the LastUpdated time
-			// on the resolved status indicates that the interval has not been reached.
-			if resolved.IsTrue(obj) {
-				if lastUpdated, err := time.Parse(time.RFC3339, resolved.GetLastUpdated(obj)); err == nil {
-					if interval := time.Since(lastUpdated); interval < upgradeplan.PollingInterval {
-						plans.EnqueueAfter(obj.Namespace, obj.Name, upgradeplan.PollingInterval-interval)
-						return status, nil
-					}
-				}
+	// process cluster plan events by creating jobs in the controller namespace to apply the plan
+	upgradectlv1.RegisterClusterPlanGeneratingHandler(ctx, clusterPlans, jobApply, "", ctl.Name,
+		func(obj *upgradeapiv1.ClusterPlan, status upgradeapiv1.PlanStatus) ([]runtime.Object, upgradeapiv1.PlanStatus, error) {
+			if obj == nil {
+				return nil, status, nil
 			}
-			// no static version, poll the channel to get latest version
-			latest, err := upgradeplan.ResolveChannel(ctx, obj.Spec.Channel, obj.Status.LatestVersion, ctl.clusterID)
-			if err != nil {
-				if !resolved.IsFalse(obj) {
-					recorder.Eventf(obj, corev1.EventTypeWarning, "ResolveFailed", "Failed to resolve latest version from Spec.Channel: %v", err)
-				}
-				return status, err
-			}
-			latest = upgradeplan.MungeVersion(latest)
-			if !resolved.IsTrue(obj) || obj.Status.LatestVersion != latest {
-				// Version has changed, set complete to false and emit event
-				recorder.Eventf(obj, corev1.EventTypeNormal, "Resolved", "Resolved latest version from Spec.Channel: %s", latest)
-				complete.False(obj)
-				complete.Message(obj, "")
-				complete.Reason(obj, "Resolved")
-			}
-			obj.Status.LatestVersion = latest
-			resolved.SetError(obj, "Channel", nil)
-			return upgradeplan.DigestStatus(obj, secretsCache)
+			return ctl.syncPlanJobs(ctx, status, ctl.clusterPlanSource(obj))
 		},
+		generatingHandlerOptions,
 	)
 
-	// process plan events by creating jobs to apply the plan
-	upgradectlv1.RegisterPlanGeneratingHandler(ctx, plans, ctl.apply.WithCacheTypes(nodes, secrets).WithGVK(jobs.GroupVersionKind()).WithDynamicLookup().WithNoDelete(), "", ctl.Name,
-		func(obj *upgradeapiv1.Plan, status upgradeapiv1.PlanStatus) (objects []runtime.Object, _ upgradeapiv1.PlanStatus, _ error) {
+	// release the place of deleted plans that were waiting for the controller-wide limit on nodes applying
+	if ctl.budget != nil {
+		plans.OnChange(ctx, ctl.Name+"-budget", func(key string, obj *upgradeapiv1.Plan) (*upgradeapiv1.Plan, error) {
+			if obj == nil {
+				ctl.budget.Release(planSourceKey("Plan", key))
+			}
+			return obj, nil
+		})
+		clusterPlans.OnChange(ctx, ctl.Name+"-budget", func(key string, obj *upgradeapiv1.ClusterPlan) (*upgradeapiv1.ClusterPlan, error) {
 			if obj == nil {
-				return objects, status, nil
+				ctl.budget.Release(planSourceKey("ClusterPlan", ctl.Namespace+"/"+key))
 			}
+			return obj, nil
+		})
+	}
 
-			logrus.Debugf("PLAN GENERATING HANDLER: plan=%s/%s@%s, status=%+v", obj.Namespace, obj.Name, obj.ResourceVersion, status)
-			// return early without selecting nodes if the plan is not validated and resolved
-			complete := upgradeapiv1.PlanComplete
-			if !upgradeapiv1.PlanSpecValidated.IsTrue(obj) || !upgradeapiv1.PlanLatestResolved.IsTrue(obj) {
-				complete.SetError(obj, "NotReady", ErrPlanNotReady)
-				return objects, status, nil
+	// process plan events by creating or removing the reboot check daemonset for plans that only reboot
+	// each namespace has its own cache of daemonsets, so the apply that looks them up is also per namespace
+	rebootCheckApplies := map[string]apply.Apply{}
+	for namespace := range ctl.namespaceFactories {
+		daemonSets := ctl.daemonSets(namespace)
+		rebootCheckApplies[namespace] = ctl.apply.WithCacheTypes(daemonSets).WithGVK(daemonSets.GroupVersionKind()).WithSetOwnerReference(true, false)
+	}
+	rebootCheckApply := func(namespace string) apply.Apply {
+		if rebootCheckApply, ok := rebootCheckApplies[metav1.NamespaceAll]; ok {
+			return rebootCheckApply
+		}
+		return rebootCheckApplies[namespace]
+	}
+	plans.OnChange(ctx, ctl.Name+"-reboot-check", func(_ string, obj *upgradeapiv1.Plan) (*upgradeapiv1.Plan, error) {
+		if obj == nil || obj.DeletionTimestamp != nil || !ctl.watchesNamespace(obj.Namespace) {
+			return obj, nil
+		}
+		var objects []runtime.Object
+		if upgradejob.RebootOnly(obj) {
+			objects = append(objects, upgradereboot.NewCheck(obj, ctl.Name))
+		}
+		return obj, rebootCheckApply(obj.Namespace).WithOwner(obj).WithSetID("reboot-check").ApplyObjects(objects...)
+	})
+	clusterPlans.OnChange(ctx, ctl.Name+"-reboot-check", func(_ string, obj *upgradeapiv1.ClusterPlan) (*upgradeapiv1.ClusterPlan, error) {
+		if obj == nil || obj.DeletionTimestamp != nil {
+			return obj, nil
+		}
+		var objects []runtime.Object
+		if plan := ctl.clusterPlanSource(obj).plan; upgradejob.RebootOnly(plan) {
+			objects = append(objects, upgradereboot.NewCheck(plan, ctl.Name))
+		}
+		return obj, rebootCheckApply(ctl.Namespace).WithOwner(obj).WithSetID("reboot-check").ApplyObjects(objects...)
+	})
+
+	return nil
+}
+
+// planSource is the object that a Plan is reconciled from: either the Plan itself, or a ClusterPlan that
+// is reconciled as a Plan in the controller namespace. Events are recorded against, and syncs enqueued for, the source.
+type planSource struct {
+	plan         *upgradeapiv1.Plan
+	object       runtime.Object
+	clusterPlan  bool
+	enqueue      func()
+	enqueueAfter func(time.Duration)
+	updateStatus func(*upgradeapiv1.Plan) error
+}
+
+func (ctl *Controller) planSource(plan *upgradeapiv1.Plan) planSource {
+	plans := ctl.upgradeFactory.Upgrade().V1().Plan()
+	return planSource{
+		plan:   plan,
+		object: plan,
+		enqueue: func() {
+			plans.Enqueue(plan.Namespace, plan.Name)
+		},
+		enqueueAfter: func(duration time.Duration) {
+			plans.EnqueueAfter(plan.Namespace, plan.Name, duration)
+		},
+		updateStatus: func(plan *upgradeapiv1.Plan) error {
+			_, err := plans.UpdateStatus(plan)
+			return err
+		},
+	}
+}
+
+func (ctl *Controller) clusterPlanSource(clusterPlan *upgradeapiv1.ClusterPlan) planSource {
+	clusterPlans := ctl.upgradeFactory.Upgrade().V1().ClusterPlan()
+	return planSource{
+		plan:        upgradeplan.FromClusterPlan(clusterPlan, ctl.Namespace),
+		object:      clusterPlan,
+		clusterPlan: true,
+		enqueue: func() {
+			clusterPlans.Enqueue(clusterPlan.Name)
+		},
+		enqueueAfter: func(duration time.Duration) {
+			clusterPlans.EnqueueAfter(clusterPlan.Name, duration)
+		},
+		updateStatus: func(plan *upgradeapiv1.Plan) error {
+			clusterPlan := clusterPlan.DeepCopy()
+			clusterPlan.Status = plan.Status
+			_, err := clusterPlans.UpdateStatus(clusterPlan)
+			return err
+		},
+	}
+}
+
+// kind returns the kind of the source: Plan or ClusterPlan.
+func (source planSource) kind() string {
+	if source.clusterPlan {
+		return "ClusterPlan"
+	}
+	return "Plan"
+}
+
+// key returns a key that identifies the plan among both Plans and ClusterPlans.
+func (source planSource) key() string {
+	return planSourceKey(source.kind(), source.plan.Namespace+"/"+source.plan.Name)
+}
+
+// planSourceKey returns the key of the source of the given kind, from the namespace/name key of its plan.
+func planSourceKey(kind, key string) string {
+	return kind + "/" + key
+}
+
+// logger returns a log entry for the named handler, with fields that identify the plan and its latest version and hash.
+func (source planSource) logger(handler string) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"handler":         handler,
+		"kind":            source.kind(),
+		"namespace":       source.plan.Namespace,
+		"plan":            source.plan.Name,
+		"resourceVersion": source.plan.ResourceVersion,
+		"version":         source.plan.Status.LatestVersion,
+		"hash":            source.plan.Status.LatestHash,
+	})
+}
+
+// tracingPlan returns the plan that spans are recorded for, identified by the kind of the source.
+func (source planSource) tracingPlan() tracing.Plan {
+	return tracing.Plan{Plan: source.plan, Kind: source.kind()}
+}
+
+// cloudEvent sends a CloudEvent of the given type for the plan, and node if any, to the CloudEvents sink, if configured.
+// The time at which the event occurred is only given for transitions that may recur for the same hash; see notify.NewCloudEvent.
+func (ctl *Controller) cloudEvent(source planSource, eventType, node, message string, occurred time.Time) {
+	data := notify.CloudEventData{
+		Kind:          source.kind(),
+		Namespace:     source.plan.Namespace,
+		Name:          source.plan.Name,
+		Node:          node,
+		LatestVersion: source.plan.Status.LatestVersion,
+		LatestHash:    source.plan.Status.LatestHash,
+		Message:       message,
+	}
+	if source.clusterPlan {
+		data.Namespace = ""
+	}
+	ctl.notifier.CloudEvent(eventType, data, occurred)
+}
+
+// syncPlanStatus validates the plan and resolves its latest version, returning the updated status.
+func (ctl *Controller) syncPlanStatus(ctx context.Context, status upgradeapiv1.PlanStatus, source planSource) (upgradeapiv1.PlanStatus, error) {
+	obj := source.plan
+	secretsCache := ctl.secretCache()
+	source.logger("plan-status").WithField("status", status).Debug("Syncing Plan status")
+
+	// ensure that the complete status is present
+	complete := upgradeapiv1.PlanComplete
+	complete.CreateUnknownIfNotExists(obj)
+
+	// validate plan, and generate events for transitions
+	validated := upgradeapiv1.PlanSpecValidated
+	validated.CreateUnknownIfNotExists(obj)
+	err := upgradeplan.Validate(obj, secretsCache)
+	if err == nil && source.clusterPlan {
+		err = ctl.validateClusterPlanName(obj.Name)
+	}
+	if err != nil {
+		if !validated.IsFalse(obj) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "ValidateFailed", "Failed to validate plan: %v", err)
+		}
+		validated.SetError(obj, "Error", err)
+		return upgradeplan.DigestStatus(obj, secretsCache)
+	}
+	if !validated.IsTrue(obj) {
+		ctl.recorder.Event(source.object, corev1.EventTypeNormal, "Validated", "Plan is valid")
+	}
+	validated.SetError(obj, "PlanIsValid", nil)
+
+	// resolve version from spec or channel, and generate events for transitions
+	resolved := upgradeapiv1.PlanLatestResolved
+	resolved.CreateUnknownIfNotExists(obj)
+	// plans that only reboot nodes have no version to resolve, unless one is given
+	if obj.Spec.Version == "" && obj.Spec.Channel == "" && upgradejob.RebootOnly(obj) {
+		if !resolved.IsTrue(obj) {
+			ctl.recorder.Event(source.object, corev1.EventTypeNormal, "Resolved", "Plan only reboots Nodes, no version to resolve")
+			defer ctl.cloudEvent(source, notify.CloudEventPlanResolved, "", "Plan only reboots Nodes, no version to resolve", time.Time{})
+		}
+		obj.Status.LatestVersion = ""
+		resolved.SetError(obj, "RebootOnly", nil)
+		return upgradeplan.DigestStatus(obj, secretsCache)
+	}
+	// raise error if neither version nor channel are set. this is handled separate from other validation.
+	if obj.Spec.Version == "" && obj.Spec.Channel == "" {
+		if !resolved.IsFalse(obj) {
+			ctl.recorder.Event(source.object, corev1.EventTypeWarning, "ResolveFailed", upgradeapiv1.ErrPlanUnresolvable.Error())
+		}
+		resolved.SetError(obj, "Error", upgradeapiv1.ErrPlanUnresolvable)
+		return upgradeplan.DigestStatus(obj, secretsCache)
+	}
+	// use static version from spec if set
+	if obj.Spec.Version != "" {
+		latest := upgradeplan.MungeVersion(obj.Spec.Version)
+		if !resolved.IsTrue(obj) || obj.Status.LatestVersion != latest {
+			// Version has changed, set complete to false and emit event
+			ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Resolved", "Resolved latest version from Spec.Version: %s", latest)
+			// sent on return, once the latest version and hash have been updated
+			defer ctl.cloudEvent(source, notify.CloudEventPlanResolved, "", "Resolved latest version from Spec.Version: "+latest, time.Time{})
+			defer ctl.tracer.Resolved(source.tracingPlan(), "Spec.Version", time.Now(), time.Now())
+			complete.False(obj)
+			complete.Message(obj, "")
+			complete.Reason(obj, "Resolved")
+		}
+		obj.Status.LatestVersion = latest
+		resolved.SetError(obj, "Version", nil)
+		return upgradeplan.DigestStatus(obj, secretsCache)
+	}
+	// re-enqueue a sync at the next channel polling interval, if the LastUpdated time
+	// on the resolved status indicates that the interval has not been reached.
+	if resolved.IsTrue(obj) {
+		if lastUpdated, err := time.Parse(time.RFC3339, resolved.GetLastUpdated(obj)); err == nil {
+			if interval := time.Since(lastUpdated); interval < upgradeplan.PollingInterval {
+				source.enqueueAfter(upgradeplan.PollingInterval - interval)
+				return status, nil
 			}
+		}
+	}
+	// no static version, poll the channel to get latest version
+	resolveStart := time.Now()
+	latest, err := upgradeplan.ResolveChannel(ctx, obj.Spec.Channel, obj.Status.LatestVersion, ctl.clusterID)
+	if err != nil {
+		if !resolved.IsFalse(obj) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "ResolveFailed", "Failed to resolve latest version from Spec.Channel: %v", err)
+		}
+		return status, err
+	}
+	latest = upgradeplan.MungeVersion(latest)
+	if !resolved.IsTrue(obj) || obj.Status.LatestVersion != latest {
+		// Version has changed, set complete to false and emit event
+		ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Resolved", "Resolved latest version from Spec.Channel: %s", latest)
+		// sent on return, once the latest version and hash have been updated
+		defer ctl.cloudEvent(source, notify.CloudEventPlanResolved, "", "Resolved latest version from Spec.Channel: "+latest, time.Time{})
+		defer ctl.tracer.Resolved(source.tracingPlan(), "Spec.Channel", resolveStart, time.Now())
+		complete.False(obj)
+		complete.Message(obj, "")
+		complete.Reason(obj, "Resolved")
+	}
+	obj.Status.LatestVersion = latest
+	resolved.SetError(obj, "Channel", nil)
+	return upgradeplan.DigestStatus(obj, secretsCache)
+}
 
-			// select nodes to apply the plan on based on nodeSelector, plan hash, and concurrency
-			concurrentNodes, err := upgradeplan.SelectConcurrentNodes(obj, nodes.Cache())
-			if err != nil {
-				recorder.Eventf(obj, corev1.EventTypeWarning, "SelectNodesFailed", "Failed to select Nodes: %v", err)
-				complete.SetError(obj, "SelectNodesFailed", err)
+// syncPlanJobs selects nodes to apply the plan on, and returns the jobs to apply it along with the updated status.
+func (ctl *Controller) syncPlanJobs(ctx context.Context, status upgradeapiv1.PlanStatus, source planSource) (objects []runtime.Object, _ upgradeapiv1.PlanStatus, _ error) {
+	obj := source.plan
+	jobs := ctl.jobs(obj.Namespace)
+	logger := source.logger("plan-jobs")
+	logger.WithField("status", status).Debug("Syncing Plan Jobs")
+	nodes := ctl.coreFactory.Core().V1().Node()
+	maintenanceWindows := ctl.upgradeFactory.Upgrade().V1().MaintenanceWindow()
+
+	// the plan only keeps its place waiting for the controller-wide limit on nodes applying while it reaches the limit;
+	// it is released if it returns early, such as while it is paused, awaiting approval, or outside its window.
+	checkedBudget := false
+	defer func() {
+		if !checkedBudget {
+			ctl.budget.Release(source.key())
+		}
+	}()
+
+	// return early without selecting nodes if the plan is not validated and resolved
+	complete := upgradeapiv1.PlanComplete
+	if !upgradeapiv1.PlanSpecValidated.IsTrue(obj) || !upgradeapiv1.PlanLatestResolved.IsTrue(obj) {
+		complete.SetError(obj, "NotReady", ErrPlanNotReady)
+		return objects, status, nil
+	}
+
+	// select nodes to apply the plan on based on nodeSelector, plan hash, and concurrency
+	concurrentNodes, err := upgradeplan.SelectConcurrentNodes(obj, ctl.Namespace, nodes.Cache(), ctl.nodeLoads(ctx))
+	if err != nil {
+		ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "SelectNodesFailed", "Failed to select Nodes: %v", err)
+		complete.SetError(obj, "SelectNodesFailed", err)
+		return objects, status, err
+	}
+
+	// Nodes skipped as per the node failure policy are excluded from the selected nodes; record them in the status,
+	// and emit an event for each node that has just been skipped.
+	skipped := upgradeplan.SkippedNodes(obj)
+	for _, nodeName := range skipped {
+		if !slices.Contains(obj.Status.Skipped, nodeName) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "NodeSkipped", "Skipping Node %s after Job failed for version %s. Hash: %s", nodeName, obj.Status.LatestVersion, obj.Status.LatestHash)
+		}
+	}
+	obj.Status.Skipped = skipped
+
+	// Plans that require manual approval don't start Jobs until the latest hash has been approved.
+	// Record the approval in the status, so that it is retained if the annotation is removed.
+	awaitingApproval := upgradeapiv1.PlanAwaitingApproval
+	switch {
+	case upgradeplan.Approved(obj):
+		if awaitingApproval.IsTrue(obj) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Approved", "Approved Jobs for version %s. Hash: %s", obj.Status.LatestVersion, obj.Status.LatestHash)
+		}
+		if obj.Spec.Approval == upgradeapiv1.ApprovalManual {
+			obj.Status.ApprovedHash = obj.Status.LatestHash
+			awaitingApproval.False(obj)
+			awaitingApproval.Reason(obj, "Approved")
+		} else if awaitingApproval.IsTrue(obj) {
+			awaitingApproval.False(obj)
+			awaitingApproval.Reason(obj, "Automatic")
+		}
+	case len(concurrentNodes) > 0:
+		if !awaitingApproval.IsTrue(obj) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "AwaitingApproval", "Waiting for approval to sync Jobs for version %s. Hash: %s", obj.Status.LatestVersion, obj.Status.LatestHash)
+		}
+		awaitingApproval.SetError(obj, "AwaitingApproval", nil)
+		complete.SetError(obj, "AwaitingApproval", ErrAwaitingApproval)
+		return nil, obj.Status, nil
+	}
+
+	// Don't start Jobs on new nodes while the Plan is paused; Jobs for nodes already applying are allowed to continue.
+	if obj.Spec.Paused {
+		applyingNodes := filterApplying(obj, concurrentNodes)
+		if len(applyingNodes) < len(concurrentNodes) {
+			if len(applyingNodes) == 0 {
+				if complete.GetReason(obj) != "Paused" {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Paused", "Paused syncing Jobs for version %s. Hash: %s", obj.Status.LatestVersion, obj.Status.LatestHash)
+				}
+				complete.SetError(obj, "Paused", ErrPaused)
+				return nil, obj.Status, nil
+			}
+			concurrentNodes = applyingNodes
+		}
+	}
+
+	// evaluate windows and blackouts from the referenced MaintenanceWindow, if any
+	var maintenanceWindow *upgradeapiv1.MaintenanceWindow
+	windowSource := "Spec.Window"
+	if obj.Spec.WindowRef != "" {
+		maintenanceWindow, err = maintenanceWindows.Cache().Get(obj.Spec.WindowRef)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
 				return objects, status, err
 			}
+			if complete.GetReason(obj) != "WindowNotFound" {
+				ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "WindowNotFound", "MaintenanceWindow %s not found", obj.Spec.WindowRef)
+			}
+			complete.SetError(obj, "WindowNotFound", err)
+			return nil, obj.Status, nil
+		}
+		if err := upgradeplan.ValidateMaintenanceWindow(maintenanceWindow); err != nil {
+			if complete.GetReason(obj) != "InvalidWindow" {
+				ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "InvalidWindow", "MaintenanceWindow %s is invalid: %v", obj.Spec.WindowRef, err)
+			}
+			complete.SetError(obj, "InvalidWindow", err)
+			return nil, obj.Status, nil
+		}
+		windowSource = "MaintenanceWindow " + maintenanceWindow.Name
+	}
+	schedule := upgradeplan.NewSchedule(obj, maintenanceWindow)
 
-			// Create an upgrade job for each node, and add the node name to Status.Applying
-			// Note that this initially creates paused jobs, and then on a second pass once
-			// the node has been added to Status.Applying the job parallelism is patched to 1
-			// to unpause the job. Ref: https://github.com/rancher/system-upgrade-controller/issues/134
-			concurrentNodeNames := make([]string, len(concurrentNodes))
-			for i := range concurrentNodes {
-				node := concurrentNodes[i]
-				// Validate Windows nodes have kubectl image configured before creating job
-				if node.Labels["kubernetes.io/os"] == "windows" && upgradejob.KubectlImageWindows == "" {
-					err := fmt.Errorf("SYSTEM_UPGRADE_JOB_KUBECTL_IMAGE_WINDOWS is required when targeting Windows nodes")
-					recorder.Eventf(obj, corev1.EventTypeWarning, "ValidationFailed", "Failed to create job for node %s: %v", node.Name, err)
-					complete.SetError(obj, "ValidationFailed", err)
-					return objects, status, err
+	// Don't start Jobs on new nodes while the Plan is in a blackout; Jobs for nodes already
+	// applying are allowed to continue. Enqueue the plan to check again when the blackout ends.
+	now := ctl.now()
+	if blackout := schedule.ActiveBlackout(now); blackout != nil {
+		applyingNodes := filterApplying(obj, concurrentNodes)
+		if len(applyingNodes) < len(concurrentNodes) {
+			source.enqueueAfter(blackout.End.Sub(now))
+			if len(applyingNodes) == 0 {
+				if complete.GetReason(obj) != "Blackout" {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Blackout", "Waiting for end of blackout at %s to sync Jobs for version %s: %s. Hash: %s",
+						blackout.End.UTC().Format(time.RFC3339), obj.Status.LatestVersion, blackout.Reason, obj.Status.LatestHash)
 				}
-				objects = append(objects, upgradejob.New(obj, node, ctl.Name))
-				concurrentNodeNames[i] = upgradenode.Hostname(node)
-			}
-
-			if len(concurrentNodeNames) > 0 {
-				// Don't start creating Jobs for the Plan if we're outside the window; just
-				// enqueue the plan to check again in a minute to see if we're within the window yet.
-				// The Plan is allowed to continue processing as long as there are nodes in progress.
-				if window := obj.Spec.Window; window != nil {
-					if len(obj.Status.Applying) == 0 && !window.Contains(time.Now()) {
-						if complete.GetReason(obj) != "Waiting" {
-							recorder.Eventf(obj, corev1.EventTypeNormal, "Waiting", "Waiting for start of Spec.Window to sync Jobs for version %s. Hash: %s", obj.Status.LatestVersion, obj.Status.LatestHash)
-						}
-						plans.EnqueueAfter(obj.Namespace, obj.Name, time.Minute)
-						complete.SetError(obj, "Waiting", ErrOutsideWindow)
-						return nil, status, nil
-					}
+				complete.SetError(obj, "Blackout", blackoutError(blackout))
+				return nil, obj.Status, nil
+			}
+			concurrentNodes = applyingNodes
+		}
+	}
+
+	// Once the window has closed, Plans that enforce its end don't start Jobs on new nodes, even
+	// while Jobs for other nodes are still applying. Enqueue the plan to check again when the window next opens.
+	windowClosed := !schedule.Open(now)
+	if windowClosed && schedule.EnforceEnd() {
+		applyingNodes := filterApplying(obj, concurrentNodes)
+		if len(applyingNodes) < len(concurrentNodes) {
+			if next, ok := schedule.NextOpen(now); ok {
+				source.enqueueAfter(next.Sub(now))
+			}
+			if len(applyingNodes) == 0 {
+				if complete.GetReason(obj) != "Waiting" {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Waiting", "Waiting for start of %s to sync Jobs for version %s. Hash: %s", windowSource, obj.Status.LatestVersion, obj.Status.LatestHash)
+				}
+				complete.SetError(obj, "Waiting", ErrOutsideWindow)
+				return nil, obj.Status, nil
+			}
+			concurrentNodes = applyingNodes
+		}
+	}
+
+	// Plans scheduled for a single slot don't start Jobs before the slot opens, and don't start Jobs
+	// on new nodes once it has closed. Enqueue the plan to check again when the slot opens.
+	if notBefore := obj.Spec.NotBefore; notBefore != nil && now.Before(notBefore.Time) {
+		applyingNodes := filterApplying(obj, concurrentNodes)
+		if len(applyingNodes) < len(concurrentNodes) {
+			source.enqueueAfter(notBefore.Sub(now))
+			if len(applyingNodes) == 0 {
+				if complete.GetReason(obj) != "Waiting" {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Waiting", "Waiting for Spec.NotBefore at %s to sync Jobs for version %s. Hash: %s",
+						notBefore.UTC().Format(time.RFC3339), obj.Status.LatestVersion, obj.Status.LatestHash)
 				}
+				complete.SetError(obj, "Waiting", ErrNotBefore)
+				return nil, obj.Status, nil
+			}
+			concurrentNodes = applyingNodes
+		}
+	}
+	if notAfter := obj.Spec.NotAfter; notAfter != nil && !now.Before(notAfter.Time) {
+		applyingNodes := filterApplying(obj, concurrentNodes)
+		if len(applyingNodes) < len(concurrentNodes) {
+			if len(applyingNodes) == 0 {
+				if complete.GetReason(obj) != "Expired" {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "Expired", "Spec.NotAfter at %s passed before Jobs completed for version %s. Hash: %s",
+						notAfter.UTC().Format(time.RFC3339), obj.Status.LatestVersion, obj.Status.LatestHash)
+				}
+				complete.SetError(obj, "Expired", ErrExpired)
+				return nil, obj.Status, nil
+			}
+			concurrentNodes = applyingNodes
+		}
+	}
 
-				// If the node list has changed, update Applying status with new node list and emit an event
-				if !slices.Equal(obj.Status.Applying, concurrentNodeNames) {
-					recorder.Eventf(obj, corev1.EventTypeNormal, "SyncJob", "Jobs synced for version %s on Nodes %s. Hash: %s",
-						obj.Status.LatestVersion, strings.Join(concurrentNodeNames, ","), obj.Status.LatestHash)
-					obj.Status.Applying = concurrentNodeNames[:]
-					complete.False(obj)
-					complete.Message(obj, "")
-					complete.Reason(obj, "SyncJob")
+	// Don't start Jobs on new nodes beyond the maximum number of nodes applying across all plans; Jobs for nodes already
+	// applying are allowed to continue. Enqueue the plan to check again once nodes applying other plans may have completed.
+	if ctl.budget != nil {
+		sources, err := ctl.listPlans()
+		if err != nil {
+			return objects, status, err
+		}
+		plans := make(map[string][]string, len(sources))
+		for _, other := range sources {
+			plans[other.key()] = other.plan.Status.Applying
+		}
+		hostnames := make([]string, len(concurrentNodes))
+		for i, node := range concurrentNodes {
+			hostnames[i] = upgradenode.Hostname(node)
+		}
+		checkedBudget = true
+		admitted := ctl.budget.Admit(source.key(), obj.Status.Applying, hostnames, plans)
+		if len(admitted) < len(concurrentNodes) {
+			source.enqueueAfter(budget.PollInterval)
+			if len(admitted) == 0 {
+				if complete.GetReason(obj) != "WaitingForBudget" {
+					ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "WaitingForBudget", "Waiting for Jobs of other Plans to complete, as at most %d Nodes may be applying at once, to sync Jobs for version %s. Hash: %s",
+						ctl.maxConcurrentNodes, obj.Status.LatestVersion, obj.Status.LatestHash)
 				}
+				complete.SetError(obj, "WaitingForBudget", ErrWaitingForBudget)
+				return nil, obj.Status, nil
+			}
+			concurrentNodes = slices.DeleteFunc(slices.Clone(concurrentNodes), func(node *corev1.Node) bool {
+				return !slices.Contains(admitted, upgradenode.Hostname(node))
+			})
+		}
+	}
+
+	// Nodes are selected in the order requested by the plan, but are listed in Status.Applying by name
+	slices.SortFunc(concurrentNodes, func(a, b *corev1.Node) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	// Create an upgrade job for each node, and add the node name to Status.Applying
+	// Note that this initially creates paused jobs, and then on a second pass once
+	// the node has been added to Status.Applying the job parallelism is patched to 1
+	// to unpause the job. Ref: https://github.com/rancher/system-upgrade-controller/issues/134
+	concurrentNodeNames := make([]string, len(concurrentNodes))
+	for i := range concurrentNodes {
+		node := concurrentNodes[i]
+		// Validate Windows nodes have kubectl image configured before creating job
+		if node.Labels["kubernetes.io/os"] == "windows" && upgradejob.KubectlImageWindows == "" {
+			err := fmt.Errorf("SYSTEM_UPGRADE_JOB_KUBECTL_IMAGE_WINDOWS is required when targeting Windows nodes")
+			ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "ValidationFailed", "Failed to create job for node %s: %v", node.Name, err)
+			complete.SetError(obj, "ValidationFailed", err)
+			return objects, status, err
+		}
+		if upgradejob.RebootEnabled(obj) && node.Labels["kubernetes.io/os"] == "windows" && !slices.Contains(obj.Status.Applying, upgradenode.Hostname(node)) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeWarning, "RebootUnsupported", "Reboot is not supported on Windows Node %s, the Job will not reboot it", node.Name)
+		}
+		job := upgradejob.New(obj, node, ctl.Namespace, ctl.Name)
+		if source.clusterPlan {
+			job.Labels[upgradeapi.LabelClusterPlan] = obj.Name
+		}
+		concurrentNodeNames[i] = upgradenode.Hostname(node)
+		// Nodes whose Job failed are given a new Job once the retry backoff has elapsed, and remain on the applying list
+		// until then. Nodes that the retry policy has given up on are no longer selected.
+		if failure := upgradeplan.NodeFailure(obj, node.Name); obj.Spec.Retry != nil && failure != nil && failure.Hash == obj.Status.LatestHash && failure.RetriedAt == nil {
+			retryAt, retry := upgradeplan.RetryAt(obj, failure)
+			if !retry {
+				continue
+			}
+			if now.Before(retryAt) {
+				source.enqueueAfter(retryAt.Sub(now))
+				continue
+			}
+			// the failed job is normally deleted by the job handler to retry it, but may already have been deleted
+			// once its TTL expired; if so, record the retry so that the job is re-created when the status is updated.
+			if _, err := jobs.Cache().Get(job.Namespace, job.Name); apierrors.IsNotFound(err) {
+				retriedAt := metav1.NewTime(now)
+				failure.RetriedAt = &retriedAt
+			} else if err != nil {
+				return objects, status, err
+			}
+		}
+		// Jobs that have not yet started are kept paused while the window is closed, if requested
+		if windowClosed && schedule.SuspendPending() {
+			if suspend, err := jobNotStarted(jobs.Cache(), job); err != nil {
+				return objects, status, err
+			} else if suspend {
+				logger.WithFields(logrus.Fields{"job": job.Name, "node": node.Name}).Debugf("Suspending Job until start of %s", windowSource)
+				*job.Spec.Parallelism = 0
+			}
+		}
+		objects = append(objects, job)
+	}
+
+	if len(concurrentNodeNames) > 0 {
+		// Don't start creating Jobs for the Plan if we're outside the window; just
+		// enqueue the plan to check again when the window next opens.
+		// The Plan is allowed to continue processing as long as there are nodes in progress.
+		if len(obj.Status.Applying) == 0 && !schedule.Open(now) {
+			if complete.GetReason(obj) != "Waiting" {
+				ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Waiting", "Waiting for start of %s to sync Jobs for version %s. Hash: %s", windowSource, obj.Status.LatestVersion, obj.Status.LatestHash)
+			}
+			if next, ok := schedule.NextOpen(now); ok {
+				source.enqueueAfter(next.Sub(now))
 			} else {
-				// set PlanComplete to true when no nodes have been selected,
-				// and emit an event if the plan just completed
-				if !complete.IsTrue(obj) {
-					recorder.Eventf(obj, corev1.EventTypeNormal, "Complete", "Jobs complete for version %s. Hash: %s",
-						obj.Status.LatestVersion, obj.Status.LatestHash)
+				source.enqueueAfter(time.Hour)
+			}
+			complete.SetError(obj, "Waiting", ErrOutsideWindow)
+			return nil, obj.Status, nil
+		}
+
+		// If the node list has changed, update Applying status with new node list and emit an event
+		if !slices.Equal(obj.Status.Applying, concurrentNodeNames) {
+			for i, node := range concurrentNodes {
+				if !slices.Contains(obj.Status.Applying, concurrentNodeNames[i]) {
+					ctl.cloudEvent(source, notify.CloudEventNodeStarted, node.Name, "Job synced on Node "+node.Name, time.Time{})
 				}
-				obj.Status.Applying = nil
-				complete.SetError(obj, "Complete", nil)
 			}
+			ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "SyncJob", "Jobs synced for version %s on Nodes %s. Hash: %s",
+				obj.Status.LatestVersion, strings.Join(concurrentNodeNames, ","), obj.Status.LatestHash)
+			obj.Status.Applying = concurrentNodeNames[:]
+			complete.False(obj)
+			complete.Message(obj, "")
+			complete.Reason(obj, "SyncJob")
+		}
+	} else if halted := upgradeplan.HaltedNodes(obj); len(halted) > 0 {
+		// Plans are not complete while Nodes that they have given up on halt them.
+		obj.Status.Applying = nil
+		complete.SetError(obj, "JobFailed", fmt.Errorf("Jobs failed on Nodes %s for version %s", strings.Join(halted, ","), obj.Status.LatestVersion))
+	} else {
+		// set PlanComplete to true when no nodes have been selected,
+		// and emit an event if the plan just completed. Plans that skipped nodes complete with failures.
+		reason, message, outcome := "Complete", "Jobs complete", tracing.OutcomeComplete
+		if len(obj.Status.Skipped) > 0 {
+			reason, message, outcome = "CompleteWithFailures", "Jobs complete, skipped Nodes "+strings.Join(obj.Status.Skipped, ","), tracing.OutcomeCompleteWithFailures
+		}
+		if !complete.IsTrue(obj) {
+			ctl.recorder.Eventf(source.object, corev1.EventTypeNormal, "Complete", "%s for version %s. Hash: %s",
+				message, obj.Status.LatestVersion, obj.Status.LatestHash)
+			ctl.cloudEvent(source, notify.CloudEventPlanCompleted, "", message, time.Time{})
+			// the rollout started when the latest hash last changed
+			var started time.Time
+			if obj.Status.RolloutStartedAt != nil {
+				started = obj.Status.RolloutStartedAt.Time
+			}
+			ctl.tracer.Rollout(source.tracingPlan(), outcome, started)
+		}
+		obj.Status.Applying = nil
+		complete.SetError(obj, reason, nil)
+	}
 
-			return objects, obj.Status, nil
-		},
-		&generic.GeneratingHandlerOptions{
-			AllowClusterScoped:            true,
-			NoOwnerReference:              true,
-			UniqueApplyForResourceVersion: true,
-		},
-	)
+	return objects, obj.Status, nil
+}
+
+// nodeLoads returns the number of Pods on each node that have not finished and are not owned by a DaemonSet.
+// Pods are read from a cache of Pods in all namespaces, which is started the first time that a plan selects the least
+// loaded nodes first, so that Pods are only watched cluster-wide if they are needed.
+func (ctl *Controller) nodeLoads(ctx context.Context) upgradeplan.NodeLoads {
+	return func() (map[string]int, error) {
+		ctl.podLoadsStarted.Do(func() {
+			ctl.podLoads, ctl.podLoadsErr = upgradenode.NewLoadInformer(ctl.kcs, ctl.resync)
+			if ctl.podLoadsErr == nil {
+				go ctl.podLoads.Run(ctx.Done())
+			}
+		})
+		if ctl.podLoadsErr != nil {
+			return nil, ctl.podLoadsErr
+		}
+		if !cache.WaitForCacheSync(ctx.Done(), ctl.podLoads.HasSynced) {
+			return nil, ctx.Err()
+		}
+		objs := ctl.podLoads.GetStore().List()
+		pods := make([]corev1.Pod, 0, len(objs))
+		for _, obj := range objs {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				pods = append(pods, *pod)
+			}
+		}
+		return upgradenode.Loads(pods), nil
+	}
+}
+
+// validateClusterPlanName returns an error if a Plan in the controller namespace has the same name as a ClusterPlan,
+// as the Jobs and Node labels for the two would conflict.
+func (ctl *Controller) validateClusterPlanName(name string) error {
+	_, err := ctl.upgradeFactory.Upgrade().V1().Plan().Cache().Get(ctl.Namespace, name)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	return fmt.Errorf("%w: %s/%s", ErrClusterPlanConflict, ctl.Namespace, name)
+}
+
+// filterApplying returns the nodes that the plan is already being applied on.
+func filterApplying(plan *upgradeapiv1.Plan, nodes []*corev1.Node) []*corev1.Node {
+	return slices.DeleteFunc(slices.Clone(nodes), func(node *corev1.Node) bool {
+		return !slices.Contains(plan.Status.Applying, upgradenode.Hostname(node))
+	})
+}
+
+// jobNotStarted returns true if the job has not yet been created, or has not yet created any pods.
+func jobNotStarted(jobCache batchctlv1.JobCache, job *batchv1.Job) (bool, error) {
+	existing, err := jobCache.Get(job.Namespace, job.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return existing.Status.Active+existing.Status.Succeeded+existing.Status.Failed == 0, nil
+}
+
+// blackoutError returns ErrInBlackout, annotated with the end time and reason of the blackout.
+func blackoutError(blackout *upgradeapiv1.BlackoutSpec) error {
+	if blackout.Reason == "" {
+		return fmt.Errorf("%w until %s", ErrInBlackout, blackout.End.UTC().Format(time.RFC3339))
+	}
+	return fmt.Errorf("%w until %s: %s", ErrInBlackout, blackout.End.UTC().Format(time.RFC3339), blackout.Reason)
+}
+
+// maintenance window events (potentially) trigger the plans that refer to them
+func (ctl *Controller) handleMaintenanceWindows(ctx context.Context) error {
+	ctl.upgradeFactory.Upgrade().V1().MaintenanceWindow().OnChange(ctx, ctl.Name, func(key string, obj *upgradeapiv1.MaintenanceWindow) (*upgradeapiv1.MaintenanceWindow, error) {
+		// plans referring to a deleted MaintenanceWindow are also enqueued, so that they report it as not found
+		if obj != nil {
+			if err := upgradeplan.ValidateMaintenanceWindow(obj); err != nil {
+				ctl.recorder.Eventf(obj, corev1.EventTypeWarning, "Invalid", "%v", err)
+			}
+		}
+		sources, err := ctl.listPlans()
+		if err != nil {
+			return obj, err
+		}
+		for _, source := range sources {
+			if plan := source.plan; plan.Spec.WindowRef == key {
+				source.logger("maintenance-windows").WithField("maintenanceWindow", key).Debug("Enqueuing sync of Plan from MaintenanceWindow")
+				source.enqueue()
+			}
+		}
+		return obj, nil
+	})
 
 	return nil
 }
@@ -0,0 +1,373 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradectl "github.com/rancher/system-upgrade-controller/pkg/generated/controllers/upgrade.cattle.io"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/notify"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/tracing"
+	corectl "github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("syncPlanJobs", func() {
+	var (
+		ctl      *Controller
+		recorder *record.FakeRecorder
+		plan     *upgradeapiv1.Plan
+		now      time.Time
+		enqueued []time.Duration
+	)
+
+	BeforeEach(func() {
+		// 04:00 on a Saturday
+		now = time.Date(2026, 1, 3, 4, 0, 0, 0, time.UTC)
+		enqueued = nil
+		recorder = record.NewFakeRecorder(100)
+		ctl = &Controller{
+			Namespace:      "system-upgrade",
+			Name:           "system-upgrade-controller",
+			planNamespaces: []string{"system-upgrade"},
+			now:            func() time.Time { return now },
+			recorder:       recorder,
+			tracer:         tracing.Noop(),
+			notifier:       notify.New("system-upgrade", "system-upgrade-controller", nil),
+		}
+		// the factories are never started; their caches are filled directly through the informer indexers
+		cfg := &rest.Config{Host: "https://127.0.0.1:6443"}
+		var err error
+		ctl.coreFactory, err = corectl.NewFactoryFromConfigWithOptions(cfg, &corectl.FactoryOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		factory, err := newNamespaceFactory(cfg, ctl.Namespace, 0)
+		Expect(err).ToNot(HaveOccurred())
+		ctl.namespaceFactories = map[string]*namespaceFactory{ctl.Namespace: factory}
+		ctl.upgradeFactory, err = upgradectl.NewFactoryFromConfigWithOptions(cfg, &corectl.FactoryOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= 2; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			Expect(ctl.coreFactory.Core().V1().Node().Informer().GetIndexer().Add(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					UID:    types.UID(name + "-uid"),
+					Labels: map[string]string{corev1.LabelHostname: name, "upgrade": "true"},
+				},
+			})).To(Succeed())
+		}
+
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade", UID: types.UID("test-plan-uid")},
+			Spec: upgradeapiv1.PlanSpec{
+				Concurrency:  2,
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"upgrade": "true"}},
+				Version:      "v1.0.0",
+				Upgrade:      &upgradeapiv1.ContainerSpec{Image: "test/image"},
+			},
+			Status: upgradeapiv1.PlanStatus{LatestVersion: "v1.0.0", LatestHash: "hash-1"},
+		}
+		upgradeapiv1.PlanSpecValidated.True(plan)
+		upgradeapiv1.PlanLatestResolved.True(plan)
+	})
+
+	// syncSource runs syncPlanJobs for the source, updating the status of its plan, and returns the Jobs by node.
+	syncSource := func(source planSource) map[string]*batchv1.Job {
+		source.enqueue = func() {}
+		source.enqueueAfter = func(duration time.Duration) { enqueued = append(enqueued, duration) }
+		var (
+			objects []runtime.Object
+			err     error
+		)
+		objects, source.plan.Status, err = ctl.syncPlanJobs(context.Background(), source.plan.Status, source)
+		Expect(err).ToNot(HaveOccurred())
+		jobs := map[string]*batchv1.Job{}
+		for _, object := range objects {
+			job := object.(*batchv1.Job)
+			jobs[job.Labels[upgradeapi.LabelNode]] = job
+		}
+		return jobs
+	}
+
+	// sync runs syncPlanJobs for the plan, updating its status, and returns the Jobs by node.
+	sync := func() map[string]*batchv1.Job {
+		return syncSource(planSource{plan: plan, object: plan})
+	}
+
+	// reasons returns the reasons of the events recorded so far.
+	reasons := func() []string {
+		var reasons []string
+		for {
+			select {
+			case event := <-recorder.Events:
+				var eventType, reason string
+				fmt.Sscan(event, &eventType, &reason)
+				reasons = append(reasons, reason)
+			default:
+				return reasons
+			}
+		}
+	}
+
+	// addJob adds the Job for the plan on the node to the Job cache, with the given number of active pods.
+	addJob := func(nodeName string, active int32) {
+		node, err := ctl.coreFactory.Core().V1().Node().Cache().Get(nodeName)
+		Expect(err).ToNot(HaveOccurred())
+		job := upgradejob.New(plan, node, ctl.Namespace, ctl.Name)
+		job.Status.Active = active
+		Expect(ctl.jobs(ctl.Namespace).Informer().GetIndexer().Add(job)).To(Succeed())
+	}
+
+	Describe("windows", func() {
+		BeforeEach(func() {
+			plan.Spec.Window = &upgradeapiv1.TimeWindowSpec{Days: []upgradeapiv1.Day{"saturday", "sunday"}, StartTime: "01:00", EndTime: "03:00"}
+		})
+
+		It("should let Jobs start on new nodes after the window closes, while nodes are still applying", func() {
+			plan.Status.Applying = []string{"node-1"}
+			Expect(sync()).To(HaveKey("node-2"))
+			Expect(plan.Status.Applying).To(Equal([]string{"node-1", "node-2"}))
+		})
+
+		It("should keep the changes to the status while waiting for the window", func() {
+			plan.Spec.OnNodeFailure = upgradeapiv1.NodeFailureSkip
+			plan.Status.Failures = []upgradeapiv1.NodeFailure{{Node: "node-2", Job: "test-job", Hash: "hash-1", FailedAt: metav1.NewTime(now)}}
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanComplete.GetReason(plan)).To(Equal("Waiting"))
+			Expect(plan.Status.Skipped).To(Equal([]string{"node-2"}))
+			// checked again when the window opens on Sunday
+			Expect(enqueued).To(ContainElement(21 * time.Hour))
+		})
+
+		It("should not start Jobs on new nodes once a window that enforces its end has closed", func() {
+			plan.Spec.Window.EnforceEnd = true
+			plan.Status.Applying = []string{"node-1"}
+			jobs := sync()
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs).To(HaveKey("node-1"))
+			Expect(plan.Status.Applying).To(Equal([]string{"node-1"}))
+			// checked again when the window opens on Sunday
+			Expect(enqueued).To(ContainElement(21 * time.Hour))
+		})
+
+		It("should wait for the window once all nodes have completed, if it enforces its end", func() {
+			plan.Spec.Window.EnforceEnd = true
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanComplete.GetReason(plan)).To(Equal("Waiting"))
+			Expect(reasons()).To(ConsistOf("Waiting"))
+			Expect(enqueued).To(ContainElement(21 * time.Hour))
+		})
+
+		It("should keep Jobs that have not started suspended while the window is closed", func() {
+			plan.Spec.Window.EnforceEnd = true
+			plan.Spec.Window.SuspendPending = true
+			plan.Status.Applying = []string{"node-1", "node-2"}
+			addJob("node-1", 1)
+			addJob("node-2", 0)
+			jobs := sync()
+			Expect(*jobs["node-1"].Spec.Parallelism).To(BeEquivalentTo(1))
+			Expect(*jobs["node-2"].Spec.Parallelism).To(BeEquivalentTo(0))
+
+			// once the window opens, the Job is resumed
+			now = now.Add(21 * time.Hour)
+			jobs = sync()
+			Expect(*jobs["node-2"].Spec.Parallelism).To(BeEquivalentTo(1))
+		})
+
+		It("should not suspend Jobs unless the window enforces its end", func() {
+			plan.Spec.Window.SuspendPending = true
+			plan.Status.Applying = []string{"node-1", "node-2"}
+			addJob("node-2", 0)
+			jobs := sync()
+			Expect(*jobs["node-2"].Spec.Parallelism).To(BeEquivalentTo(1))
+		})
+	})
+
+	Describe("notBefore and notAfter", func() {
+		BeforeEach(func() {
+			plan.Spec.NotBefore = &metav1.Time{Time: now}
+			plan.Spec.NotAfter = &metav1.Time{Time: now.Add(time.Hour)}
+		})
+
+		It("should wait for notBefore, and check again when it passes", func() {
+			now = now.Add(-time.Minute)
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanComplete.GetReason(plan)).To(Equal("Waiting"))
+			Expect(reasons()).To(ConsistOf("Waiting"))
+			Expect(enqueued).To(Equal([]time.Duration{time.Minute}))
+		})
+
+		It("should start Jobs at notBefore", func() {
+			Expect(sync()).To(HaveLen(2))
+			Expect(plan.Status.Applying).To(Equal([]string{"node-1", "node-2"}))
+		})
+
+		It("should start Jobs until just before notAfter", func() {
+			now = now.Add(time.Hour - time.Nanosecond)
+			Expect(sync()).To(HaveLen(2))
+		})
+
+		It("should expire at notAfter", func() {
+			now = now.Add(time.Hour)
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanComplete.GetReason(plan)).To(Equal("Expired"))
+			Expect(reasons()).To(ConsistOf("Expired"))
+
+			// the event is only recorded once
+			Expect(sync()).To(BeEmpty())
+			Expect(reasons()).To(BeEmpty())
+		})
+
+		It("should let Jobs on nodes already applying continue after notAfter", func() {
+			now = now.Add(2 * time.Hour)
+			plan.Status.Applying = []string{"node-1"}
+			jobs := sync()
+			Expect(jobs).To(HaveLen(1))
+			Expect(jobs).To(HaveKey("node-1"))
+			Expect(plan.Status.Applying).To(Equal([]string{"node-1"}))
+		})
+	})
+
+	Describe("approval", func() {
+		BeforeEach(func() {
+			plan.Spec.Approval = upgradeapiv1.ApprovalManual
+		})
+
+		It("should wait for the latest hash to be approved", func() {
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanAwaitingApproval.IsTrue(plan)).To(BeTrue())
+			Expect(upgradeapiv1.PlanComplete.GetReason(plan)).To(Equal("AwaitingApproval"))
+			Expect(reasons()).To(ConsistOf("AwaitingApproval"))
+		})
+
+		It("should start Jobs once the hash is approved, and keep the approval once the annotation is removed", func() {
+			Expect(sync()).To(BeEmpty())
+			plan.Annotations = map[string]string{upgradeapi.AnnotationApprovedHash: "hash-1"}
+			Expect(sync()).To(HaveLen(2))
+			Expect(plan.Status.ApprovedHash).To(Equal("hash-1"))
+			Expect(upgradeapiv1.PlanAwaitingApproval.IsFalse(plan)).To(BeTrue())
+			Expect(reasons()).To(Equal([]string{"AwaitingApproval", "Approved", "SyncJob"}))
+
+			plan.Annotations = nil
+			Expect(sync()).To(HaveLen(2))
+		})
+
+		It("should not start Jobs for an approved hash that is not the latest", func() {
+			plan.Annotations = map[string]string{upgradeapi.AnnotationApprovedHash: "hash-0"}
+			plan.Status.ApprovedHash = "hash-0"
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanAwaitingApproval.IsTrue(plan)).To(BeTrue())
+		})
+
+		It("should require approval again once the spec changes", func() {
+			plan.Annotations = map[string]string{upgradeapi.AnnotationApprovedHash: "hash-1"}
+			Expect(sync()).To(HaveLen(2))
+			Expect(reasons()).To(ConsistOf("SyncJob"))
+
+			// the Jobs for the approved hash complete, and the spec is then changed
+			plan.Status.Applying = nil
+			plan.Status.LatestHash = "hash-2"
+			Expect(sync()).To(BeEmpty())
+			Expect(plan.Status.ApprovedHash).To(Equal("hash-1"))
+			Expect(upgradeapiv1.PlanAwaitingApproval.IsTrue(plan)).To(BeTrue())
+			Expect(reasons()).To(ConsistOf("AwaitingApproval"))
+
+			plan.Annotations[upgradeapi.AnnotationApprovedHash] = "hash-2"
+			Expect(sync()).To(HaveLen(2))
+			Expect(plan.Status.ApprovedHash).To(Equal("hash-2"))
+		})
+
+		It("should not wait for approval unless it is manual", func() {
+			plan.Spec.Approval = ""
+			Expect(sync()).To(HaveLen(2))
+			Expect(plan.Status.ApprovedHash).To(BeEmpty())
+		})
+	})
+
+	Describe("ClusterPlans", func() {
+		var clusterPlan *upgradeapiv1.ClusterPlan
+
+		BeforeEach(func() {
+			clusterPlan = &upgradeapiv1.ClusterPlan{
+				ObjectMeta: metav1.ObjectMeta{Name: plan.Name, UID: plan.UID},
+				Spec:       plan.Spec,
+				Status:     plan.Status,
+			}
+		})
+
+		It("should create Jobs in the controller namespace, labelled with the ClusterPlan", func() {
+			source := ctl.clusterPlanSource(clusterPlan)
+			jobs := syncSource(source)
+			Expect(jobs).To(HaveLen(2))
+			for _, job := range jobs {
+				Expect(job.Namespace).To(Equal(ctl.Namespace))
+				Expect(job.Labels).To(HaveKeyWithValue(upgradeapi.LabelClusterPlan, clusterPlan.Name))
+				Expect(job.Labels).To(HaveKeyWithValue(upgradeapi.LabelPlan, clusterPlan.Name))
+			}
+			Expect(source.plan.Status.Applying).To(Equal([]string{"node-1", "node-2"}))
+		})
+
+		It("should not label the Jobs of Plans with a ClusterPlan", func() {
+			for _, job := range sync() {
+				Expect(job.Labels).ToNot(HaveKey(upgradeapi.LabelClusterPlan))
+			}
+		})
+
+		It("should reject a ClusterPlan with the same name as a Plan in the controller namespace", func() {
+			plans := ctl.upgradeFactory.Upgrade().V1().Plan().Informer().GetIndexer()
+			Expect(plans.Add(&upgradeapiv1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant", Name: clusterPlan.Name}})).To(Succeed())
+			Expect(ctl.validateClusterPlanName(clusterPlan.Name)).To(Succeed())
+
+			Expect(plans.Add(&upgradeapiv1.Plan{ObjectMeta: metav1.ObjectMeta{Namespace: ctl.Namespace, Name: clusterPlan.Name}})).To(Succeed())
+			Expect(ctl.validateClusterPlanName(clusterPlan.Name)).To(MatchError(ErrClusterPlanConflict))
+			Expect(ctl.validateClusterPlanName("other-plan")).To(Succeed())
+
+			// the ClusterPlan is reported as invalid, and no Jobs are created for it
+			source := ctl.clusterPlanSource(clusterPlan)
+			status, err := ctl.syncPlanStatus(context.Background(), source.plan.Status, source)
+			Expect(err).ToNot(HaveOccurred())
+			source.plan.Status = status
+			Expect(upgradeapiv1.PlanSpecValidated.IsFalse(source.plan)).To(BeTrue())
+			Expect(upgradeapiv1.PlanSpecValidated.GetMessage(source.plan)).To(ContainSubstring(ErrClusterPlanConflict.Error()))
+			Expect(reasons()).To(ConsistOf("ValidateFailed"))
+			Expect(syncSource(source)).To(BeEmpty())
+			Expect(upgradeapiv1.PlanComplete.GetReason(source.plan)).To(Equal("NotReady"))
+		})
+	})
+
+	Describe("tracing", func() {
+		It("should record the rollout from when the latest hash changed", func() {
+			spans := tracetest.NewSpanRecorder()
+			ctl.tracer = tracing.New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans), sdktrace.WithIDGenerator(tracing.IDGenerator())))
+			rolloutStartedAt := metav1.NewTime(time.Now().Add(-time.Hour))
+			plan.Status.RolloutStartedAt = &rolloutStartedAt
+			// the plan has been applied on all nodes
+			for _, name := range []string{"node-1", "node-2"} {
+				node, err := ctl.coreFactory.Core().V1().Node().Cache().Get(name)
+				Expect(err).ToNot(HaveOccurred())
+				node.Labels[upgradejob.LabelPlanName(plan, ctl.Namespace)] = plan.Status.LatestHash
+			}
+			// the complete condition was last updated for an unrelated reason
+			upgradeapiv1.PlanComplete.SetError(plan, "Waiting", ErrOutsideWindow)
+
+			Expect(sync()).To(BeEmpty())
+			Expect(upgradeapiv1.PlanComplete.IsTrue(plan)).To(BeTrue())
+			Expect(spans.Ended()).To(HaveLen(1))
+			Expect(spans.Ended()[0].Name()).To(Equal("Rollout Plan"))
+			Expect(spans.Ended()[0].StartTime()).To(BeTemporally("==", rolloutStartedAt.Time))
+		})
+	})
+})
@@ -31,7 +31,7 @@ func TestNew(t *testing.T) {
 					},
 				},
 			}
-			job := New(plan, node, "ctr")
+			job := New(plan, node, "system-upgrade", "ctr")
 			t.Logf("%#v", job.Spec.Template.Spec.InitContainers)
 			for _, container := range job.Spec.Template.Spec.InitContainers {
 				if container.Name == "drain" {
@@ -28,6 +28,9 @@ const (
 	defaultActiveDeadlineSeconds   = int64(600)
 	defaultPrivileged              = true
 	defaultKubectlImage            = "rancher/kubectl:v1.30.3"
+	defaultRebootImage             = "rancher/mirrored-library-busybox:1.36.1"
+	defaultRebootSentinel          = "/var/run/reboot-required"
+	defaultRebootTimeout           = 15 * time.Minute
 	defaultImagePullPolicy         = corev1.PullIfNotPresent
 	defaultTTLSecondsAfterFinished = int32(900)
 	defaultPodReplacementPolicy    = batchv1.PodReplacementPolicy("TerminatingOrFailed")
@@ -85,6 +88,13 @@ var (
 		return defaultValue
 	}(defaultKubectlImage)
 
+	RebootImage = func(defaultValue string) string {
+		if str := os.Getenv("SYSTEM_UPGRADE_JOB_REBOOT_IMAGE"); str != "" {
+			return str
+		}
+		return defaultValue
+	}(defaultRebootImage)
+
 	KubectlImageWindows = func() string {
 		return os.Getenv("SYSTEM_UPGRADE_JOB_KUBECTL_IMAGE_WINDOWS")
 	}()
@@ -133,10 +143,81 @@ var (
 	ConditionFailed   = condition.Cond(batchv1.JobFailed)
 )
 
-func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *batchv1.Job {
-	exclusiveString := strconv.FormatBool(plan.Spec.Exclusive)
+const (
+	// RebootContainerName is the name of the container that schedules a reboot of the Node, when enabled.
+	RebootContainerName = "reboot"
+
+	// rebootScheduled and rebootNotRequired prefix the termination message of the reboot container.
+	rebootScheduled   = "Scheduled"
+	rebootNotRequired = "NotRequired"
+
+	// rebootScript checks the reboot sentinel if required, and schedules a reboot of the host that will
+	// occur after the container has exited, so that the Job can complete. The current boot ID is written
+	// to the termination message, so that the controller can tell when the Node has come back up.
+	rebootScript = `set -e
+if [ "$REBOOT_POLICY" = "IfRequired" ] && [ ! -e "/host$REBOOT_SENTINEL" ]; then
+  echo "Reboot sentinel $REBOOT_SENTINEL not found"
+  echo -n "` + rebootNotRequired + `" > /dev/termination-log
+  exit 0
+fi
+BOOT_ID=$(cat /proc/sys/kernel/random/boot_id)
+nsenter -t 1 -m -u -i -n -p -- sh -c 'if command -v systemd-run >/dev/null; then systemd-run --on-active=30 systemctl reboot; else shutdown -r +1; fi'
+echo "Reboot scheduled from boot $BOOT_ID"
+echo -n "` + rebootScheduled + ` $BOOT_ID" > /dev/termination-log
+`
+)
+
+const (
+	// NotExclusive is the exclusive group label value of Jobs for plans that are not exclusive.
+	NotExclusive = "false"
+	// DefaultExclusiveGroup is the exclusive group of exclusive plans that do not set a group. It matches the label
+	// value of Jobs for exclusive plans created before groups were introduced, so that they continue to exclude each other.
+	DefaultExclusiveGroup = "true"
+)
+
+// ExclusiveGroup returns the exclusive group of the plan, which Jobs are labeled with, or NotExclusive.
+func ExclusiveGroup(plan *upgradeapiv1.Plan) string {
+	switch {
+	case plan.Spec.ExclusiveGroup != "":
+		return plan.Spec.ExclusiveGroup
+	case plan.Spec.Exclusive:
+		return DefaultExclusiveGroup
+	default:
+		return NotExclusive
+	}
+}
+
+// LabelName returns the name that the node labels of the plan in the given namespace are composed with. Plans in
+// the controller namespace, which includes ClusterPlans, are labeled by name alone, as they always have been. Plans in
+// other namespaces are also labeled by namespace, joined by an underscore, which names and namespaces cannot contain;
+// so that a plan cannot set or select the node labels of a plan with the same name in another namespace.
+func LabelName(namespace, planName, controllerNamespace string) string {
+	if namespace == controllerNamespace {
+		return planName
+	}
+	return name.Limit(namespace+"_"+planName, 63)
+}
+
+// LabelPlanName returns the label that records the hash of the plan last applied on a node, and that Jobs for the
+// plan are labeled with.
+func LabelPlanName(plan *upgradeapiv1.Plan, controllerNamespace string) string {
+	return upgradeapi.LabelPlanName(LabelName(plan.Namespace, plan.Name, controllerNamespace))
+}
+
+// LabelRebootName returns the label that marks a node as requiring a reboot by the plan.
+func LabelRebootName(plan *upgradeapiv1.Plan, controllerNamespace string) string {
+	return upgradeapi.LabelRebootName(LabelName(plan.Namespace, plan.Name, controllerNamespace))
+}
+
+// AnnotationRebootedName returns the annotation that records when the reboot label of a node was last cleared by the plan.
+func AnnotationRebootedName(plan *upgradeapiv1.Plan, controllerNamespace string) string {
+	return upgradeapi.AnnotationRebootedName(LabelName(plan.Namespace, plan.Name, controllerNamespace))
+}
+
+func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerNamespace, controllerName string) *batchv1.Job {
+	exclusiveGroup := ExclusiveGroup(plan)
 	hostPathDirectory := corev1.HostPathDirectory
-	labelPlanName := upgradeapi.LabelPlanName(plan.Name)
+	labelPlanName := LabelPlanName(plan, controllerNamespace)
 	nodeHostname := upgradenode.Hostname(node)
 	shortNodeName := strings.SplitN(node.Name, ".", 2)[0]
 	ttlSecondsAfterFinished := TTLSecondsAfterFinished
@@ -152,6 +233,15 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 		}
 	}
 
+	// Likewise, ensure that the job is retained for long enough to wait for the node to reboot.
+	if RebootEnabled(plan) {
+		ttlRebootTimeout := RebootTimeout(plan) + time.Minute
+		ttlAfterFinished := time.Duration(ttlSecondsAfterFinished) * time.Second
+		if ttlAfterFinished < ttlRebootTimeout {
+			ttlSecondsAfterFinished = int32(ttlRebootTimeout.Seconds())
+		}
+	}
+
 	jobAnnotations := labels.Set{
 		upgradeapi.AnnotationTTLSecondsAfterFinished: strconv.FormatInt(int64(ttlSecondsAfterFinished), 10),
 	}
@@ -166,7 +256,7 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 
 	jobLabels := labels.Set{
 		upgradeapi.LabelController: controllerName,
-		upgradeapi.LabelExclusive:  exclusiveString,
+		upgradeapi.LabelExclusive:  exclusiveGroup,
 		upgradeapi.LabelNode:       node.Name,
 		upgradeapi.LabelPlan:       plan.Name,
 		upgradeapi.LabelVersion:    plan.Status.LatestVersion,
@@ -271,18 +361,20 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 		*job.Spec.Parallelism = 1
 	}
 
-	if plan.Spec.Exclusive {
+	if exclusiveGroup != NotExclusive {
 		job.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = []corev1.PodAffinityTerm{{
 			LabelSelector: &metav1.LabelSelector{
 				MatchExpressions: []metav1.LabelSelectorRequirement{{
 					Key:      upgradeapi.LabelExclusive,
 					Operator: metav1.LabelSelectorOpIn,
 					Values: []string{
-						exclusiveString,
+						exclusiveGroup,
 					},
 				}},
 			},
-			TopologyKey: corev1.LabelHostname,
+			// exclusive plans in other namespaces are also considered
+			NamespaceSelector: &metav1.LabelSelector{},
+			TopologyKey:       corev1.LabelHostname,
 		}}
 	}
 
@@ -303,7 +395,11 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 	}
 
 	// add volumes from upgrade plan
-	for _, v := range plan.Spec.Upgrade.Volumes {
+	var upgradeVolumes []upgradeapiv1.VolumeSpec
+	if plan.Spec.Upgrade != nil {
+		upgradeVolumes = plan.Spec.Upgrade.Volumes
+	}
+	for _, v := range upgradeVolumes {
 		podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, corev1.Volume{
 			Name: v.Name,
 			VolumeSource: corev1.VolumeSource{
@@ -314,6 +410,39 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 		})
 	}
 
+	// add secret and host path volumes for steps, skipping any that are shared with the plan or another step
+	for _, step := range plan.Spec.Steps {
+		for _, secret := range step.Secrets {
+			volumeName := name.SafeConcatName("secret", secret.Name)
+			if hasVolume(podTemplate.Spec.Volumes, volumeName) {
+				continue
+			}
+			podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, corev1.Volume{
+				Name: volumeName,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName:  secret.Name,
+						DefaultMode: secret.DefaultMode,
+						Optional:    pointer.Bool(secret.IgnoreUpdates),
+					},
+				},
+			})
+		}
+		for _, v := range step.Volumes {
+			if hasVolume(podTemplate.Spec.Volumes, v.Name) {
+				continue
+			}
+			podTemplate.Spec.Volumes = append(podTemplate.Spec.Volumes, corev1.Volume{
+				Name: v.Name,
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: v.Source,
+					},
+				},
+			})
+		}
+	}
+
 	// Determine if the target node is Windows
 	isWindows := node.Labels["kubernetes.io/os"] == "windows"
 
@@ -404,7 +533,7 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 			upgradectr.WithSecrets(plan.Spec.Secrets),
 			upgradectr.WithPlanEnvironment(plan.Name, plan.Status),
 			upgradectr.WithImagePullPolicy(ImagePullPolicy),
-			upgradectr.WithVolumes(plan.Spec.Upgrade.Volumes),
+			upgradectr.WithVolumes(upgradeVolumes),
 		)
 
 		if isWindows {
@@ -425,7 +554,7 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 			upgradectr.WithSecrets(plan.Spec.Secrets),
 			upgradectr.WithPlanEnvironment(plan.Name, plan.Status),
 			upgradectr.WithImagePullPolicy(ImagePullPolicy),
-			upgradectr.WithVolumes(plan.Spec.Upgrade.Volumes),
+			upgradectr.WithVolumes(upgradeVolumes),
 		)
 		if isWindows {
 			cordonContainer.SecurityContext = &corev1.SecurityContext{
@@ -441,41 +570,63 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 		podTemplate.Spec.InitContainers = append(podTemplate.Spec.InitContainers, cordonContainer)
 	}
 
-	// Check if SecurityContext from the Plan is non-nil
-	var securityContext *corev1.SecurityContext
-	if plan.Spec.Upgrade.SecurityContext != nil {
-		securityContext = plan.Spec.Upgrade.SecurityContext
-	} else if isWindows {
-		// Set Windows-specific security context (HostProcess, run as SYSTEM)
-		securityContext = &corev1.SecurityContext{
-			WindowsOptions: &corev1.WindowsSecurityContextOptions{
-				RunAsUserName: pointer.String("NT AUTHORITY\\SYSTEM"),
-				HostProcess:   pointer.Bool(true),
-			},
-		}
-	} else {
-		securityContext = &corev1.SecurityContext{
-			Privileged: &Privileged,
-			Capabilities: &corev1.Capabilities{
-				Add: []corev1.Capability{
-					corev1.Capability("CAP_SYS_BOOT"),
-				},
-			},
-		}
+	// then we run the steps, in order
+	for _, step := range plan.Spec.Steps {
+		podTemplate.Spec.InitContainers = append(podTemplate.Spec.InitContainers,
+			upgradectr.New(StepContainerName(step.Name), step.ContainerSpec,
+				upgradectr.WithLatestTag(plan.Status.LatestVersion),
+				upgradectr.WithSecurityContext(securityContext(step.SecurityContext, isWindows)),
+				upgradectr.WithSecrets(plan.Spec.Secrets),
+				upgradectr.WithSecrets(step.Secrets),
+				upgradectr.WithPlanEnvironment(plan.Name, plan.Status),
+				upgradectr.WithImagePullPolicy(ImagePullPolicy),
+				upgradectr.WithVolumes(step.Volumes),
+			),
+		)
 	}
 
 	// and finally, we upgrade
-	podTemplate.Spec.Containers = []corev1.Container{
-		upgradectr.New("upgrade", *plan.Spec.Upgrade,
+	var containers []corev1.Container
+	if plan.Spec.Upgrade != nil {
+		containers = append(containers, upgradectr.New("upgrade", *plan.Spec.Upgrade,
 			upgradectr.WithLatestTag(plan.Status.LatestVersion),
-			upgradectr.WithSecurityContext(securityContext),
+			upgradectr.WithSecurityContext(securityContext(plan.Spec.Upgrade.SecurityContext, isWindows)),
 			upgradectr.WithSecrets(plan.Spec.Secrets),
 			upgradectr.WithPlanEnvironment(plan.Name, plan.Status),
 			upgradectr.WithImagePullPolicy(ImagePullPolicy),
-			upgradectr.WithVolumes(plan.Spec.Upgrade.Volumes),
-		),
+			upgradectr.WithVolumes(upgradeVolumes),
+		))
+	}
+
+	// reboot is not supported on Windows nodes; this is reported by the controller when the node is selected
+	if RebootEnabled(plan) && !isWindows {
+		rebootPolicy := plan.Spec.Reboot.Policy
+		if RebootOnly(plan) {
+			rebootPolicy = upgradeapiv1.RebootIfRequired
+		}
+		// the upgrade container must complete before the reboot is scheduled, so run it as the last init container
+		podTemplate.Spec.InitContainers = append(podTemplate.Spec.InitContainers, containers...)
+		containers = []corev1.Container{
+			upgradectr.New(RebootContainerName, upgradeapiv1.ContainerSpec{
+				Image:   RebootImage,
+				Command: []string{"sh", "-c", rebootScript},
+				Env: []corev1.EnvVar{{
+					Name:  "REBOOT_POLICY",
+					Value: string(rebootPolicy),
+				}, {
+					Name:  "REBOOT_SENTINEL",
+					Value: RebootSentinel(plan),
+				}},
+			},
+				upgradectr.WithSecurityContext(securityContext(nil, isWindows)),
+				upgradectr.WithPlanEnvironment(plan.Name, plan.Status),
+				upgradectr.WithImagePullPolicy(ImagePullPolicy),
+			),
+		}
 	}
 
+	podTemplate.Spec.Containers = containers
+
 	if plan.Spec.JobActiveDeadlineSecs == nil {
 		// nil means default from controller
 		job.Spec.ActiveDeadlineSeconds = pointer.Int64(ActiveDeadlineSeconds)
@@ -504,6 +655,10 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 		}
 	}
 
+	if plan.Spec.PodTemplate != nil {
+		applyPodTemplate(podTemplate, plan.Spec.PodTemplate)
+	}
+
 	if drain != nil && drain.Timeout != nil && job.Spec.ActiveDeadlineSeconds != nil {
 		var timeout time.Duration
 		switch drain.Timeout.Type {
@@ -519,3 +674,216 @@ func New(plan *upgradeapiv1.Plan, node *corev1.Node, controllerName string) *bat
 
 	return job
 }
+
+// RebootEnabled returns true if the Plan reboots the Node after the upgrade container completes.
+func RebootEnabled(plan *upgradeapiv1.Plan) bool {
+	reboot := plan.Spec.Reboot
+	return reboot != nil && (reboot.Only || reboot.Policy != "" && reboot.Policy != upgradeapiv1.RebootNever)
+}
+
+// RebootOnly returns true if the Plan only reboots Nodes that report the reboot sentinel.
+func RebootOnly(plan *upgradeapiv1.Plan) bool {
+	return plan.Spec.Reboot != nil && plan.Spec.Reboot.Only
+}
+
+// RebootSentinel returns the path of the reboot sentinel file on the host.
+func RebootSentinel(plan *upgradeapiv1.Plan) string {
+	if reboot := plan.Spec.Reboot; reboot != nil && reboot.Sentinel != "" {
+		return reboot.Sentinel
+	}
+	return defaultRebootSentinel
+}
+
+// RebootTimeout returns the time to wait for the Node to come back after a reboot.
+func RebootTimeout(plan *upgradeapiv1.Plan) time.Duration {
+	if reboot := plan.Spec.Reboot; reboot != nil && reboot.Timeout != nil {
+		return reboot.Timeout.Duration
+	}
+	return defaultRebootTimeout
+}
+
+// ScheduledReboot returns the boot ID of the Node at the time that the Job Pod scheduled a reboot.
+// If the Pod did not schedule a reboot, false is returned.
+func ScheduledReboot(pod *corev1.Pod) (string, bool) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != RebootContainerName || status.State.Terminated == nil {
+			continue
+		}
+		if bootID, ok := strings.CutPrefix(status.State.Terminated.Message, rebootScheduled+" "); ok {
+			return bootID, true
+		}
+	}
+	return "", false
+}
+
+// StepContainerName returns the name of the init container that runs the named step.
+func StepContainerName(step string) string {
+	return name.SafeConcatName("step", step)
+}
+
+// CurrentStep returns the name of the plan step that the Job Pod is running, or has failed at.
+// An empty string is returned if the Pod is not running a step.
+func CurrentStep(plan *upgradeapiv1.Plan, pod *corev1.Pod) string {
+	for _, status := range pod.Status.InitContainerStatuses {
+		running := status.State.Running != nil
+		failed := status.State.Terminated != nil && status.State.Terminated.ExitCode != 0
+		if !running && !failed {
+			continue
+		}
+		for _, step := range plan.Spec.Steps {
+			if status.Name == StepContainerName(step.Name) {
+				return step.Name
+			}
+		}
+	}
+	return ""
+}
+
+// FailedContainer returns the name and terminated state of the first container of the Job Pod that terminated with a
+// non-zero exit code, checking init containers first. If the container has restarted since, its last termination is
+// returned, and previous is true: the logs of the failed run are those of the previous instance of the container.
+// An empty name is returned if no container has failed.
+func FailedContainer(pod *corev1.Pod) (name string, terminated *corev1.ContainerStateTerminated, previous bool) {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				return status.Name, terminated, false
+			}
+			if terminated := status.LastTerminationState.Terminated; terminated != nil && terminated.ExitCode != 0 {
+				return status.Name, terminated, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// Truncate returns the first max bytes of the string, followed by an ellipsis if it was truncated.
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "") + "..."
+}
+
+// TruncateTail returns the last max bytes of the string, starting at a line boundary if possible,
+// preceded by an ellipsis if it was truncated. This is used to keep the end of logs.
+func TruncateTail(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[len(s)-max:]
+	if i := strings.IndexByte(s, '\n'); i >= 0 && i < len(s)-1 {
+		s = s[i+1:]
+	}
+	return "..." + strings.ToValidUTF8(s, "")
+}
+
+// securityContext returns the given security context if it is set; otherwise the default
+// for the upgrade container is returned.
+func securityContext(securityContext *corev1.SecurityContext, isWindows bool) *corev1.SecurityContext {
+	if securityContext != nil {
+		return securityContext
+	}
+	if isWindows {
+		// Set Windows-specific security context (HostProcess, run as SYSTEM)
+		return &corev1.SecurityContext{
+			WindowsOptions: &corev1.WindowsSecurityContextOptions{
+				RunAsUserName: pointer.String("NT AUTHORITY\\SYSTEM"),
+				HostProcess:   pointer.Bool(true),
+			},
+		}
+	}
+	return &corev1.SecurityContext{
+		Privileged: &Privileged,
+		Capabilities: &corev1.Capabilities{
+			Add: []corev1.Capability{
+				corev1.Capability("CAP_SYS_BOOT"),
+			},
+		},
+	}
+}
+
+func hasVolume(volumes []corev1.Volume, volumeName string) bool {
+	return slices.ContainsFunc(volumes, func(volume corev1.Volume) bool {
+		return volume.Name == volumeName
+	})
+}
+
+// applyPodTemplate overlays the Plan's pod template overrides onto the default Job Pod template.
+func applyPodTemplate(podTemplate *corev1.PodTemplateSpec, overrides *upgradeapiv1.PodTemplateSpec) {
+	// the template labels are shared with the Job, copy them before adding to them
+	podLabels := labels.Set{}
+	for key, value := range podTemplate.Labels {
+		podLabels[key] = value
+	}
+	for key, value := range overrides.Labels {
+		if _, ok := podLabels[key]; !ok {
+			podLabels[key] = value
+		}
+	}
+	podTemplate.Labels = podLabels
+
+	for key, value := range overrides.Annotations {
+		if _, ok := podTemplate.Annotations[key]; !ok {
+			podTemplate.Annotations[key] = value
+		}
+	}
+
+	if overrides.HostIPC != nil {
+		podTemplate.Spec.HostIPC = *overrides.HostIPC
+	}
+	if overrides.HostPID != nil {
+		podTemplate.Spec.HostPID = *overrides.HostPID
+	}
+	if overrides.HostNetwork != nil {
+		podTemplate.Spec.HostNetwork = *overrides.HostNetwork
+		if !podTemplate.Spec.HostNetwork {
+			podTemplate.Spec.DNSPolicy = corev1.DNSClusterFirst
+		}
+	}
+	if overrides.DNSPolicy != "" {
+		podTemplate.Spec.DNSPolicy = overrides.DNSPolicy
+	}
+	if overrides.RuntimeClassName != nil {
+		podTemplate.Spec.RuntimeClassName = overrides.RuntimeClassName
+	}
+
+	if len(overrides.NodeAffinity) > 0 {
+		nodeSelector := podTemplate.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		for i := range nodeSelector.NodeSelectorTerms {
+			nodeSelector.NodeSelectorTerms[i].MatchExpressions = append(nodeSelector.NodeSelectorTerms[i].MatchExpressions, overrides.NodeAffinity...)
+		}
+	}
+
+	if overrides.HostRoot != nil && !*overrides.HostRoot {
+		podTemplate.Spec.Volumes = slices.DeleteFunc(podTemplate.Spec.Volumes, func(volume corev1.Volume) bool {
+			return volume.Name == "host-root"
+		})
+		isHostRoot := func(volumeMount corev1.VolumeMount) bool {
+			return volumeMount.Name == "host-root"
+		}
+		for i := range podTemplate.Spec.InitContainers {
+			podTemplate.Spec.InitContainers[i].VolumeMounts = slices.DeleteFunc(podTemplate.Spec.InitContainers[i].VolumeMounts, isHostRoot)
+		}
+		for i := range podTemplate.Spec.Containers {
+			podTemplate.Spec.Containers[i].VolumeMounts = slices.DeleteFunc(podTemplate.Spec.Containers[i].VolumeMounts, isHostRoot)
+		}
+	}
+
+	// sidecars are restartable init containers, and must come first so that
+	// they are started before the prepare, cordon/drain and upgrade containers.
+	if len(overrides.Sidecars) > 0 {
+		sidecars := make([]corev1.Container, len(overrides.Sidecars))
+		for i := range overrides.Sidecars {
+			overrides.Sidecars[i].DeepCopyInto(&sidecars[i])
+			if sidecars[i].RestartPolicy == nil {
+				restartPolicy := corev1.ContainerRestartPolicyAlways
+				sidecars[i].RestartPolicy = &restartPolicy
+			}
+			if sidecars[i].ImagePullPolicy == "" {
+				sidecars[i].ImagePullPolicy = ImagePullPolicy
+			}
+		}
+		podTemplate.Spec.InitContainers = append(sidecars, podTemplate.Spec.InitContainers...)
+	}
+}
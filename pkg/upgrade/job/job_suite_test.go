@@ -1,7 +1,9 @@
 package job_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -48,7 +50,7 @@ var _ = Describe("Jobs", func() {
 		Context("When the Plan has a positive non-zero value for deadline", func() {
 			It("Constructs the batchv1.Job with the Plan's given value", func() {
 				plan.Spec.JobActiveDeadlineSecs = pointer.Int64(12345)
-				job := sucjob.New(plan, node, "foo")
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
 				Expect(job.Spec.ActiveDeadlineSeconds).To(PointTo(Equal(int64(12345))))
 			})
 		})
@@ -60,7 +62,7 @@ var _ = Describe("Jobs", func() {
 				defer func() { sucjob.ActiveDeadlineSeconds = oldActiveDeadlineSeconds }()
 
 				plan.Spec.JobActiveDeadlineSecs = nil
-				job := sucjob.New(plan, node, "bar")
+				job := sucjob.New(plan, node, "system-upgrade", "bar")
 				Expect(job.Spec.ActiveDeadlineSeconds).To(PointTo(Equal(int64(300))))
 			})
 		})
@@ -72,7 +74,7 @@ var _ = Describe("Jobs", func() {
 				defer func() { sucjob.ActiveDeadlineSeconds = oldActiveDeadlineSeconds }()
 
 				plan.Spec.JobActiveDeadlineSecs = pointer.Int64(0)
-				job := sucjob.New(plan, node, "bar")
+				job := sucjob.New(plan, node, "system-upgrade", "bar")
 				Expect(job.Spec.ActiveDeadlineSeconds).To(BeNil())
 			})
 		})
@@ -84,7 +86,7 @@ var _ = Describe("Jobs", func() {
 				defer func() { sucjob.ActiveDeadlineSeconds = oldActiveDeadlineSeconds }()
 
 				plan.Spec.JobActiveDeadlineSecs = pointer.Int64(-1)
-				job := sucjob.New(plan, node, "baz")
+				job := sucjob.New(plan, node, "system-upgrade", "baz")
 				Expect(job.Spec.ActiveDeadlineSeconds).To(PointTo(Equal(int64(3600))))
 			})
 		})
@@ -96,7 +98,7 @@ var _ = Describe("Jobs", func() {
 				defer func() { sucjob.ActiveDeadlineSecondsMax = oldActiveDeadlineSecondsMax }()
 
 				plan.Spec.JobActiveDeadlineSecs = pointer.Int64(600)
-				job := sucjob.New(plan, node, "foobar")
+				job := sucjob.New(plan, node, "system-upgrade", "foobar")
 				Expect(job.Spec.ActiveDeadlineSeconds).To(PointTo(Equal(int64(300))))
 			})
 		})
@@ -112,7 +114,7 @@ var _ = Describe("Jobs", func() {
 				plan.Labels["plan.cattle.io/some-label"] = "buz"
 				plan.Labels["some.other/label"] = "bla"
 
-				job := sucjob.New(plan, node, "foobar")
+				job := sucjob.New(plan, node, "system-upgrade", "foobar")
 				Expect(job.Annotations).To(Not(HaveKey("cattle.io/some-annotation")))
 				Expect(job.Annotations).To(Not(HaveKey("plan.cattle.io/some-annotation")))
 				Expect(job.Annotations).To(HaveKeyWithValue("some.other/annotation", "baz"))
@@ -129,4 +131,314 @@ var _ = Describe("Jobs", func() {
 			})
 		})
 	})
+
+	Describe("Applying the Plan's pod template overrides", func() {
+		Context("When the Plan disables host namespaces and the host root mount", func() {
+			It("Constructs the batchv1.Job without them", func() {
+				plan.Spec.PodTemplate = &upgradev1.PodTemplateSpec{
+					HostIPC:     pointer.Bool(false),
+					HostPID:     pointer.Bool(false),
+					HostNetwork: pointer.Bool(false),
+					HostRoot:    pointer.Bool(false),
+				}
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				podSpec := job.Spec.Template.Spec
+				Expect(podSpec.HostIPC).To(BeFalse())
+				Expect(podSpec.HostPID).To(BeFalse())
+				Expect(podSpec.HostNetwork).To(BeFalse())
+				Expect(podSpec.DNSPolicy).To(Equal(corev1.DNSClusterFirst))
+				Expect(podSpec.Volumes).To(Not(ContainElement(HaveField("Name", "host-root"))))
+				for _, container := range podSpec.Containers {
+					Expect(container.VolumeMounts).To(Not(ContainElement(HaveField("Name", "host-root"))))
+				}
+			})
+		})
+
+		Context("When the Plan has pod labels, node affinity and sidecars", func() {
+			It("Merges them into the batchv1.Job Pod template", func() {
+				plan.Spec.PodTemplate = &upgradev1.PodTemplateSpec{
+					Labels: map[string]string{
+						"some.other/label":            "bla",
+						"upgrade.cattle.io/plan":      "not-my-plan",
+						"upgrade.cattle.io/exclusive": "maybe",
+					},
+					RuntimeClassName: pointer.String("kata"),
+					NodeAffinity: []corev1.NodeSelectorRequirement{{
+						Key:      "kubernetes.io/arch",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{"amd64"},
+					}},
+					Sidecars: []corev1.Container{{
+						Name:  "inventory",
+						Image: "inventory:latest",
+					}},
+				}
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Labels).To(Not(HaveKey("some.other/label")))
+				Expect(job.Spec.Template.Labels).To(HaveKeyWithValue("some.other/label", "bla"))
+				Expect(job.Spec.Template.Labels).To(HaveKeyWithValue("upgrade.cattle.io/plan", plan.Name))
+				Expect(job.Spec.Template.Labels).To(HaveKeyWithValue("upgrade.cattle.io/exclusive", "false"))
+				Expect(job.Spec.Template.Spec.RuntimeClassName).To(PointTo(Equal("kata")))
+
+				terms := job.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+				Expect(terms).To(HaveLen(1))
+				Expect(terms[0].MatchExpressions).To(HaveLen(2))
+				Expect(terms[0].MatchExpressions[1].Key).To(Equal("kubernetes.io/arch"))
+
+				initContainers := job.Spec.Template.Spec.InitContainers
+				Expect(initContainers).To(HaveLen(1))
+				Expect(initContainers[0].Name).To(Equal("inventory"))
+				Expect(initContainers[0].RestartPolicy).To(PointTo(Equal(corev1.ContainerRestartPolicyAlways)))
+				Expect(plan.Spec.PodTemplate.Sidecars[0].RestartPolicy).To(BeNil())
+			})
+		})
+	})
+
+	Describe("Excluding other Plans", func() {
+		Context("When the Plan is not exclusive", func() {
+			It("Labels the Job as not exclusive, with pod anti-affinity only for the Plan", func() {
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Labels).To(HaveKeyWithValue("upgrade.cattle.io/exclusive", "false"))
+				terms := job.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+				Expect(terms).To(HaveLen(1))
+				Expect(terms[0].LabelSelector.MatchExpressions[0].Key).To(Equal("upgrade.cattle.io/plan"))
+			})
+		})
+
+		Context("When the Plan is exclusive without a group", func() {
+			It("Keys the label and pod anti-affinity on the default group", func() {
+				plan.Spec.Exclusive = true
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Labels).To(HaveKeyWithValue("upgrade.cattle.io/exclusive", sucjob.DefaultExclusiveGroup))
+				terms := job.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+				Expect(terms).To(HaveLen(1))
+				Expect(terms[0].LabelSelector.MatchExpressions[0].Values).To(Equal([]string{sucjob.DefaultExclusiveGroup}))
+			})
+		})
+
+		Context("When the Plan has an exclusive group", func() {
+			It("Keys the label and pod anti-affinity on the group", func() {
+				plan.Spec.ExclusiveGroup = "os"
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Labels).To(HaveKeyWithValue("upgrade.cattle.io/exclusive", "os"))
+				Expect(job.Spec.Template.Labels).To(HaveKeyWithValue("upgrade.cattle.io/exclusive", "os"))
+				terms := job.Spec.Template.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+				Expect(terms).To(HaveLen(1))
+				Expect(terms[0].LabelSelector.MatchExpressions[0].Key).To(Equal("upgrade.cattle.io/exclusive"))
+				Expect(terms[0].LabelSelector.MatchExpressions[0].Values).To(Equal([]string{"os"}))
+				Expect(terms[0].TopologyKey).To(Equal(corev1.LabelHostname))
+			})
+		})
+	})
+
+	Describe("Rebooting the Node", func() {
+		Context("When the Plan does not enable reboot", func() {
+			It("Constructs the batchv1.Job with the upgrade container", func() {
+				plan.Spec.Reboot = &upgradev1.RebootSpec{Policy: upgradev1.RebootNever}
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Spec.Template.Spec.Containers).To(HaveLen(1))
+				Expect(job.Spec.Template.Spec.Containers[0].Name).To(Equal("upgrade"))
+			})
+		})
+
+		Context("When the Plan reboots if required", func() {
+			It("Constructs the batchv1.Job with the upgrade container followed by the reboot container", func() {
+				plan.Spec.Reboot = &upgradev1.RebootSpec{
+					Policy:  upgradev1.RebootIfRequired,
+					Timeout: &metav1.Duration{Duration: time.Hour},
+				}
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				podSpec := job.Spec.Template.Spec
+				Expect(podSpec.InitContainers).To(HaveLen(1))
+				Expect(podSpec.InitContainers[0].Name).To(Equal("upgrade"))
+				Expect(podSpec.Containers).To(HaveLen(1))
+				Expect(podSpec.Containers[0].Name).To(Equal(sucjob.RebootContainerName))
+				Expect(podSpec.Containers[0].Image).To(Equal(sucjob.RebootImage))
+				Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "REBOOT_POLICY", Value: "IfRequired"}))
+				Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "REBOOT_SENTINEL", Value: "/var/run/reboot-required"}))
+				Expect(*job.Spec.TTLSecondsAfterFinished).To(BeNumerically(">=", int32(time.Hour.Seconds())))
+			})
+		})
+
+		Context("When the Plan only reboots", func() {
+			It("Constructs the batchv1.Job with only the reboot container, checking the sentinel", func() {
+				plan.Spec.Upgrade = nil
+				plan.Spec.Drain = &upgradev1.DrainSpec{Force: true}
+				plan.Spec.Reboot = &upgradev1.RebootSpec{
+					Policy:   upgradev1.RebootAlways,
+					Sentinel: "/run/reboot-needed",
+					Only:     true,
+				}
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				podSpec := job.Spec.Template.Spec
+				Expect(podSpec.InitContainers).To(HaveLen(1))
+				Expect(podSpec.InitContainers[0].Name).To(Equal("drain"))
+				Expect(podSpec.Containers).To(HaveLen(1))
+				Expect(podSpec.Containers[0].Name).To(Equal(sucjob.RebootContainerName))
+				Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "REBOOT_POLICY", Value: "IfRequired"}))
+				Expect(podSpec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "REBOOT_SENTINEL", Value: "/run/reboot-needed"}))
+			})
+		})
+
+		Context("When the Node runs Windows", func() {
+			It("Constructs the batchv1.Job without the reboot container", func() {
+				plan.Spec.Reboot = &upgradev1.RebootSpec{Policy: upgradev1.RebootAlways}
+				node = node.DeepCopy()
+				if node.Labels == nil {
+					node.Labels = map[string]string{}
+				}
+				node.Labels["kubernetes.io/os"] = "windows"
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				podSpec := job.Spec.Template.Spec
+				Expect(podSpec.InitContainers).To(BeEmpty())
+				Expect(podSpec.Containers).To(HaveLen(1))
+				Expect(podSpec.Containers[0].Name).To(Equal("upgrade"))
+			})
+		})
+
+		Context("When the reboot container has scheduled a reboot", func() {
+			It("Returns the boot ID from the termination message", func() {
+				pod := &corev1.Pod{Status: corev1.PodStatus{
+					ContainerStatuses: []corev1.ContainerStatus{{
+						Name: sucjob.RebootContainerName,
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+							Message: "Scheduled 5b1a8d6c-5f0f-4b8e-a1b4-3a1d5f1f0e6e",
+						}},
+					}},
+				}}
+				bootID, ok := sucjob.ScheduledReboot(pod)
+				Expect(ok).To(BeTrue())
+				Expect(bootID).To(Equal("5b1a8d6c-5f0f-4b8e-a1b4-3a1d5f1f0e6e"))
+
+				pod.Status.ContainerStatuses[0].State.Terminated.Message = "NotRequired"
+				_, ok = sucjob.ScheduledReboot(pod)
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Running the Plan's steps", func() {
+		Context("When the Plan has steps", func() {
+			It("Constructs the batchv1.Job with ordered step init containers after cordon", func() {
+				plan.Spec.Cordon = true
+				plan.Spec.Secrets = []upgradev1.SecretSpec{{Name: "shared", Path: "/run/shared"}}
+				plan.Spec.Steps = []upgradev1.StepSpec{{
+					Name:          "backup",
+					ContainerSpec: upgradev1.ContainerSpec{Image: "backup"},
+					Secrets:       []upgradev1.SecretSpec{{Name: "creds", Path: "/run/creds"}},
+				}, {
+					Name: "migrate",
+					ContainerSpec: upgradev1.ContainerSpec{
+						Image:   "migrate:v1",
+						Volumes: []upgradev1.VolumeSpec{{Name: "data", Source: "/var/lib/data", Destination: "/data"}},
+					},
+					Secrets: []upgradev1.SecretSpec{{Name: "creds", Path: "/run/creds"}},
+				}}
+				plan.Status.LatestVersion = "v2"
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				podSpec := job.Spec.Template.Spec
+
+				Expect(podSpec.InitContainers).To(HaveLen(3))
+				Expect(podSpec.InitContainers[0].Name).To(Equal("cordon"))
+				Expect(podSpec.InitContainers[1].Name).To(Equal(sucjob.StepContainerName("backup")))
+				Expect(podSpec.InitContainers[1].Image).To(Equal("backup:v2"))
+				Expect(podSpec.InitContainers[1].VolumeMounts).To(ContainElement(HaveField("Name", "secret-shared")))
+				Expect(podSpec.InitContainers[1].VolumeMounts).To(ContainElement(HaveField("Name", "secret-creds")))
+				Expect(podSpec.InitContainers[2].Name).To(Equal(sucjob.StepContainerName("migrate")))
+				Expect(podSpec.InitContainers[2].Image).To(Equal("migrate:v1"))
+				Expect(podSpec.InitContainers[2].VolumeMounts).To(ContainElement(HaveField("Name", "data")))
+				Expect(podSpec.Containers[0].VolumeMounts).To(Not(ContainElement(HaveField("Name", "secret-creds"))))
+
+				var secretVolumes int
+				for _, volume := range podSpec.Volumes {
+					if volume.Name == "secret-creds" {
+						secretVolumes++
+					}
+				}
+				Expect(secretVolumes).To(Equal(1))
+				Expect(podSpec.Volumes).To(ContainElement(HaveField("Name", "data")))
+			})
+		})
+	})
+
+	Describe("Reporting failures", func() {
+		Context("When an init container of the Job Pod has failed", func() {
+			It("Returns the failed init container in preference to later containers", func() {
+				pod := &corev1.Pod{Status: corev1.PodStatus{
+					InitContainerStatuses: []corev1.ContainerStatus{{
+						Name:  "cordon",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+					}, {
+						Name:                 "drain",
+						State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}},
+						LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "cannot evict pod"}},
+					}},
+					ContainerStatuses: []corev1.ContainerStatus{{
+						Name:  "upgrade",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2}},
+					}},
+				}}
+				name, terminated, previous := sucjob.FailedContainer(pod)
+				Expect(name).To(Equal("drain"))
+				Expect(terminated.Message).To(Equal("cannot evict pod"))
+				Expect(previous).To(BeTrue())
+
+				pod.Status.InitContainerStatuses = nil
+				name, _, previous = sucjob.FailedContainer(pod)
+				Expect(name).To(Equal("upgrade"))
+				Expect(previous).To(BeFalse())
+
+				pod.Status.ContainerStatuses = nil
+				name, terminated, _ = sucjob.FailedContainer(pod)
+				Expect(name).To(BeEmpty())
+				Expect(terminated).To(BeNil())
+			})
+		})
+
+		Context("When messages and logs are longer than the limit", func() {
+			It("Keeps the start of messages and the last lines of logs", func() {
+				Expect(sucjob.Truncate("short", 10)).To(Equal("short"))
+				Expect(sucjob.Truncate("a longer message", 8)).To(Equal("a longer..."))
+				Expect(sucjob.TruncateTail("line 1\nline 2\nline 3\n", 100)).To(Equal("line 1\nline 2\nline 3\n"))
+				Expect(sucjob.TruncateTail("line 1\nline 2\nline 3\n", 10)).To(Equal("...line 3\n"))
+			})
+		})
+	})
+
+	Describe("Labeling Nodes", func() {
+		Context("When the Plan is in the controller namespace", func() {
+			It("Labels by the Plan name alone", func() {
+				plan.Namespace = "system-upgrade"
+				Expect(sucjob.LabelPlanName(plan, "system-upgrade")).To(Equal("plan.upgrade.cattle.io/test-1"))
+				Expect(sucjob.LabelRebootName(plan, "system-upgrade")).To(Equal("reboot.upgrade.cattle.io/test-1"))
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Labels).To(HaveKeyWithValue("plan.upgrade.cattle.io/test-1", plan.Status.LatestHash))
+			})
+		})
+
+		Context("When the Plan is in another namespace", func() {
+			It("Labels by the Plan namespace and name", func() {
+				Expect(sucjob.LabelPlanName(plan, "system-upgrade")).To(Equal("plan.upgrade.cattle.io/default_test-1"))
+				Expect(sucjob.LabelRebootName(plan, "system-upgrade")).To(Equal("reboot.upgrade.cattle.io/default_test-1"))
+				Expect(sucjob.AnnotationRebootedName(plan, "system-upgrade")).To(Equal("rebooted.upgrade.cattle.io/default_test-1"))
+				job := sucjob.New(plan, node, "system-upgrade", "foo")
+				Expect(job.Labels).To(HaveKey("plan.upgrade.cattle.io/default_test-1"))
+				Expect(job.Labels).ToNot(HaveKey("plan.upgrade.cattle.io/test-1"))
+			})
+
+			It("Does not share labels with a Plan of the same name in the controller namespace", func() {
+				other := plan.DeepCopy()
+				other.Namespace = "system-upgrade"
+				Expect(sucjob.LabelPlanName(plan, "system-upgrade")).ToNot(Equal(sucjob.LabelPlanName(other, "system-upgrade")))
+			})
+
+			It("Limits the label name to 63 characters", func() {
+				plan.Namespace = strings.Repeat("n", 40)
+				plan.Name = strings.Repeat("p", 40)
+				labelName := sucjob.LabelName(plan.Namespace, plan.Name, "system-upgrade")
+				Expect(len(labelName)).To(BeNumerically("<=", 63))
+				Expect(labelName).ToNot(Equal(sucjob.LabelName(plan.Namespace, strings.Repeat("p", 41), "system-upgrade")))
+			})
+		})
+	})
 })
@@ -0,0 +1,89 @@
+package upgrade
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		output    *bytes.Buffer
+		formatter logrus.Formatter
+	)
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+		formatter = logrus.StandardLogger().Formatter
+		logrus.SetOutput(output)
+		DeferCleanup(func() {
+			logrus.SetOutput(GinkgoWriter)
+			logrus.SetFormatter(formatter)
+		})
+	})
+
+	// line decodes the single line logged as JSON.
+	line := func() map[string]any {
+		fields := map[string]any{}
+		Expect(json.Unmarshal(output.Bytes(), &fields)).To(Succeed())
+		return fields
+	}
+
+	It("should reject unsupported log formats", func() {
+		_, err := NewLogFormatter("xml")
+		Expect(err).To(MatchError(ErrUnsupportedLogFormat))
+	})
+
+	It("should log plan fields as JSON", func() {
+		jsonFormatter, err := NewLogFormatter("json")
+		Expect(err).ToNot(HaveOccurred())
+		logrus.SetFormatter(jsonFormatter)
+
+		source := planSource{plan: &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "tenant", Name: "k3s-server", ResourceVersion: "42"},
+			Status:     upgradeapiv1.PlanStatus{LatestVersion: "v1.32.1", LatestHash: "abc123"},
+		}}
+		source.logger("plan-jobs").WithField("node", "node-1").Info("Syncing Plan Jobs")
+
+		Expect(line()).To(And(
+			HaveKeyWithValue("level", "info"),
+			HaveKeyWithValue("msg", "Syncing Plan Jobs"),
+			HaveKeyWithValue("handler", "plan-jobs"),
+			HaveKeyWithValue("kind", "Plan"),
+			HaveKeyWithValue("namespace", "tenant"),
+			HaveKeyWithValue("plan", "k3s-server"),
+			HaveKeyWithValue("resourceVersion", "42"),
+			HaveKeyWithValue("version", "v1.32.1"),
+			HaveKeyWithValue("hash", "abc123"),
+			HaveKeyWithValue("node", "node-1"),
+		))
+	})
+
+	It("should log pod fields as JSON", func() {
+		jsonFormatter, err := NewLogFormatter("json")
+		Expect(err).ToNot(HaveOccurred())
+		logrus.SetFormatter(jsonFormatter)
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "tenant", Name: "apply-k3s-server-abc", Labels: map[string]string{upgradeapi.LabelPlan: "k3s-server"}},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+		}
+		podLogger(pod).Info("Node requires reboot")
+
+		Expect(line()).To(And(
+			HaveKeyWithValue("msg", "Node requires reboot"),
+			HaveKeyWithValue("handler", "pods"),
+			HaveKeyWithValue("namespace", "tenant"),
+			HaveKeyWithValue("pod", "apply-k3s-server-abc"),
+			HaveKeyWithValue("plan", "k3s-server"),
+			HaveKeyWithValue("node", "node-1"),
+		))
+	})
+})
@@ -0,0 +1,152 @@
+package upgrade
+
+import (
+	"time"
+
+	appsctl "github.com/rancher/wrangler/v3/pkg/generated/controllers/apps"
+	appsctlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/apps/v1"
+	batchctl "github.com/rancher/wrangler/v3/pkg/generated/controllers/batch"
+	batchctlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/batch/v1"
+	corectl "github.com/rancher/wrangler/v3/pkg/generated/controllers/core"
+	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+// namespaceFactory holds the factories for the namespaced resources that the controller caches in a single namespace,
+// so that Secrets, Pods, DaemonSets and Jobs in namespaces that Plans are not watched in are not cached.
+type namespaceFactory struct {
+	core  *corectl.Factory
+	apps  *appsctl.Factory
+	batch *batchctl.Factory
+}
+
+func newNamespaceFactory(cfg *rest.Config, namespace string, resync time.Duration) (*namespaceFactory, error) {
+	core, err := corectl.NewFactoryFromConfigWithOptions(cfg, &corectl.FactoryOptions{
+		Namespace: namespace,
+		Resync:    resync,
+	})
+	if err != nil {
+		return nil, err
+	}
+	apps, err := appsctl.NewFactoryFromConfigWithOptions(cfg, &appsctl.FactoryOptions{
+		Namespace: namespace,
+		Resync:    resync,
+	})
+	if err != nil {
+		return nil, err
+	}
+	batch, err := batchctl.NewFactoryFromConfigWithOptions(cfg, &batchctl.FactoryOptions{
+		Namespace: namespace,
+		Resync:    resync,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceFactory{core: core, apps: apps, batch: batch}, nil
+}
+
+// cacheNamespaces returns the namespaces that namespaced resources are cached in: the namespaces that Plans are watched in,
+// and the controller namespace, that the Jobs of ClusterPlans are created in. If Plans in all namespaces are watched,
+// resources are cached in all namespaces.
+func (ctl *Controller) cacheNamespaces() []string {
+	if ctl.planNamespaces[0] == metav1.NamespaceAll {
+		return []string{metav1.NamespaceAll}
+	}
+	namespaces := []string{ctl.Namespace}
+	for _, namespace := range ctl.planNamespaces {
+		if namespace != ctl.Namespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// factoryFor returns the factory that caches resources in the given namespace, which must be one of the cache namespaces.
+func (ctl *Controller) factoryFor(namespace string) *namespaceFactory {
+	if factory, ok := ctl.namespaceFactories[metav1.NamespaceAll]; ok {
+		return factory
+	}
+	return ctl.namespaceFactories[namespace]
+}
+
+func (ctl *Controller) jobs(namespace string) batchctlv1.JobController {
+	return ctl.factoryFor(namespace).batch.Batch().V1().Job()
+}
+
+func (ctl *Controller) pods(namespace string) corectlv1.PodController {
+	return ctl.factoryFor(namespace).core.Core().V1().Pod()
+}
+
+func (ctl *Controller) daemonSets(namespace string) appsctlv1.DaemonSetController {
+	return ctl.factoryFor(namespace).apps.Apps().V1().DaemonSet()
+}
+
+// secretCache returns a SecretCache that reads Secrets from the cache of their namespace.
+func (ctl *Controller) secretCache() corectlv1.SecretCache {
+	caches := namespacedSecretCache{}
+	for namespace, factory := range ctl.namespaceFactories {
+		caches[namespace] = factory.core.Core().V1().Secret().Cache()
+	}
+	return caches
+}
+
+// namespacedSecretCache is a SecretCache over the Secret caches of several namespaces, keyed by namespace; a cache keyed by
+// metav1.NamespaceAll holds the Secrets in all namespaces. Secrets in namespaces without a cache are not found.
+type namespacedSecretCache map[string]corectlv1.SecretCache
+
+func (c namespacedSecretCache) cache(namespace string) corectlv1.SecretCache {
+	if cache, ok := c[metav1.NamespaceAll]; ok {
+		return cache
+	}
+	return c[namespace]
+}
+
+func (c namespacedSecretCache) Get(namespace, name string) (*corev1.Secret, error) {
+	cache := c.cache(namespace)
+	if cache == nil {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	return cache.Get(namespace, name)
+}
+
+func (c namespacedSecretCache) List(namespace string, selector labels.Selector) ([]*corev1.Secret, error) {
+	if namespace != metav1.NamespaceAll {
+		cache := c.cache(namespace)
+		if cache == nil {
+			return nil, nil
+		}
+		return cache.List(namespace, selector)
+	}
+	var secrets []*corev1.Secret
+	for _, cache := range c {
+		list, err := cache.List(namespace, selector)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, list...)
+	}
+	return secrets, nil
+}
+
+func (c namespacedSecretCache) AddIndexer(indexName string, indexer generic.Indexer[*corev1.Secret]) {
+	for _, cache := range c {
+		cache.AddIndexer(indexName, indexer)
+	}
+}
+
+func (c namespacedSecretCache) GetByIndex(indexName, key string) ([]*corev1.Secret, error) {
+	var secrets []*corev1.Secret
+	for _, cache := range c {
+		list, err := cache.GetByIndex(indexName, key)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, list...)
+	}
+	return secrets, nil
+}
@@ -1,6 +1,15 @@
 package node
 
-import corev1 "k8s.io/api/core/v1"
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
 
 func Hostname(node *corev1.Node) string {
 	if node.Labels != nil {
@@ -10,3 +19,60 @@ func Hostname(node *corev1.Node) string {
 	}
 	return node.Name
 }
+
+// IsReady returns true if the Node's Ready condition is true.
+func IsReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Loads returns the number of Pods on each Node, by name, that have not finished and are not owned by a DaemonSet.
+func Loads(pods []corev1.Pod) map[string]int {
+	loads := map[string]int{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+			continue
+		}
+		loads[pod.Spec.NodeName]++
+	}
+	return loads
+}
+
+// NewLoadInformer returns an informer for the Pods in all namespaces that may count towards the load of a Node: those
+// that are scheduled and have not finished. Pods are stripped down to the fields read by Loads, to limit the memory
+// used by the cache.
+func NewLoadInformer(client kubernetes.Interface, resync time.Duration) (cache.SharedIndexInformer, error) {
+	selector := fields.AndSelectors(
+		fields.OneTermNotEqualSelector("spec.nodeName", ""),
+		fields.OneTermNotEqualSelector("status.phase", string(corev1.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(corev1.PodFailed)),
+	).String()
+	informer := coreinformers.NewFilteredPodInformer(client, metav1.NamespaceAll, resync, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.FieldSelector = selector
+	})
+	err := informer.SetTransform(func(obj any) (any, error) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return obj, nil
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       pod.Namespace,
+				Name:            pod.Name,
+				ResourceVersion: pod.ResourceVersion,
+				OwnerReferences: pod.OwnerReferences,
+			},
+			Spec:   corev1.PodSpec{NodeName: pod.Spec.NodeName},
+			Status: corev1.PodStatus{Phase: pod.Status.Phase},
+		}, nil
+	})
+	return informer, err
+}
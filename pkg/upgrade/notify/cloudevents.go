@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	"github.com/sirupsen/logrus"
+)
+
+// Types of the CloudEvents sent for the upgrade lifecycle. These are stable, and may be relied on by consumers.
+const (
+	CloudEventPlanResolved  = "io.cattle.upgrade.plan.resolved"
+	CloudEventNodeStarted   = "io.cattle.upgrade.node.started"
+	CloudEventNodeCompleted = "io.cattle.upgrade.node.completed"
+	CloudEventNodeFailed    = "io.cattle.upgrade.node.failed"
+	CloudEventPlanCompleted = "io.cattle.upgrade.plan.completed"
+
+	cloudEventsSpecVersion = "1.0"
+	contentTypeCloudEvents = "application/cloudevents+json"
+)
+
+// CloudEvent is a CloudEvent in the structured JSON format.
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            CloudEventData `json:"data"`
+}
+
+// CloudEventData is the data of a CloudEvent sent for a Plan or ClusterPlan, and the node it was applied to, if any.
+type CloudEventData struct {
+	Kind          string `json:"kind"`
+	Namespace     string `json:"namespace,omitempty"`
+	Name          string `json:"name"`
+	Node          string `json:"node,omitempty"`
+	LatestVersion string `json:"latestVersion,omitempty"`
+	LatestHash    string `json:"latestHash,omitempty"`
+	Message       string `json:"message,omitempty"`
+	Controller    string `json:"controller"`
+}
+
+// WithCloudEvents sends CloudEvents to the given sink, in addition to the notifications sent to endpoints.
+func (n *Notifier) WithCloudEvents(sink Endpoint) *Notifier {
+	n.cloudEventsSink = &sink
+	return n
+}
+
+// CloudEvent queues a CloudEvent of the given type to be sent to the CloudEvents sink, if one is configured.
+// See NewCloudEvent for the time at which the event occurred.
+func (n *Notifier) CloudEvent(eventType string, data CloudEventData, occurred time.Time) {
+	if n.cloudEventsSink == nil {
+		return
+	}
+	data.Controller = n.controllerName
+	event := NewCloudEvent(eventType, data, occurred)
+	body, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("Failed to marshal CloudEvent %s for %s: %v", event.Type, event.Subject, err)
+		return
+	}
+	n.enqueue(delivery{
+		body:        body,
+		contentType: contentTypeCloudEvents,
+		description: fmt.Sprintf("CloudEvent %s for %s", event.Type, event.Subject),
+		endpoint:    *n.cloudEventsSink,
+	})
+}
+
+// NewCloudEvent returns a CloudEvent with the given type and data. The source is the API path of the plan, and the
+// subject is the plan name, followed by the node name if any. The ID is derived from the type, source, subject, latest
+// version, latest hash and the time at which the event occurred, so that the same transition sent again, such as when
+// a finished Job is synced again, has the same ID, while each occurrence of a transition that may recur for the same
+// hash, such as a node failing again after a retry, has a unique ID. The time is that recorded for the transition, such
+// as the time at which the Job failed, or zero for transitions that occur once per hash; the time of the event is then
+// the time at which it is sent.
+func NewCloudEvent(eventType string, data CloudEventData, occurred time.Time) CloudEvent {
+	source := fmt.Sprintf("/apis/%s/v1/clusterplans/%s", upgradeapi.GroupName, data.Name)
+	if data.Kind != "ClusterPlan" {
+		source = fmt.Sprintf("/apis/%s/v1/namespaces/%s/plans/%s", upgradeapi.GroupName, data.Namespace, data.Name)
+	}
+	subject := data.Name
+	if data.Node != "" {
+		subject += "/" + data.Node
+	}
+	id := sha256.Sum256([]byte(eventType + "\x00" + source + "\x00" + subject + "\x00" + data.LatestVersion + "\x00" + data.LatestHash + "\x00" + occurred.UTC().Format(time.RFC3339Nano)))
+	t := occurred
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              fmt.Sprintf("%x", id[:16]),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            t.UTC(),
+		DataContentType: contentTypeJSON,
+		Data:            data,
+	}
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+)
+
+var _ = Describe("CloudEvents", func() {
+	data := CloudEventData{
+		Kind:          "Plan",
+		Namespace:     "system-upgrade",
+		Name:          "test-plan",
+		Node:          "node-1",
+		LatestVersion: "v1.0.0",
+		LatestHash:    "test-hash",
+	}
+
+	It("should set the source and subject from the plan and node", func() {
+		event := NewCloudEvent(CloudEventNodeCompleted, data, time.Now())
+		Expect(event.SpecVersion).To(Equal("1.0"))
+		Expect(event.Type).To(Equal("io.cattle.upgrade.node.completed"))
+		Expect(event.Source).To(Equal("/apis/upgrade.cattle.io/v1/namespaces/system-upgrade/plans/test-plan"))
+		Expect(event.Subject).To(Equal("test-plan/node-1"))
+		Expect(event.DataContentType).To(Equal("application/json"))
+	})
+
+	It("should set the source and subject of ClusterPlan events without a node", func() {
+		clusterData := data
+		clusterData.Kind, clusterData.Namespace, clusterData.Node = "ClusterPlan", "", ""
+		event := NewCloudEvent(CloudEventPlanCompleted, clusterData, time.Now())
+		Expect(event.Source).To(Equal("/apis/upgrade.cattle.io/v1/clusterplans/test-plan"))
+		Expect(event.Subject).To(Equal("test-plan"))
+	})
+
+	It("should derive a unique ID for each occurrence of a transition", func() {
+		failedAt := time.Date(2026, 1, 3, 4, 0, 0, 0, time.UTC)
+		event := NewCloudEvent(CloudEventNodeFailed, data, failedAt)
+		Expect(event.Time).To(Equal(failedAt))
+		// the same failure sent again keeps its ID, while failing again after a retry does not
+		Expect(NewCloudEvent(CloudEventNodeFailed, data, failedAt).ID).To(Equal(event.ID))
+		Expect(NewCloudEvent(CloudEventNodeFailed, data, failedAt.Add(time.Minute)).ID).ToNot(Equal(event.ID))
+		Expect(NewCloudEvent(CloudEventNodeCompleted, data, failedAt).ID).ToNot(Equal(event.ID))
+		newHash := data
+		newHash.LatestHash = "new-hash"
+		Expect(NewCloudEvent(CloudEventNodeFailed, newHash, failedAt).ID).ToNot(Equal(event.ID))
+	})
+
+	It("should derive the ID of transitions that occur once per hash without the time they are sent", func() {
+		event := NewCloudEvent(CloudEventNodeStarted, data, time.Time{})
+		Expect(event.Time).ToNot(BeZero())
+		time.Sleep(time.Millisecond)
+		Expect(NewCloudEvent(CloudEventNodeStarted, data, time.Time{}).ID).To(Equal(event.ID))
+	})
+
+	It("should POST structured CloudEvents to the sink", func() {
+		received := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- r
+			bodies <- body
+		}))
+		DeferCleanup(server.Close)
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		notifier := New("system-upgrade", "system-upgrade-controller", nil)
+		notifier.CloudEvent(CloudEventNodeFailed, data, time.Now())
+		Expect(notifier.queues).To(BeEmpty())
+
+		notifier.WithCloudEvents(Endpoint{NotificationSpec: upgradeapiv1.NotificationSpec{URL: server.URL}})
+		go notifier.Run(ctx)
+		notifier.CloudEvent(CloudEventNodeFailed, data, time.Now())
+
+		var request *http.Request
+		Eventually(received).Should(Receive(&request))
+		Expect(request.Header.Get("Content-Type")).To(Equal("application/cloudevents+json"))
+		var event CloudEvent
+		Expect(json.Unmarshal(<-bodies, &event)).To(Succeed())
+		Expect(event.Type).To(Equal(CloudEventNodeFailed))
+		Expect(event.Subject).To(Equal("test-plan/node-1"))
+		Expect(event.Data.Node).To(Equal("node-1"))
+		Expect(event.Data.Controller).To(Equal("system-upgrade-controller"))
+	})
+})
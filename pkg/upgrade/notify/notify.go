@@ -0,0 +1,309 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// defaultQueueSize is the number of notifications that can be waiting to be sent to an endpoint before new
+	// notifications for it are dropped.
+	defaultQueueSize = 100
+	// defaultRetries is the number of times that sending a notification is retried.
+	defaultRetries = 4
+	// defaultBackoff is the delay before the first retry; the delay is doubled for each subsequent retry.
+	defaultBackoff = time.Second
+	// defaultTimeout is the timeout for each attempt to send a notification.
+	defaultTimeout = 10 * time.Second
+
+	contentTypeJSON = "application/json"
+)
+
+var (
+	ErrUnexpectedStatus = errors.New("unexpected response status")
+	ErrURLNotAllowed    = errors.New("url is not allowed for plans outside the controller namespace")
+
+	// DefaultEvents are the reasons of the events that are sent to endpoints that do not specify any.
+	DefaultEvents = []string{"Resolved", "SyncJob", "JobFailed", "JobComplete", "Complete", "Waiting"}
+)
+
+// Event is the JSON payload POSTed to endpoints.
+type Event struct {
+	// Reason, type and message of the Kubernetes event.
+	Reason  string `json:"reason"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Kind, namespace and name of the Plan or ClusterPlan that the event was emitted for.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Latest version and hash of the plan when the event was emitted.
+	LatestVersion string `json:"latestVersion,omitempty"`
+	LatestHash    string `json:"latestHash,omitempty"`
+	// Name of the controller that emitted the event.
+	Controller string    `json:"controller"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Endpoint is an HTTP endpoint that is sent events. Credentials are read from the named Secret, if any.
+type Endpoint struct {
+	upgradeapiv1.NotificationSpec
+	// Namespace of the Secret.
+	SecretNamespace string
+}
+
+// Wants returns true if the endpoint should be sent events with the given reason.
+func (e Endpoint) Wants(reason string) bool {
+	if len(e.Events) == 0 {
+		return slices.Contains(DefaultEvents, reason)
+	}
+	return slices.Contains(e.Events, reason)
+}
+
+// delivery is a JSON payload waiting to be sent to an endpoint. The description identifies the payload in logs.
+type delivery struct {
+	body        []byte
+	contentType string
+	description string
+	endpoint    Endpoint
+}
+
+// Notifier sends events to HTTP endpoints. Events are queued for each endpoint URL, and sent in the background with
+// retries by a worker for the URL, so that handlers, and other endpoints, are not blocked by slow or unavailable endpoints.
+type Notifier struct {
+	controllerName      string
+	controllerNamespace string
+	endpoints           []Endpoint
+	cloudEventsSink     *Endpoint
+	allowedURLs         []*url.URL
+	secretCache         corectlv1.SecretCache
+	client              *http.Client
+
+	mu      sync.Mutex
+	ctx     context.Context
+	queues  map[string]chan delivery
+	dropped atomic.Uint64
+
+	retries int
+	backoff time.Duration
+}
+
+// New returns a Notifier for the named controller, that sends events for all plans to the given endpoints in addition
+// to those configured for each plan. Secrets for ClusterPlans are resolved from the controller namespace.
+func New(controllerNamespace, controllerName string, secretCache corectlv1.SecretCache, endpoints ...Endpoint) *Notifier {
+	return &Notifier{
+		controllerName:      controllerName,
+		controllerNamespace: controllerNamespace,
+		endpoints:           endpoints,
+		secretCache:         secretCache,
+		client:              &http.Client{Timeout: defaultTimeout},
+		queues:              map[string]chan delivery{},
+		retries:             defaultRetries,
+		backoff:             defaultBackoff,
+	}
+}
+
+// AllowURLs allows endpoints of Plans outside the controller namespace to be sent events only if their URL has the same
+// scheme and host as one of the given URLs, and a path under its path. Endpoints of Plans outside the controller
+// namespace are not sent events if no URLs are allowed. Invalid URLs are ignored.
+func (n *Notifier) AllowURLs(urls ...string) *Notifier {
+	for _, rawURL := range urls {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Host == "" {
+			logrus.Warnf("Ignoring invalid allowed notification URL %q", rawURL)
+			continue
+		}
+		n.allowedURLs = append(n.allowedURLs, u)
+	}
+	return n
+}
+
+// Allowed returns nil if the endpoint of a plan may be sent events. Endpoints of ClusterPlans, and Plans in the
+// controller namespace, are always allowed; others must match an allowed URL.
+func (n *Notifier) Allowed(endpoint Endpoint) error {
+	if endpoint.SecretNamespace == n.controllerNamespace {
+		return nil
+	}
+	u, err := url.Parse(endpoint.URL)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range n.allowedURLs {
+		path := strings.TrimSuffix(allowed.Path, "/")
+		if u.Scheme == allowed.Scheme && strings.EqualFold(u.Host, allowed.Host) && u.User == nil &&
+			(u.Path == path || strings.HasPrefix(u.Path, path+"/")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrURLNotAllowed, endpoint.URL)
+}
+
+// Run sends queued events until the context is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	n.mu.Lock()
+	n.ctx = ctx
+	for _, queue := range n.queues {
+		go n.work(ctx, queue)
+	}
+	n.mu.Unlock()
+	<-ctx.Done()
+}
+
+// Dropped returns the number of events that have been dropped, because the queue for their endpoint was full,
+// the endpoint is not allowed, or they could not be sent.
+func (n *Notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// work sends events queued for an endpoint URL until the context is cancelled.
+func (n *Notifier) work(ctx context.Context, queue chan delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-queue:
+			if err := n.deliver(ctx, d); err != nil {
+				logrus.Errorf("Failed to send %s to %s (%d dropped): %v", d.description, d.endpoint.URL, n.dropped.Add(1), err)
+			}
+		}
+	}
+}
+
+// Notify queues the event to be sent to the controller endpoints, and the given plan endpoints, that want it.
+// If the queue for an endpoint is full, or the endpoint is not allowed, the event is dropped for that endpoint.
+func (n *Notifier) Notify(event Event, planEndpoints ...Endpoint) {
+	var body []byte
+	for i, endpoint := range append(slices.Clone(n.endpoints), planEndpoints...) {
+		if !endpoint.Wants(event.Reason) {
+			continue
+		}
+		if i >= len(n.endpoints) {
+			if err := n.Allowed(endpoint); err != nil {
+				logrus.Warnf("Dropped %s (%d dropped): %v", description(event), n.dropped.Add(1), err)
+				continue
+			}
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(event); err != nil {
+				logrus.Errorf("Failed to marshal %s: %v", description(event), err)
+				return
+			}
+		}
+		n.enqueue(delivery{body: body, contentType: contentTypeJSON, description: description(event), endpoint: endpoint})
+	}
+}
+
+// Send POSTs the event to the endpoint, retrying with exponential backoff if the request fails
+// or the endpoint responds with a 429 or 5xx status.
+func (n *Notifier) Send(ctx context.Context, event Event, endpoint Endpoint) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return n.deliver(ctx, delivery{body: body, contentType: contentTypeJSON, description: description(event), endpoint: endpoint})
+}
+
+// enqueue queues the payload for the worker of its endpoint URL, starting the worker if this is the first payload
+// for the URL. Workers are started once the Notifier is running.
+func (n *Notifier) enqueue(d delivery) {
+	n.mu.Lock()
+	queue, ok := n.queues[d.endpoint.URL]
+	if !ok {
+		queue = make(chan delivery, defaultQueueSize)
+		n.queues[d.endpoint.URL] = queue
+		if n.ctx != nil {
+			go n.work(n.ctx, queue)
+		}
+	}
+	n.mu.Unlock()
+	select {
+	case queue <- d:
+	default:
+		logrus.Warnf("Dropped %s to %s (%d dropped): queue is full", d.description, d.endpoint.URL, n.dropped.Add(1))
+	}
+}
+
+// deliver POSTs the payload to the endpoint, with retries.
+func (n *Notifier) deliver(ctx context.Context, d delivery) error {
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.send(ctx, d)
+		if err == nil || !retry || attempt >= n.retries {
+			return err
+		}
+		logrus.Debugf("Retrying %s to %s in %s: %v", d.description, d.endpoint.URL, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single attempt to POST the payload to the endpoint, returning whether the attempt should be retried if it failed.
+func (n *Notifier) send(ctx context.Context, d delivery) (bool, error) {
+	endpoint := d.endpoint
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", d.contentType)
+	if endpoint.SecretName != "" {
+		secret, err := n.secretCache.Get(endpoint.SecretNamespace, endpoint.SecretName)
+		if err != nil {
+			return true, err
+		}
+		if err := setAuth(request, secret); err != nil {
+			return false, err
+		}
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+		return retry, fmt.Errorf("%w: %s", ErrUnexpectedStatus, response.Status)
+	}
+	return false, nil
+}
+
+// setAuth sets the Authorization header of the request from the token, or username and password, in the Secret.
+func setAuth(request *http.Request, secret *corev1.Secret) error {
+	if token := secret.Data["token"]; len(token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+string(token))
+		return nil
+	}
+	username, password := secret.Data["username"], secret.Data["password"]
+	if len(username) > 0 && len(password) > 0 {
+		request.SetBasicAuth(string(username), string(password))
+		return nil
+	}
+	return fmt.Errorf("secret %s/%s does not have a token, or username and password", secret.Namespace, secret.Name)
+}
+
+// description returns a description of the event for logs.
+func description(event Event) string {
+	if event.Namespace == "" {
+		return fmt.Sprintf("%s notification for %s %s", event.Reason, event.Kind, event.Name)
+	}
+	return fmt.Sprintf("%s notification for %s %s/%s", event.Reason, event.Kind, event.Namespace, event.Name)
+}
@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// request is a request received by the test server.
+type request struct {
+	header http.Header
+	event  Event
+}
+
+var _ = Describe("Notifier", func() {
+	var (
+		server      *httptest.Server
+		mu          sync.Mutex
+		requests    []request
+		statuses    []int
+		notifier    *Notifier
+		secretCache *generic.Cache[*corev1.Secret]
+	)
+	received := func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]request{}, requests...)
+	}
+	BeforeEach(func() {
+		requests, statuses = nil, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			req := request{header: r.Header}
+			Expect(json.Unmarshal(body, &req.event)).To(Succeed())
+			mu.Lock()
+			defer mu.Unlock()
+			requests = append(requests, req)
+			if len(statuses) > 0 {
+				w.WriteHeader(statuses[0])
+				statuses = statuses[1:]
+			}
+		}))
+		DeferCleanup(server.Close)
+
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(indexer.Add(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "system-upgrade"},
+			Data:       map[string][]byte{"token": []byte("test-token")},
+		})).To(Succeed())
+		Expect(indexer.Add(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "basic", Namespace: "default"},
+			Data:       map[string][]byte{"username": []byte("user"), "password": []byte("pass")},
+		})).To(Succeed())
+		secretCache = generic.NewCache[*corev1.Secret](indexer, corev1.Resource("secrets"))
+		notifier = New("system-upgrade", "system-upgrade-controller", secretCache)
+		notifier.backoff = time.Millisecond
+	})
+
+	endpoint := func(secretNamespace, secretName string, events ...string) Endpoint {
+		return Endpoint{
+			NotificationSpec: upgradeapiv1.NotificationSpec{URL: server.URL, SecretName: secretName, Events: events},
+			SecretNamespace:  secretNamespace,
+		}
+	}
+
+	Context("Send", func() {
+		It("should POST the event with a bearer token", func() {
+			event := Event{Reason: "Complete", Type: corev1.EventTypeNormal, Kind: "Plan", Namespace: "system-upgrade", Name: "test-plan"}
+			Expect(notifier.Send(context.Background(), event, endpoint("system-upgrade", "token"))).To(Succeed())
+			Expect(received()).To(HaveLen(1))
+			Expect(received()[0].header.Get("Authorization")).To(Equal("Bearer test-token"))
+			Expect(received()[0].header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(received()[0].event).To(Equal(event))
+		})
+
+		It("should POST the event with basic authentication", func() {
+			Expect(notifier.Send(context.Background(), Event{Reason: "Complete"}, endpoint("default", "basic"))).To(Succeed())
+			Expect(received()).To(HaveLen(1))
+			Expect(received()[0].header.Get("Authorization")).To(HavePrefix("Basic "))
+		})
+
+		It("should retry server errors", func() {
+			statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+			Expect(notifier.Send(context.Background(), Event{Reason: "Complete"}, endpoint("", ""))).To(Succeed())
+			Expect(received()).To(HaveLen(3))
+		})
+
+		It("should give up after the configured number of retries", func() {
+			statuses = []int{500, 500, 500, 500, 500, 500}
+			err := notifier.Send(context.Background(), Event{Reason: "Complete"}, endpoint("", ""))
+			Expect(err).To(MatchError(ErrUnexpectedStatus))
+			Expect(received()).To(HaveLen(defaultRetries + 1))
+		})
+
+		It("should not retry client errors", func() {
+			statuses = []int{http.StatusUnauthorized}
+			err := notifier.Send(context.Background(), Event{Reason: "Complete"}, endpoint("", ""))
+			Expect(err).To(MatchError(ErrUnexpectedStatus))
+			Expect(received()).To(HaveLen(1))
+		})
+	})
+
+	Context("Recorder", func() {
+		var (
+			ctx    context.Context
+			plan   *upgradeapiv1.Plan
+			events *record.FakeRecorder
+		)
+		BeforeEach(func() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(context.Background())
+			DeferCleanup(cancel)
+			go notifier.Run(ctx)
+			events = record.NewFakeRecorder(10)
+			plan = &upgradeapiv1.Plan{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "default"},
+				Spec: upgradeapiv1.PlanSpec{
+					Notifications: []upgradeapiv1.NotificationSpec{{URL: server.URL, SecretName: "basic"}},
+				},
+				Status: upgradeapiv1.PlanStatus{LatestVersion: "v1.0.0", LatestHash: "test-hash"},
+			}
+		})
+
+		It("should record the event and notify plan endpoints", func() {
+			notifier.AllowURLs(server.URL + "/")
+			recorder := notifier.Recorder(events)
+			recorder.Eventf(plan, corev1.EventTypeNormal, "Complete", "Jobs complete for version %s", "v1.0.0")
+			Expect(events.Events).To(Receive(Equal("Normal Complete Jobs complete for version v1.0.0")))
+			Eventually(received).Should(HaveLen(1))
+			event := received()[0].event
+			Expect(event.Kind).To(Equal("Plan"))
+			Expect(event.Namespace).To(Equal("default"))
+			Expect(event.Name).To(Equal("test-plan"))
+			Expect(event.Message).To(Equal("Jobs complete for version v1.0.0"))
+			Expect(event.LatestVersion).To(Equal("v1.0.0"))
+			Expect(event.LatestHash).To(Equal("test-hash"))
+			Expect(event.Controller).To(Equal("system-upgrade-controller"))
+			Expect(received()[0].header.Get("Authorization")).To(HavePrefix("Basic "))
+		})
+
+		It("should only notify endpoints of the events that they want", func() {
+			notifier.AllowURLs(server.URL)
+			plan.Spec.Notifications[0].Events = []string{"JobFailed"}
+			notifier.endpoints = []Endpoint{endpoint("", "")}
+			recorder := notifier.Recorder(events)
+			recorder.Event(plan, corev1.EventTypeNormal, "Validated", "Plan is valid")
+			recorder.Event(plan, corev1.EventTypeNormal, "Complete", "Jobs complete")
+			recorder.Event(plan, corev1.EventTypeWarning, "JobFailed", "Job failed")
+			Eventually(received).Should(HaveLen(3))
+			Consistently(received, "100ms").Should(HaveLen(3))
+			reasons := []string{}
+			for _, r := range received() {
+				reasons = append(reasons, r.event.Reason)
+			}
+			Expect(reasons).To(ConsistOf("Complete", "JobFailed", "JobFailed"))
+		})
+
+		It("should notify events for ClusterPlans using secrets from the controller namespace", func() {
+			clusterPlan := &upgradeapiv1.ClusterPlan{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-plan"},
+				Spec: upgradeapiv1.PlanSpec{
+					Notifications: []upgradeapiv1.NotificationSpec{{URL: server.URL, SecretName: "token"}},
+				},
+			}
+			notifier.Recorder(events).Event(clusterPlan, corev1.EventTypeNormal, "SyncJob", "Jobs synced")
+			Eventually(received).Should(HaveLen(1))
+			Expect(received()[0].event.Kind).To(Equal("ClusterPlan"))
+			Expect(received()[0].event.Namespace).To(BeEmpty())
+			Expect(received()[0].header.Get("Authorization")).To(Equal("Bearer test-token"))
+		})
+
+		It("should not notify endpoints of Plans outside the controller namespace that are not allowed", func() {
+			notifier.AllowURLs("https://hooks.example.com/upgrades", server.URL+"/allowed")
+			plan.Spec.Notifications = append(plan.Spec.Notifications,
+				upgradeapiv1.NotificationSpec{URL: server.URL + "/allowed/plan"},
+				upgradeapiv1.NotificationSpec{URL: server.URL + "/allowed-other"},
+			)
+			notifier.Recorder(events).Event(plan, corev1.EventTypeNormal, "Complete", "Jobs complete")
+			Eventually(received).Should(HaveLen(1))
+			Consistently(received, "100ms").Should(HaveLen(1))
+			Expect(notifier.Dropped()).To(BeEquivalentTo(2))
+		})
+
+		It("should notify endpoints of Plans in the controller namespace without an allowed URL", func() {
+			plan.Namespace = "system-upgrade"
+			plan.Spec.Notifications[0].SecretName = "token"
+			notifier.Recorder(events).Event(plan, corev1.EventTypeNormal, "Complete", "Jobs complete")
+			Eventually(received).Should(HaveLen(1))
+		})
+
+		It("should not block endpoints behind a slow endpoint", func() {
+			slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			}))
+			DeferCleanup(slow.Close)
+			notifier.endpoints = []Endpoint{{NotificationSpec: upgradeapiv1.NotificationSpec{URL: slow.URL}}, endpoint("", "")}
+			for range 3 {
+				notifier.Notify(Event{Reason: "Complete"})
+			}
+			Eventually(received, "500ms").Should(HaveLen(3))
+		})
+
+		It("should count events dropped because the queue for an endpoint is full", func() {
+			// the notifier is not running, so queued events are not sent
+			notifier = New("system-upgrade", "system-upgrade-controller", secretCache, endpoint("", ""))
+			for range defaultQueueSize + 2 {
+				notifier.Notify(Event{Reason: "Complete"})
+			}
+			Expect(notifier.Dropped()).To(BeEquivalentTo(2))
+		})
+
+		It("should not notify events for other objects", func() {
+			notifier.endpoints = []Endpoint{endpoint("", "")}
+			notifier.Recorder(events).Event(&corev1.Node{}, corev1.EventTypeNormal, "Complete", "Started")
+			Consistently(received, "100ms").Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// recorder is an EventRecorder that also sends events emitted for Plans and ClusterPlans as notifications.
+type recorder struct {
+	record.EventRecorder
+	notifier *Notifier
+}
+
+// Recorder wraps the EventRecorder, so that events recorded for Plans and ClusterPlans are also sent to endpoints.
+func (n *Notifier) Recorder(eventRecorder record.EventRecorder) record.EventRecorder {
+	return &recorder{EventRecorder: eventRecorder, notifier: n}
+}
+
+func (r *recorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, message)
+	r.notify(object, eventtype, reason, message)
+}
+
+func (r *recorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	r.notify(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *recorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	r.notify(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *recorder) notify(object runtime.Object, eventtype, reason, message string) {
+	event := Event{
+		Reason:     reason,
+		Type:       eventtype,
+		Message:    message,
+		Controller: r.notifier.controllerName,
+		Timestamp:  time.Now().UTC(),
+	}
+	var (
+		spec            *upgradeapiv1.PlanSpec
+		secretNamespace string
+	)
+	switch obj := object.(type) {
+	case *upgradeapiv1.Plan:
+		event.Kind, event.Namespace, event.Name = "Plan", obj.Namespace, obj.Name
+		event.LatestVersion, event.LatestHash = obj.Status.LatestVersion, obj.Status.LatestHash
+		spec, secretNamespace = &obj.Spec, obj.Namespace
+	case *upgradeapiv1.ClusterPlan:
+		event.Kind, event.Name = "ClusterPlan", obj.Name
+		event.LatestVersion, event.LatestHash = obj.Status.LatestVersion, obj.Status.LatestHash
+		spec, secretNamespace = &obj.Spec, r.notifier.controllerNamespace
+	default:
+		return
+	}
+	endpoints := make([]Endpoint, len(spec.Notifications))
+	for i, notification := range spec.Notifications {
+		endpoints[i] = Endpoint{NotificationSpec: notification, SecretNamespace: secretNamespace}
+	}
+	r.notifier.Notify(event, endpoints...)
+}
@@ -1,20 +1,26 @@
 package plan
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"fmt"
 	stdhash "hash"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/kubereboot/kured/pkg/timewindow"
 	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
 	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
 	"github.com/rancher/wrangler/v3/pkg/data"
 	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/v3/pkg/merr"
@@ -23,18 +29,44 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/kubectl/pkg/util/hash"
 )
 
 const (
-	defaultPollingInterval = 15 * time.Minute
+	defaultPollingInterval  = 15 * time.Minute
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoff     = time.Minute
+
+	// maxFailures is the number of failures recorded in the plan status; the oldest are removed first.
+	maxFailures = 1000
+	// maxFailureDetails is the number of the most recent failures recorded in the plan status with their
+	// termination message and logs; they are cleared from older failures.
+	maxFailureDetails = 10
+
+	// FailureRebootTimeout is the reason of the failure recorded for a node that did not come back from a reboot.
+	FailureRebootTimeout = "RebootTimeout"
 )
 
 var (
 	ErrDrainDeleteConflict           = fmt.Errorf("spec.drain cannot specify both deleteEmptydirData and deleteLocalData")
 	ErrDrainPodSelectorNotSelectable = fmt.Errorf("spec.drain.podSelector is not selectable")
 	ErrInvalidWindow                 = fmt.Errorf("spec.window is invalid")
+	ErrInvalidBlackout               = fmt.Errorf("spec.blackouts is invalid")
+	ErrWindowRefConflict             = fmt.Errorf("spec.windowRef cannot be specified with spec.window or spec.windows")
+	ErrInvalidMaintenanceWindow      = fmt.Errorf("maintenance window is invalid")
+	ErrInvalidNotAfter               = fmt.Errorf("spec.notAfter is not after spec.notBefore")
 	ErrInvalidDelay                  = fmt.Errorf("spec.postCompleteDelay is negative")
+	ErrInvalidSidecar                = fmt.Errorf("spec.podTemplate.sidecars is invalid")
+	ErrInvalidStep                   = fmt.Errorf("spec.steps is invalid")
+	ErrInvalidReboot                 = fmt.Errorf("spec.reboot is invalid")
+	ErrInvalidNotification           = fmt.Errorf("spec.notifications is invalid")
+	ErrInvalidRetry                  = fmt.Errorf("spec.retry is invalid")
+	ErrInvalidOnNodeFailure          = fmt.Errorf("spec.onNodeFailure is invalid")
+	ErrInvalidOrder                  = fmt.Errorf("spec.order is invalid")
+	ErrInvalidExclusiveGroup         = fmt.Errorf("spec.exclusiveGroup is invalid")
+	ErrUpgradeRequired               = fmt.Errorf("spec.upgrade is required unless spec.reboot.only is set")
 
 	PollingInterval = func(defaultValue time.Duration) time.Duration {
 		if str, ok := os.LookupEnv("SYSTEM_UPGRADE_PLAN_POLLING_INTERVAL"); ok {
@@ -57,7 +89,7 @@ func DigestStatus(plan *upgradeapiv1.Plan, secretCache corectlv1.SecretCache) (u
 			return plan.Status, err
 		}
 
-		for _, s := range plan.Spec.Secrets {
+		for _, s := range Secrets(plan) {
 			if !s.IgnoreUpdates {
 				secret, err := secretCache.Get(plan.Namespace, s.Name)
 				if err != nil {
@@ -72,11 +104,257 @@ func DigestStatus(plan *upgradeapiv1.Plan, secretCache corectlv1.SecretCache) (u
 				h.Write([]byte(secretHash))
 			}
 		}
-		plan.Status.LatestHash = fmt.Sprintf("%x", h.Sum(nil))
+		// the rollout of a new hash starts when it first becomes the latest hash
+		if latestHash := fmt.Sprintf("%x", h.Sum(nil)); latestHash != plan.Status.LatestHash {
+			rolloutStartedAt := metav1.Now()
+			plan.Status.LatestHash = latestHash
+			plan.Status.RolloutStartedAt = &rolloutStartedAt
+		}
 	}
 	return plan.Status, nil
 }
 
+// FromClusterPlan returns a Plan in the given namespace with the metadata, spec and status of the ClusterPlan,
+// so that ClusterPlans can be reconciled as Plans.
+func FromClusterPlan(clusterPlan *upgradeapiv1.ClusterPlan, namespace string) *upgradeapiv1.Plan {
+	clusterPlan = clusterPlan.DeepCopy()
+	plan := &upgradeapiv1.Plan{
+		ObjectMeta: clusterPlan.ObjectMeta,
+		Spec:       clusterPlan.Spec,
+		Status:     clusterPlan.Status,
+	}
+	plan.Namespace = namespace
+	return plan
+}
+
+// Approved returns true if the plan does not require manual approval, or if its latest hash has been
+// approved by annotation or in the status.
+func Approved(plan *upgradeapiv1.Plan) bool {
+	if plan.Spec.Approval != upgradeapiv1.ApprovalManual {
+		return true
+	}
+	return plan.Status.LatestHash != "" &&
+		(plan.Annotations[upgradeapi.AnnotationApprovedHash] == plan.Status.LatestHash || plan.Status.ApprovedHash == plan.Status.LatestHash)
+}
+
+// NodeFailure returns the Job failure recorded in the plan status for the named node, or nil if there is none.
+func NodeFailure(plan *upgradeapiv1.Plan, nodeName string) *upgradeapiv1.NodeFailure {
+	for i := range plan.Status.Failures {
+		if plan.Status.Failures[i].Node == nodeName {
+			return &plan.Status.Failures[i]
+		}
+	}
+	return nil
+}
+
+// SetNodeFailure records the Job failure in the plan status, replacing any failure recorded for the same node.
+// Failures recorded for other hashes are removed, as they are no longer relevant. At most maxFailures failures are
+// recorded, and only the most recent maxFailureDetails keep their termination message and logs.
+func SetNodeFailure(plan *upgradeapiv1.Plan, failure upgradeapiv1.NodeFailure) {
+	plan.Status.Failures = slices.DeleteFunc(plan.Status.Failures, func(f upgradeapiv1.NodeFailure) bool {
+		return f.Node == failure.Node || f.Hash != failure.Hash
+	})
+	plan.Status.Failures = append(plan.Status.Failures, failure)
+	// keep the status of plans failing on many nodes within the size limit of objects
+	if len(plan.Status.Failures) > maxFailureDetails {
+		sort.SliceStable(plan.Status.Failures, func(i, j int) bool {
+			return plan.Status.Failures[j].FailedAt.Before(&plan.Status.Failures[i].FailedAt)
+		})
+		plan.Status.Failures = plan.Status.Failures[:min(len(plan.Status.Failures), maxFailures)]
+		for i := maxFailureDetails; i < len(plan.Status.Failures); i++ {
+			plan.Status.Failures[i].TerminationMessage = ""
+			plan.Status.Failures[i].Logs = ""
+		}
+	}
+	sort.Slice(plan.Status.Failures, func(i, j int) bool {
+		return plan.Status.Failures[i].Node < plan.Status.Failures[j].Node
+	})
+}
+
+// ClearNodeFailure removes the Job failure recorded in the plan status for the named node,
+// returning true if there was one.
+func ClearNodeFailure(plan *upgradeapiv1.Plan, nodeName string) bool {
+	n := len(plan.Status.Failures)
+	plan.Status.Failures = slices.DeleteFunc(plan.Status.Failures, func(f upgradeapiv1.NodeFailure) bool {
+		return f.Node == nodeName
+	})
+	if len(plan.Status.Failures) == 0 {
+		plan.Status.Failures = nil
+	}
+	return len(plan.Status.Failures) < n
+}
+
+// FailureKind returns the kind of the failure, as matched by the retry policy: Drain if the drain container failed,
+// and Upgrade otherwise.
+func FailureKind(failure *upgradeapiv1.NodeFailure) upgradeapiv1.RetryOn {
+	if failure.Container == "drain" {
+		return upgradeapiv1.RetryOnDrain
+	}
+	return upgradeapiv1.RetryOnUpgrade
+}
+
+// RetryMaxAttempts returns the maximum number of Jobs created on a node for the same hash, as per the plan retry policy.
+func RetryMaxAttempts(plan *upgradeapiv1.Plan) int32 {
+	if plan.Spec.Retry == nil {
+		return 1
+	}
+	if plan.Spec.Retry.MaxAttempts > 0 {
+		return plan.Spec.Retry.MaxAttempts
+	}
+	return defaultRetryMaxAttempts
+}
+
+// RetryAt returns the time after which a new Job is created for the node whose Job failed, as per the plan retry policy,
+// and true if the failure is to be retried. Failures that have already been retried are not retried again, and nodes
+// that did not come back from a reboot are not rebooted again.
+func RetryAt(plan *upgradeapiv1.Plan, failure *upgradeapiv1.NodeFailure) (time.Time, bool) {
+	retry := plan.Spec.Retry
+	if retry == nil || failure.RetriedAt != nil || failure.Reason == FailureRebootTimeout || max(failure.Attempts, 1) >= RetryMaxAttempts(plan) {
+		return time.Time{}, false
+	}
+	if len(retry.On) > 0 && !slices.Contains(retry.On, FailureKind(failure)) {
+		return time.Time{}, false
+	}
+	backoff := defaultRetryBackoff
+	if retry.Backoff != nil {
+		backoff = retry.Backoff.Duration
+	}
+	return failure.FailedAt.Add(backoff), true
+}
+
+// GaveUp returns true if the failure on the node for the latest hash is neither retried nor to be retried: either the
+// plan has a retry policy that has given up on it, or the node did not come back from a reboot. No new Jobs are created
+// for the node until the plan hash changes.
+func GaveUp(plan *upgradeapiv1.Plan, failure *upgradeapiv1.NodeFailure) bool {
+	if failure == nil || failure.Hash != plan.Status.LatestHash || failure.RetriedAt != nil {
+		return false
+	}
+	if failure.Reason == FailureRebootTimeout {
+		return true
+	}
+	if plan.Spec.Retry == nil {
+		return false
+	}
+	_, retry := RetryAt(plan, failure)
+	return !retry
+}
+
+// SkippedNodes returns the names of the nodes that the plan skips as per its node failure policy: those whose Job
+// failed for the latest hash, and will not be retried. Nodes are only skipped if the policy is Skip.
+func SkippedNodes(plan *upgradeapiv1.Plan) []string {
+	if plan.Spec.OnNodeFailure != upgradeapiv1.NodeFailureSkip {
+		return nil
+	}
+	var skipped []string
+	for i := range plan.Status.Failures {
+		failure := &plan.Status.Failures[i]
+		if failure.Hash == plan.Status.LatestHash && (plan.Spec.Retry == nil || GaveUp(plan, failure)) {
+			skipped = append(skipped, failure.Node)
+		}
+	}
+	return skipped
+}
+
+// Windows returns the time windows set inline on the plan.
+func Windows(plan *upgradeapiv1.Plan) []upgradeapiv1.TimeWindowSpec {
+	var windows []upgradeapiv1.TimeWindowSpec
+	if plan.Spec.Window != nil {
+		windows = append(windows, *plan.Spec.Window)
+	}
+	return append(windows, plan.Spec.Windows...)
+}
+
+// Schedule is the set of time windows and blackouts that determine when a plan may start Jobs.
+type Schedule struct {
+	Windows   []upgradeapiv1.TimeWindowSpec
+	Blackouts []upgradeapiv1.BlackoutSpec
+}
+
+// NewSchedule returns the schedule for the plan. If the plan refers to a maintenance window, its windows
+// are used in place of those set inline on the plan, and its blackouts are applied in addition to the plan's.
+func NewSchedule(plan *upgradeapiv1.Plan, maintenanceWindow *upgradeapiv1.MaintenanceWindow) Schedule {
+	if maintenanceWindow == nil {
+		return Schedule{
+			Windows:   Windows(plan),
+			Blackouts: plan.Spec.Blackouts,
+		}
+	}
+	schedule := Schedule{
+		Windows:   make([]upgradeapiv1.TimeWindowSpec, len(maintenanceWindow.Spec.Windows)),
+		Blackouts: append(slices.Clone(plan.Spec.Blackouts), maintenanceWindow.Spec.Blackouts...),
+	}
+	for i, window := range maintenanceWindow.Spec.Windows {
+		if window.TimeZone == "" {
+			window.TimeZone = maintenanceWindow.Spec.TimeZone
+		}
+		schedule.Windows[i] = window
+	}
+	return schedule
+}
+
+// Open returns true if the schedule has no time windows, or if any of them contains the given time.
+func (s Schedule) Open(t time.Time) bool {
+	for i := range s.Windows {
+		if s.Windows[i].Contains(t) {
+			return true
+		}
+	}
+	return len(s.Windows) == 0
+}
+
+// NextOpen returns the earliest time, no later than the same day of the following week, that any of the schedule's windows
+// is open. If no window opens by then, false is returned.
+func (s Schedule) NextOpen(t time.Time) (time.Time, bool) {
+	var next time.Time
+	for i := range s.Windows {
+		if open, ok := s.Windows[i].NextOpen(t); ok && (next.IsZero() || open.Before(next)) {
+			next = open
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// EnforceEnd returns true if any of the schedule's windows enforces its end.
+func (s Schedule) EnforceEnd() bool {
+	return slices.ContainsFunc(s.Windows, func(window upgradeapiv1.TimeWindowSpec) bool {
+		return window.EnforceEnd
+	})
+}
+
+// SuspendPending returns true if any of the schedule's windows enforces its end by suspending Jobs that have not yet started.
+func (s Schedule) SuspendPending() bool {
+	return slices.ContainsFunc(s.Windows, func(window upgradeapiv1.TimeWindowSpec) bool {
+		return window.EnforceEnd && window.SuspendPending
+	})
+}
+
+// ActiveBlackout returns the blackout containing the given time, or nil if there is none.
+// If more than one blackout contains the given time, the one that ends last is returned.
+func (s Schedule) ActiveBlackout(t time.Time) *upgradeapiv1.BlackoutSpec {
+	var active *upgradeapiv1.BlackoutSpec
+	for i := range s.Blackouts {
+		blackout := &s.Blackouts[i]
+		if blackout.Contains(t) && (active == nil || blackout.End.After(active.End.Time)) {
+			active = blackout
+		}
+	}
+	return active
+}
+
+// Secrets returns the Secrets mounted for the plan, followed by those mounted only for its steps.
+// Secrets mounted for more than one step are only returned once.
+func Secrets(plan *upgradeapiv1.Plan) []upgradeapiv1.SecretSpec {
+	secrets := slices.Clone(plan.Spec.Secrets)
+	for _, step := range plan.Spec.Steps {
+		for _, secret := range step.Secrets {
+			if !slices.ContainsFunc(secrets, func(s upgradeapiv1.SecretSpec) bool { return s.Name == secret.Name }) {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+	return secrets
+}
+
 func addToHashFromAnnotation(h stdhash.Hash, plan *upgradeapiv1.Plan) error {
 	if plan.Annotations[upgradeapi.AnnotationIncludeInDigest] == "" {
 		return nil
@@ -152,20 +430,60 @@ func NodeSelector(plan *upgradeapiv1.Plan) (labels.Selector, error) {
 	return nodeSelector.Add(*requireHostname), nil
 }
 
-func SelectConcurrentNodes(plan *upgradeapiv1.Plan, nodeCache corectlv1.NodeCache) ([]*corev1.Node, error) {
+// HaltedNodes returns the names of the nodes that halt the plan as per its node failure policy: those whose Job failed
+// for the latest hash, and that the plan has given up on. Halted nodes are no longer applying, but still count
+// against the plan concurrency. Nodes are only halted if the policy is not Skip.
+func HaltedNodes(plan *upgradeapiv1.Plan) []string {
+	if plan.Spec.OnNodeFailure == upgradeapiv1.NodeFailureSkip {
+		return nil
+	}
+	var halted []string
+	for i := range plan.Status.Failures {
+		if GaveUp(plan, &plan.Status.Failures[i]) {
+			halted = append(halted, plan.Status.Failures[i].Node)
+		}
+	}
+	return halted
+}
+
+// NodeLoads returns the number of Pods on each node, by name, that have not finished and are not owned by a DaemonSet.
+// It is only called for plans that select the least loaded nodes first.
+type NodeLoads func() (map[string]int, error)
+
+// SelectConcurrentNodes returns the nodes that the plan is to be applied on: those it is already being applied on,
+// followed by candidate nodes in the order requested by the plan, up to the plan concurrency. Skipped and halted nodes
+// are not selected, and halted nodes count against the plan concurrency. The controller namespace is used to compose
+// the node labels of the plan.
+func SelectConcurrentNodes(plan *upgradeapiv1.Plan, controllerNamespace string, nodeCache corectlv1.NodeCache, nodeLoads NodeLoads) ([]*corev1.Node, error) {
 	var (
-		applying = plan.Status.Applying
-		selected []*corev1.Node
+		applying    = plan.Status.Applying
+		halted      = HaltedNodes(plan)
+		excluded    = append(SkippedNodes(plan), halted...)
+		concurrency = plan.Spec.Concurrency - int64(len(halted))
+		selected    []*corev1.Node
 	)
 	nodeSelector, err := NodeSelector(plan)
 	if err != nil {
 		return nil, err
 	}
-	requirementPlanNotLatest, err := labels.NewRequirement(upgradeapi.LabelPlanName(plan.Name), selection.NotIn, []string{"disabled", plan.Status.LatestHash})
-	if err != nil {
-		return nil, err
+	if upgradejob.RebootOnly(plan) {
+		// plans that only reboot are applied to nodes that report the reboot sentinel, regardless of the plan hash
+		requirementPlanNotDisabled, err := labels.NewRequirement(upgradejob.LabelPlanName(plan, controllerNamespace), selection.NotIn, []string{"disabled"})
+		if err != nil {
+			return nil, err
+		}
+		requirementRebootRequired, err := labels.NewRequirement(upgradejob.LabelRebootName(plan, controllerNamespace), selection.Equals, []string{upgradeapi.LabelRebootRequired})
+		if err != nil {
+			return nil, err
+		}
+		nodeSelector = nodeSelector.Add(*requirementPlanNotDisabled, *requirementRebootRequired)
+	} else {
+		requirementPlanNotLatest, err := labels.NewRequirement(upgradejob.LabelPlanName(plan, controllerNamespace), selection.NotIn, []string{"disabled", plan.Status.LatestHash})
+		if err != nil {
+			return nil, err
+		}
+		nodeSelector = nodeSelector.Add(*requirementPlanNotLatest)
 	}
-	nodeSelector = nodeSelector.Add(*requirementPlanNotLatest)
 	if len(applying) > 0 {
 		requirementApplying, err := labels.NewRequirement(corev1.LabelHostname, selection.In, applying)
 		if err != nil {
@@ -175,8 +493,14 @@ func SelectConcurrentNodes(plan *upgradeapiv1.Plan, nodeCache corectlv1.NodeCach
 		if err != nil {
 			return nil, err
 		}
+		// nodes already applying are kept first, so that they cannot be displaced by candidates
+		sort.Slice(applyingNodes, func(i, j int) bool {
+			return applyingNodes[i].Name < applyingNodes[j].Name
+		})
 		for _, node := range applyingNodes {
-			selected = append(selected, node.DeepCopy())
+			if !slices.Contains(excluded, node.Name) {
+				selected = append(selected, node.DeepCopy())
+			}
 		}
 		requirementNotApplying, err := labels.NewRequirement(corev1.LabelHostname, selection.NotIn, applying)
 		if err != nil {
@@ -186,31 +510,105 @@ func SelectConcurrentNodes(plan *upgradeapiv1.Plan, nodeCache corectlv1.NodeCach
 	}
 
 	// avoid listing, sorting, and appending candidate nodes if we can
-	if int64(len(selected)) < plan.Spec.Concurrency {
+	if int64(len(selected)) < concurrency {
 		candidateNodes, err := nodeCache.List(nodeSelector)
 		if err != nil {
 			return nil, err
 		}
+		candidateNodes = slices.DeleteFunc(candidateNodes, func(node *corev1.Node) bool {
+			return slices.Contains(excluded, node.Name)
+		})
 		// this code exists to establish a defined order for generating jobs per plan, per latest hash.
 		// it is necessary to avoid the sometimes occurrence of multiple calls to the generating handler for the same
 		// plan resource version which, due to undefined ordering when listing nodes, was causing more jobs to be
-		// generated than dictated by the plan concurrency
+		// generated than dictated by the plan concurrency. nodes that are equal in the order requested by the plan
+		// are still ordered by hash, so that the order remains defined.
+		compare, err := nodeOrder(plan, nodeLoads)
+		if err != nil {
+			return nil, err
+		}
 		sort.Slice(candidateNodes, func(i, j int) bool {
+			if c := compare(candidateNodes[i], candidateNodes[j]); c != 0 {
+				return c < 0
+			}
 			isum := sha256sum(string(candidateNodes[i].UID), string(plan.UID), plan.Status.LatestHash)
 			jsum := sha256sum(string(candidateNodes[j].UID), string(plan.UID), plan.Status.LatestHash)
 			return isum < jsum
 		})
 
-		for i := 0; i < len(candidateNodes) && int64(len(selected)) < plan.Spec.Concurrency; i++ {
+		for i := 0; i < len(candidateNodes) && int64(len(selected)) < concurrency; i++ {
 			selected = append(selected, candidateNodes[i].DeepCopy())
 		}
 	}
-	sort.Slice(selected, func(i, j int) bool {
-		return selected[i].Name < selected[j].Name
-	})
 	return selected, nil
 }
 
+// nodeOrder returns a function that compares nodes in the order requested by the plan, or that finds all nodes equal
+// if no order is requested.
+func nodeOrder(plan *upgradeapiv1.Plan, nodeLoads NodeLoads) (func(a, b *corev1.Node) int, error) {
+	if plan.Spec.Order == nil {
+		return func(a, b *corev1.Node) int { return 0 }, nil
+	}
+	switch plan.Spec.Order.By {
+	case upgradeapiv1.NodeOrderName:
+		return func(a, b *corev1.Node) int {
+			return strings.Compare(a.Name, b.Name)
+		}, nil
+	case upgradeapiv1.NodeOrderLabel:
+		key := plan.Spec.Order.Label
+		return func(a, b *corev1.Node) int {
+			av, aok := a.Labels[key]
+			bv, bok := b.Labels[key]
+			if aok != bok {
+				return compareBool(aok, bok)
+			}
+			ai, aerr := strconv.ParseInt(av, 10, 64)
+			bi, berr := strconv.ParseInt(bv, 10, 64)
+			if aerr == nil && berr == nil {
+				return cmp.Compare(ai, bi)
+			}
+			return strings.Compare(av, bv)
+		}, nil
+	case upgradeapiv1.NodeOrderOldestVersion:
+		return func(a, b *corev1.Node) int {
+			av, aerr := utilversion.ParseGeneric(a.Status.NodeInfo.KubeletVersion)
+			bv, berr := utilversion.ParseGeneric(b.Status.NodeInfo.KubeletVersion)
+			if (aerr == nil) != (berr == nil) {
+				return compareBool(aerr == nil, berr == nil)
+			}
+			if aerr != nil {
+				return 0
+			}
+			return compareBool(av.LessThan(bv), bv.LessThan(av))
+		}, nil
+	case upgradeapiv1.NodeOrderLeastLoaded:
+		loads := map[string]int{}
+		if nodeLoads != nil {
+			var err error
+			if loads, err = nodeLoads(); err != nil {
+				return nil, err
+			}
+		}
+		// nodes with equal loads are ordered by name, so that ties are broken the same way in every sync
+		return func(a, b *corev1.Node) int {
+			return cmp.Or(cmp.Compare(loads[a.Name], loads[b.Name]), strings.Compare(a.Name, b.Name))
+		}, nil
+	}
+	return func(a, b *corev1.Node) int { return 0 }, nil
+}
+
+// compareBool orders true before false.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
 func sha256sum(s ...string) string {
 	h := sha256.New()
 	for i := range s {
@@ -221,6 +619,9 @@ func sha256sum(s ...string) string {
 
 // Validate performs validation of the plan spec, raising errors for any conflicting or invalid settings.
 func Validate(plan *upgradeapiv1.Plan, secretCache corectlv1.SecretCache) error {
+	if plan.Spec.Upgrade == nil && !upgradejob.RebootOnly(plan) {
+		return ErrUpgradeRequired
+	}
 	if drainSpec := plan.Spec.Drain; drainSpec != nil {
 		if drainSpec.DeleteEmptydirData != nil && drainSpec.DeleteLocalData != nil {
 			return ErrDrainDeleteConflict
@@ -235,21 +636,118 @@ func Validate(plan *upgradeapiv1.Plan, secretCache corectlv1.SecretCache) error
 			}
 		}
 	}
-	if windowSpec := plan.Spec.Window; windowSpec != nil {
-		days := make([]string, len(windowSpec.Days))
-		for i, day := range windowSpec.Days {
-			days[i] = string(day)
-		}
-		if _, err := timewindow.New(days, windowSpec.StartTime, windowSpec.EndTime, windowSpec.TimeZone); err != nil {
+	for _, windowSpec := range Windows(plan) {
+		if err := validateWindow(windowSpec); err != nil {
 			return merr.NewErrors(ErrInvalidWindow, err)
 		}
 	}
+	if plan.Spec.WindowRef != "" && len(Windows(plan)) > 0 {
+		return ErrWindowRefConflict
+	}
+	for _, blackoutSpec := range plan.Spec.Blackouts {
+		if err := validateBlackout(blackoutSpec); err != nil {
+			return merr.NewErrors(ErrInvalidBlackout, err)
+		}
+	}
+	if notBefore, notAfter := plan.Spec.NotBefore, plan.Spec.NotAfter; notBefore != nil && notAfter != nil && !notAfter.After(notBefore.Time) {
+		return ErrInvalidNotAfter
+	}
 	if delay := plan.Spec.PostCompleteDelay; delay != nil && delay.Duration < 0 {
 		return ErrInvalidDelay
 	}
+	if err := validateSteps(plan); err != nil {
+		return merr.NewErrors(ErrInvalidStep, err)
+	}
+	if rebootSpec := plan.Spec.Reboot; rebootSpec != nil {
+		switch rebootSpec.Policy {
+		case "", upgradeapiv1.RebootNever, upgradeapiv1.RebootAlways, upgradeapiv1.RebootIfRequired:
+		default:
+			return merr.NewErrors(ErrInvalidReboot, fmt.Errorf("unknown policy %q", rebootSpec.Policy))
+		}
+		if rebootSpec.Sentinel != "" && !path.IsAbs(rebootSpec.Sentinel) {
+			return merr.NewErrors(ErrInvalidReboot, fmt.Errorf("sentinel %q is not an absolute path", rebootSpec.Sentinel))
+		}
+		if rebootSpec.Timeout != nil && rebootSpec.Timeout.Duration <= 0 {
+			return merr.NewErrors(ErrInvalidReboot, fmt.Errorf("timeout must be positive"))
+		}
+		// the reboot container checks the sentinel under /host, and enters the host PID namespace to schedule the reboot
+		if podTemplate := plan.Spec.PodTemplate; podTemplate != nil && upgradejob.RebootEnabled(plan) {
+			if podTemplate.HostPID != nil && !*podTemplate.HostPID {
+				return merr.NewErrors(ErrInvalidReboot, fmt.Errorf("reboot requires the host PID namespace, which spec.podTemplate.hostPID disables"))
+			}
+			if podTemplate.HostRoot != nil && !*podTemplate.HostRoot {
+				return merr.NewErrors(ErrInvalidReboot, fmt.Errorf("reboot requires the host root filesystem, which spec.podTemplate.hostRoot disables"))
+			}
+		}
+	}
+	if podTemplate := plan.Spec.PodTemplate; podTemplate != nil {
+		names := map[string]bool{"prepare": true, "cordon": true, "drain": true, "upgrade": true}
+		for _, step := range plan.Spec.Steps {
+			names[upgradejob.StepContainerName(step.Name)] = true
+		}
+		if upgradejob.RebootEnabled(plan) {
+			names[upgradejob.RebootContainerName] = true
+		}
+		for _, sidecar := range podTemplate.Sidecars {
+			if sidecar.Name == "" || sidecar.Image == "" {
+				return merr.NewErrors(ErrInvalidSidecar, fmt.Errorf("sidecar name and image must be specified"))
+			}
+			if names[sidecar.Name] {
+				return merr.NewErrors(ErrInvalidSidecar, fmt.Errorf("container name %q is already in use", sidecar.Name))
+			}
+			names[sidecar.Name] = true
+		}
+	}
+	for _, notification := range plan.Spec.Notifications {
+		if u, err := url.Parse(notification.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return merr.NewErrors(ErrInvalidNotification, fmt.Errorf("url %q is not an absolute http or https URL", notification.URL))
+		}
+	}
+	if group := plan.Spec.ExclusiveGroup; group != "" {
+		if errs := validation.IsValidLabelValue(group); len(errs) > 0 {
+			return merr.NewErrors(ErrInvalidExclusiveGroup, fmt.Errorf("group %q is invalid: %s", group, strings.Join(errs, ", ")))
+		}
+		if group == upgradejob.NotExclusive {
+			return merr.NewErrors(ErrInvalidExclusiveGroup, fmt.Errorf("group %q is reserved for plans that are not exclusive", group))
+		}
+	}
+	if orderSpec := plan.Spec.Order; orderSpec != nil {
+		switch orderSpec.By {
+		case upgradeapiv1.NodeOrderName, upgradeapiv1.NodeOrderOldestVersion, upgradeapiv1.NodeOrderLeastLoaded:
+			if orderSpec.Label != "" {
+				return merr.NewErrors(ErrInvalidOrder, fmt.Errorf("label must only be specified with the %s strategy", upgradeapiv1.NodeOrderLabel))
+			}
+		case upgradeapiv1.NodeOrderLabel:
+			if errs := validation.IsQualifiedName(orderSpec.Label); len(errs) > 0 {
+				return merr.NewErrors(ErrInvalidOrder, fmt.Errorf("label %q is invalid: %s", orderSpec.Label, strings.Join(errs, ", ")))
+			}
+		default:
+			return merr.NewErrors(ErrInvalidOrder, fmt.Errorf("unknown strategy %q", orderSpec.By))
+		}
+	}
+	switch plan.Spec.OnNodeFailure {
+	case "", upgradeapiv1.NodeFailureHalt, upgradeapiv1.NodeFailureSkip:
+	default:
+		return merr.NewErrors(ErrInvalidOnNodeFailure, fmt.Errorf("unknown policy %q", plan.Spec.OnNodeFailure))
+	}
+	if retrySpec := plan.Spec.Retry; retrySpec != nil {
+		if retrySpec.MaxAttempts < 0 {
+			return merr.NewErrors(ErrInvalidRetry, fmt.Errorf("maxAttempts must not be negative"))
+		}
+		if retrySpec.Backoff != nil && retrySpec.Backoff.Duration < 0 {
+			return merr.NewErrors(ErrInvalidRetry, fmt.Errorf("backoff must not be negative"))
+		}
+		for _, on := range retrySpec.On {
+			switch on {
+			case upgradeapiv1.RetryOnDrain, upgradeapiv1.RetryOnUpgrade:
+			default:
+				return merr.NewErrors(ErrInvalidRetry, fmt.Errorf("unknown failure kind %q", on))
+			}
+		}
+	}
 
 	sErrs := []error{}
-	for _, secret := range plan.Spec.Secrets {
+	for _, secret := range Secrets(plan) {
 		if secret.IgnoreUpdates {
 			continue
 		}
@@ -260,3 +758,76 @@ func Validate(plan *upgradeapiv1.Plan, secretCache corectlv1.SecretCache) error
 
 	return merr.NewErrors(sErrs...)
 }
+
+// ValidateMaintenanceWindow validates the time zone, windows and blackouts of the MaintenanceWindow, as they would be
+// applied to the Plans that refer to it.
+func ValidateMaintenanceWindow(maintenanceWindow *upgradeapiv1.MaintenanceWindow) error {
+	if _, err := time.LoadLocation(maintenanceWindow.Spec.TimeZone); err != nil {
+		return merr.NewErrors(ErrInvalidMaintenanceWindow, fmt.Errorf("spec.timeZone: %v", err))
+	}
+	for i, windowSpec := range NewSchedule(&upgradeapiv1.Plan{}, maintenanceWindow).Windows {
+		if err := validateWindow(windowSpec); err != nil {
+			return merr.NewErrors(ErrInvalidMaintenanceWindow, fmt.Errorf("spec.windows[%d]: %v", i, err))
+		}
+	}
+	for i, blackoutSpec := range maintenanceWindow.Spec.Blackouts {
+		if err := validateBlackout(blackoutSpec); err != nil {
+			return merr.NewErrors(ErrInvalidMaintenanceWindow, fmt.Errorf("spec.blackouts[%d]: %v", i, err))
+		}
+	}
+	return nil
+}
+
+// validateWindow checks that the days, start and end times, and time zone of the time window can be parsed.
+func validateWindow(windowSpec upgradeapiv1.TimeWindowSpec) error {
+	days := make([]string, len(windowSpec.Days))
+	for i, day := range windowSpec.Days {
+		days[i] = string(day)
+	}
+	_, err := timewindow.New(days, windowSpec.StartTime, windowSpec.EndTime, windowSpec.TimeZone)
+	return err
+}
+
+// validateBlackout checks that the blackout ends after it starts.
+func validateBlackout(blackoutSpec upgradeapiv1.BlackoutSpec) error {
+	if !blackoutSpec.End.After(blackoutSpec.Start.Time) {
+		return fmt.Errorf("end %s is not after start %s", blackoutSpec.End.Format(time.RFC3339), blackoutSpec.Start.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// validateSteps checks that step names are unique DNS labels, and that volumes and secrets
+// mounted for steps do not conflict with those mounted for the rest of the plan.
+func validateSteps(plan *upgradeapiv1.Plan) error {
+	names := map[string]bool{}
+	volumes := map[string]string{}
+	if plan.Spec.Upgrade != nil {
+		for _, v := range plan.Spec.Upgrade.Volumes {
+			volumes[v.Name] = v.Source
+		}
+	}
+	for _, step := range plan.Spec.Steps {
+		if errs := validation.IsDNS1123Label(step.Name); len(errs) > 0 {
+			return fmt.Errorf("step name %q is invalid: %s", step.Name, strings.Join(errs, ", "))
+		}
+		if names[step.Name] {
+			return fmt.Errorf("step name %q is already in use", step.Name)
+		}
+		names[step.Name] = true
+		if step.Image == "" {
+			return fmt.Errorf("step %q image must be specified", step.Name)
+		}
+		for _, v := range step.Volumes {
+			if source, ok := volumes[v.Name]; ok && source != v.Source {
+				return fmt.Errorf("step %q volume %q conflicts with another volume of the same name", step.Name, v.Name)
+			}
+			volumes[v.Name] = v.Source
+		}
+		for _, secret := range step.Secrets {
+			if slices.ContainsFunc(plan.Spec.Secrets, func(s upgradeapiv1.SecretSpec) bool { return s.Name == secret.Name }) {
+				return fmt.Errorf("step %q secret %q is already mounted for the plan", step.Name, secret.Name)
+			}
+		}
+	}
+	return nil
+}
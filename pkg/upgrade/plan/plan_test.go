@@ -0,0 +1,630 @@
+package plan_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	"github.com/rancher/wrangler/v3/pkg/generic"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		plan     *upgradeapiv1.Plan
+		failure  *upgradeapiv1.NodeFailure
+		failedAt time.Time
+	)
+
+	BeforeEach(func() {
+		failedAt = time.Now().Truncate(time.Second)
+		plan = &upgradeapiv1.Plan{
+			Spec: upgradeapiv1.PlanSpec{
+				Retry: &upgradeapiv1.RetrySpec{MaxAttempts: 2, Backoff: &metav1.Duration{Duration: 5 * time.Minute}},
+			},
+			Status: upgradeapiv1.PlanStatus{LatestHash: "hash-1"},
+		}
+		failure = &upgradeapiv1.NodeFailure{
+			Node:      "node-1",
+			Job:       "apply-test-plan-on-node-1",
+			Hash:      "hash-1",
+			Container: "upgrade",
+			FailedAt:  metav1.NewTime(failedAt),
+			Attempts:  1,
+		}
+	})
+
+	It("should retry after the backoff until the maximum number of attempts", func() {
+		retryAt, ok := upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeTrue())
+		Expect(retryAt).To(BeTemporally("==", failedAt.Add(5*time.Minute)))
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeFalse())
+
+		failure.Attempts = 2
+		_, ok = upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeFalse())
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeTrue())
+	})
+
+	It("should use the default attempts and backoff", func() {
+		plan.Spec.Retry = &upgradeapiv1.RetrySpec{}
+		Expect(upgradeplan.RetryMaxAttempts(plan)).To(BeEquivalentTo(3))
+		retryAt, ok := upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeTrue())
+		Expect(retryAt).To(BeTemporally("==", failedAt.Add(time.Minute)))
+	})
+
+	It("should only retry the selected kinds of failure", func() {
+		plan.Spec.Retry.On = []upgradeapiv1.RetryOn{upgradeapiv1.RetryOnDrain}
+		_, ok := upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeFalse())
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeTrue())
+
+		failure.Container = "drain"
+		Expect(upgradeplan.FailureKind(failure)).To(Equal(upgradeapiv1.RetryOnDrain))
+		_, ok = upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should give up on nodes that did not come back from a reboot, even without a retry policy", func() {
+		failure.Reason = upgradeplan.FailureRebootTimeout
+		_, ok := upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeFalse())
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeTrue())
+
+		plan.Spec.Retry = nil
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeTrue())
+		Expect(upgradeplan.HaltedNodes(plan)).To(BeEmpty())
+
+		plan.Status.Failures = []upgradeapiv1.NodeFailure{*failure}
+		Expect(upgradeplan.HaltedNodes(plan)).To(Equal([]string{"node-1"}))
+	})
+
+	It("should not retry a failure more than once, or without a retry policy", func() {
+		failure.RetriedAt = &metav1.Time{Time: failedAt.Add(5 * time.Minute)}
+		_, ok := upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeFalse())
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeFalse())
+
+		failure.RetriedAt = nil
+		plan.Spec.Retry = nil
+		_, ok = upgradeplan.RetryAt(plan, failure)
+		Expect(ok).To(BeFalse())
+		Expect(upgradeplan.GaveUp(plan, failure)).To(BeFalse())
+	})
+})
+
+var _ = Describe("SetNodeFailure", func() {
+	var (
+		plan     *upgradeapiv1.Plan
+		failedAt time.Time
+	)
+
+	BeforeEach(func() {
+		failedAt = time.Now().Truncate(time.Second)
+		plan = &upgradeapiv1.Plan{Status: upgradeapiv1.PlanStatus{LatestHash: "hash-1"}}
+	})
+
+	setFailures := func(n int) {
+		for i := range n {
+			upgradeplan.SetNodeFailure(plan, upgradeapiv1.NodeFailure{
+				Node:               fmt.Sprintf("node-%04d", i),
+				Hash:               "hash-1",
+				TerminationMessage: "failed",
+				Logs:               "logs",
+				FailedAt:           metav1.NewTime(failedAt.Add(time.Duration(i) * time.Second)),
+				Attempts:           1,
+			})
+		}
+	}
+
+	It("should replace the failure on the node, and failures for other hashes", func() {
+		setFailures(2)
+		upgradeplan.SetNodeFailure(plan, upgradeapiv1.NodeFailure{Node: "node-0000", Hash: "hash-1", Attempts: 2})
+		Expect(plan.Status.Failures).To(HaveLen(2))
+		Expect(upgradeplan.NodeFailure(plan, "node-0000").Attempts).To(BeEquivalentTo(2))
+
+		upgradeplan.SetNodeFailure(plan, upgradeapiv1.NodeFailure{Node: "node-0002", Hash: "hash-2"})
+		Expect(plan.Status.Failures).To(HaveLen(1))
+	})
+
+	It("should only keep the details of the most recent failures", func() {
+		setFailures(15)
+		Expect(plan.Status.Failures).To(HaveLen(15))
+		Expect(plan.Status.Failures[0].Node).To(Equal("node-0000"))
+		var detailed []string
+		for _, failure := range plan.Status.Failures {
+			if failure.Logs != "" {
+				Expect(failure.TerminationMessage).ToNot(BeEmpty())
+				detailed = append(detailed, failure.Node)
+			}
+		}
+		Expect(detailed).To(HaveLen(10))
+		Expect(detailed[0]).To(Equal("node-0005"))
+	})
+
+	It("should remove the oldest failures", func() {
+		setFailures(1002)
+		Expect(plan.Status.Failures).To(HaveLen(1000))
+		Expect(upgradeplan.NodeFailure(plan, "node-0000")).To(BeNil())
+		Expect(upgradeplan.NodeFailure(plan, "node-0001")).To(BeNil())
+		Expect(upgradeplan.NodeFailure(plan, "node-0002")).ToNot(BeNil())
+		Expect(upgradeplan.NodeFailure(plan, "node-1001")).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("SkippedNodes", func() {
+	var plan *upgradeapiv1.Plan
+
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			Spec: upgradeapiv1.PlanSpec{OnNodeFailure: upgradeapiv1.NodeFailureSkip},
+			Status: upgradeapiv1.PlanStatus{
+				LatestHash: "hash-2",
+				Failures: []upgradeapiv1.NodeFailure{
+					{Node: "node-1", Hash: "hash-1", Attempts: 1},
+					{Node: "node-2", Hash: "hash-2", Attempts: 1},
+					{Node: "node-3", Hash: "hash-2", Attempts: 2},
+				},
+			},
+		}
+	})
+
+	It("should skip nodes whose Job failed for the latest hash", func() {
+		Expect(upgradeplan.SkippedNodes(plan)).To(Equal([]string{"node-2", "node-3"}))
+	})
+
+	It("should only skip nodes that will not be retried", func() {
+		plan.Spec.Retry = &upgradeapiv1.RetrySpec{MaxAttempts: 2}
+		Expect(upgradeplan.SkippedNodes(plan)).To(Equal([]string{"node-3"}))
+	})
+
+	It("should not skip nodes unless the policy is Skip", func() {
+		plan.Spec.OnNodeFailure = upgradeapiv1.NodeFailureHalt
+		Expect(upgradeplan.SkippedNodes(plan)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("HaltedNodes", func() {
+	var plan *upgradeapiv1.Plan
+
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			Spec: upgradeapiv1.PlanSpec{Retry: &upgradeapiv1.RetrySpec{MaxAttempts: 2}},
+			Status: upgradeapiv1.PlanStatus{
+				LatestHash: "hash-2",
+				Failures: []upgradeapiv1.NodeFailure{
+					{Node: "node-1", Hash: "hash-1", Attempts: 2},
+					{Node: "node-2", Hash: "hash-2", Attempts: 1},
+					{Node: "node-3", Hash: "hash-2", Attempts: 2},
+				},
+			},
+		}
+	})
+
+	It("should halt on nodes that the retry policy has given up on", func() {
+		Expect(upgradeplan.HaltedNodes(plan)).To(Equal([]string{"node-3"}))
+	})
+
+	It("should not halt on nodes without a retry policy, or if the policy is Skip", func() {
+		plan.Spec.OnNodeFailure = upgradeapiv1.NodeFailureSkip
+		Expect(upgradeplan.HaltedNodes(plan)).To(BeEmpty())
+
+		plan.Spec.OnNodeFailure = upgradeapiv1.NodeFailureHalt
+		plan.Spec.Retry = nil
+		Expect(upgradeplan.HaltedNodes(plan)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("SelectConcurrentNodes", func() {
+	var (
+		plan        *upgradeapiv1.Plan
+		nodeIndexer cache.Indexer
+		nodeCache   *generic.NonNamespacedCache[*corev1.Node]
+	)
+
+	// names returns the names of the nodes.
+	names := func(nodes []*corev1.Node) []string {
+		names := make([]string, len(nodes))
+		for i, node := range nodes {
+			names[i] = node.Name
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade", UID: types.UID("test-plan-uid")},
+			Spec: upgradeapiv1.PlanSpec{
+				Concurrency:  2,
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"upgrade": "true"}},
+			},
+			Status: upgradeapiv1.PlanStatus{LatestHash: "hash-1"},
+		}
+		nodeIndexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		for i := 0; i < 5; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			Expect(nodeIndexer.Add(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					UID:    types.UID(name + "-uid"),
+					Labels: map[string]string{corev1.LabelHostname: name, "upgrade": "true"},
+				},
+			})).To(Succeed())
+		}
+		nodeCache = generic.NewNonNamespacedCache[*corev1.Node](nodeIndexer, corev1.Resource("nodes"))
+	})
+
+	It("should not select halted nodes, which count against the concurrency", func() {
+		plan.Spec.Retry = &upgradeapiv1.RetrySpec{MaxAttempts: 1}
+		plan.Status.Applying = []string{"node-1", "node-2"}
+		plan.Status.Failures = []upgradeapiv1.NodeFailure{{Node: "node-1", Hash: "hash-1", Attempts: 1}}
+
+		nodes, err := upgradeplan.SelectConcurrentNodes(plan, "system-upgrade", nodeCache, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(nodes)).To(Equal([]string{"node-2"}))
+	})
+
+	It("should not select skipped nodes, which do not count against the concurrency", func() {
+		plan.Spec.OnNodeFailure = upgradeapiv1.NodeFailureSkip
+		plan.Status.Applying = []string{"node-1", "node-2"}
+		plan.Status.Failures = []upgradeapiv1.NodeFailure{{Node: "node-1", Hash: "hash-1", Attempts: 1}}
+
+		nodes, err := upgradeplan.SelectConcurrentNodes(plan, "system-upgrade", nodeCache, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodes).To(HaveLen(2))
+		Expect(names(nodes)).To(ContainElement("node-2"))
+		Expect(names(nodes)).ToNot(ContainElement("node-1"))
+	})
+
+	It("should keep nodes already applying first, ahead of less loaded candidates", func() {
+		plan.Spec.Order = &upgradeapiv1.OrderSpec{By: upgradeapiv1.NodeOrderLeastLoaded}
+		plan.Status.Applying = []string{"node-3"}
+		loads := func() (map[string]int, error) {
+			return map[string]int{"node-0": 1, "node-1": 2, "node-2": 2, "node-3": 9, "node-4": 0}, nil
+		}
+
+		nodes, err := upgradeplan.SelectConcurrentNodes(plan, "system-upgrade", nodeCache, loads)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names(nodes)).To(Equal([]string{"node-3", "node-4"}))
+	})
+
+	It("should break ties between equally loaded nodes by name", func() {
+		plan.Spec.Concurrency = 3
+		plan.Spec.Order = &upgradeapiv1.OrderSpec{By: upgradeapiv1.NodeOrderLeastLoaded}
+		loads := func() (map[string]int, error) {
+			return map[string]int{"node-0": 3, "node-1": 2, "node-2": 2, "node-3": 2, "node-4": 3}, nil
+		}
+
+		for range 3 {
+			nodes, err := upgradeplan.SelectConcurrentNodes(plan, "system-upgrade", nodeCache, loads)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names(nodes)).To(Equal([]string{"node-1", "node-2", "node-3"}))
+		}
+	})
+})
+
+var _ = Describe("DigestStatus", func() {
+	It("should record when the rollout of a new hash started", func() {
+		plan := &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade"},
+			Status:     upgradeapiv1.PlanStatus{LatestVersion: "v1.0.0"},
+		}
+		status, err := upgradeplan.DigestStatus(plan, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.LatestHash).ToNot(BeEmpty())
+		Expect(status.RolloutStartedAt).ToNot(BeNil())
+		started := *status.RolloutStartedAt
+
+		// the start is kept while the hash is unchanged
+		plan.Status = status
+		plan.Status.RolloutStartedAt = &metav1.Time{Time: started.Add(-time.Hour)}
+		status, err = upgradeplan.DigestStatus(plan, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.RolloutStartedAt.Time).To(Equal(started.Add(-time.Hour)))
+
+		plan.Status.LatestVersion = "v1.1.0"
+		status, err = upgradeplan.DigestStatus(plan, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.RolloutStartedAt.Time).To(BeTemporally(">=", started.Time))
+	})
+})
+
+var _ = Describe("Approved", func() {
+	var plan *upgradeapiv1.Plan
+
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade"},
+			Spec:       upgradeapiv1.PlanSpec{Approval: upgradeapiv1.ApprovalManual},
+			Status:     upgradeapiv1.PlanStatus{LatestHash: "hash-1"},
+		}
+	})
+
+	It("should approve the latest hash by annotation or in the status", func() {
+		Expect(upgradeplan.Approved(plan)).To(BeFalse())
+		plan.Annotations = map[string]string{upgradeapi.AnnotationApprovedHash: "hash-1"}
+		Expect(upgradeplan.Approved(plan)).To(BeTrue())
+		plan.Annotations = nil
+		plan.Status.ApprovedHash = "hash-1"
+		Expect(upgradeplan.Approved(plan)).To(BeTrue())
+	})
+
+	It("should not approve a new hash until it is approved", func() {
+		plan.Annotations = map[string]string{upgradeapi.AnnotationApprovedHash: "hash-1"}
+		plan.Status.ApprovedHash = "hash-1"
+		plan.Status.LatestHash = "hash-2"
+		Expect(upgradeplan.Approved(plan)).To(BeFalse())
+	})
+
+	It("should not approve a plan without a hash", func() {
+		plan.Status.LatestHash = ""
+		plan.Annotations = map[string]string{upgradeapi.AnnotationApprovedHash: ""}
+		Expect(upgradeplan.Approved(plan)).To(BeFalse())
+	})
+
+	It("should approve plans that do not require manual approval", func() {
+		plan.Spec.Approval = ""
+		Expect(upgradeplan.Approved(plan)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Validate", func() {
+	var plan *upgradeapiv1.Plan
+
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade"},
+			Spec: upgradeapiv1.PlanSpec{
+				Concurrency: 1,
+				Version:     "v1.0.0",
+				Upgrade:     &upgradeapiv1.ContainerSpec{Image: "test/image"},
+				Reboot:      &upgradeapiv1.RebootSpec{Policy: upgradeapiv1.RebootAlways},
+				PodTemplate: &upgradeapiv1.PodTemplateSpec{},
+			},
+		}
+	})
+
+	It("should accept a pod template that keeps the host PID namespace and root filesystem", func() {
+		plan.Spec.PodTemplate.HostPID = ptr.To(true)
+		plan.Spec.PodTemplate.HostRoot = ptr.To(true)
+		Expect(upgradeplan.Validate(plan, nil)).To(Succeed())
+	})
+
+	It("should reject a pod template that disables the host PID namespace or root filesystem for reboot", func() {
+		plan.Spec.PodTemplate.HostPID = ptr.To(false)
+		Expect(upgradeplan.Validate(plan, nil)).To(MatchError(ContainSubstring(upgradeplan.ErrInvalidReboot.Error())))
+
+		plan.Spec.PodTemplate.HostPID = nil
+		plan.Spec.PodTemplate.HostRoot = ptr.To(false)
+		Expect(upgradeplan.Validate(plan, nil)).To(MatchError(ContainSubstring(upgradeplan.ErrInvalidReboot.Error())))
+
+		plan.Spec.Reboot.Policy = upgradeapiv1.RebootNever
+		Expect(upgradeplan.Validate(plan, nil)).To(Succeed())
+	})
+
+	It("should reject a notAfter that is not after notBefore", func() {
+		notBefore := metav1.NewTime(time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC))
+		plan.Spec.NotBefore = &notBefore
+		plan.Spec.NotAfter = &notBefore
+		Expect(upgradeplan.Validate(plan, nil)).To(MatchError(upgradeplan.ErrInvalidNotAfter))
+
+		notAfter := metav1.NewTime(notBefore.Add(time.Second))
+		plan.Spec.NotAfter = &notAfter
+		Expect(upgradeplan.Validate(plan, nil)).To(Succeed())
+
+		// either may be set alone
+		plan.Spec.NotBefore = nil
+		Expect(upgradeplan.Validate(plan, nil)).To(Succeed())
+	})
+})
+
+var _ = Describe("Schedule", func() {
+	var (
+		schedule upgradeplan.Schedule
+		start    time.Time
+	)
+
+	BeforeEach(func() {
+		// a Saturday
+		start = time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)
+		schedule = upgradeplan.Schedule{
+			Windows: []upgradeapiv1.TimeWindowSpec{
+				{Days: []upgradeapiv1.Day{"saturday"}, StartTime: "01:00", EndTime: "03:00"},
+				{Days: []upgradeapiv1.Day{"saturday", "sunday"}, StartTime: "22:00", EndTime: "23:00"},
+			},
+			Blackouts: []upgradeapiv1.BlackoutSpec{
+				{Start: metav1.NewTime(start.Add(2 * time.Hour)), End: metav1.NewTime(start.Add(5 * time.Hour)), Reason: "first"},
+				{Start: metav1.NewTime(start.Add(4 * time.Hour)), End: metav1.NewTime(start.Add(8 * time.Hour)), Reason: "second"},
+			},
+		}
+	})
+
+	It("should be open if any of its windows is open", func() {
+		Expect(schedule.Open(start.Add(30 * time.Minute))).To(BeFalse())
+		Expect(schedule.Open(start.Add(time.Hour))).To(BeTrue())
+		Expect(schedule.Open(start.Add(4 * time.Hour))).To(BeFalse())
+		Expect(schedule.Open(start.Add(22 * time.Hour))).To(BeTrue())
+		Expect(schedule.Open(start.Add(25 * time.Hour))).To(BeFalse())
+		Expect(schedule.Open(start.Add(46 * time.Hour))).To(BeTrue())
+	})
+
+	It("should always be open without windows", func() {
+		schedule.Windows = nil
+		Expect(schedule.Open(start)).To(BeTrue())
+	})
+
+	It("should open next at the earliest of its windows", func() {
+		next, ok := schedule.NextOpen(start.Add(30 * time.Minute))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(time.Hour)))
+
+		next, ok = schedule.NextOpen(start.Add(4 * time.Hour))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(22 * time.Hour)))
+
+		next, ok = schedule.NextOpen(start.Add(time.Hour + 30*time.Minute))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(time.Hour + 30*time.Minute)))
+	})
+
+	It("should open next at the start of a window on the same day of the following week", func() {
+		schedule.Windows = schedule.Windows[:1]
+		next, ok := schedule.NextOpen(start.Add(4 * time.Hour))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(7*24*time.Hour + time.Hour)))
+	})
+
+	It("should open next at midnight on the days of a window that spans midnight", func() {
+		schedule.Windows = []upgradeapiv1.TimeWindowSpec{{Days: []upgradeapiv1.Day{"saturday"}, StartTime: "23:00", EndTime: "01:00"}}
+		next, ok := schedule.NextOpen(start.Add(-30 * time.Minute))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start))
+
+		next, ok = schedule.NextOpen(start.Add(2 * time.Hour))
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(23 * time.Hour)))
+	})
+
+	It("should open next at the start of a window in its time zone", func() {
+		schedule.Windows = []upgradeapiv1.TimeWindowSpec{{Days: []upgradeapiv1.Day{"saturday"}, StartTime: "01:00", EndTime: "03:00", TimeZone: "America/New_York"}}
+		next, ok := schedule.NextOpen(start)
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(start.Add(6 * time.Hour)))
+	})
+
+	It("should not open if none of its windows can be parsed", func() {
+		schedule.Windows = []upgradeapiv1.TimeWindowSpec{{Days: []upgradeapiv1.Day{"saturday"}, StartTime: "not a time", EndTime: "03:00"}}
+		Expect(schedule.Open(start.Add(time.Hour))).To(BeFalse())
+		_, ok := schedule.NextOpen(start)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should return the blackout that ends last when blackouts overlap", func() {
+		Expect(schedule.ActiveBlackout(start.Add(3 * time.Hour)).Reason).To(Equal("first"))
+		Expect(schedule.ActiveBlackout(start.Add(4*time.Hour + 30*time.Minute)).Reason).To(Equal("second"))
+		Expect(schedule.ActiveBlackout(start.Add(6 * time.Hour)).Reason).To(Equal("second"))
+
+		// the order of the blackouts does not matter
+		schedule.Blackouts[0], schedule.Blackouts[1] = schedule.Blackouts[1], schedule.Blackouts[0]
+		Expect(schedule.ActiveBlackout(start.Add(4*time.Hour + 30*time.Minute)).Reason).To(Equal("second"))
+	})
+
+	It("should include the start of a blackout, but not its end", func() {
+		Expect(schedule.ActiveBlackout(start.Add(2*time.Hour - time.Nanosecond))).To(BeNil())
+		Expect(schedule.ActiveBlackout(start.Add(2 * time.Hour)).Reason).To(Equal("first"))
+		Expect(schedule.ActiveBlackout(start.Add(8*time.Hour - time.Nanosecond)).Reason).To(Equal("second"))
+		Expect(schedule.ActiveBlackout(start.Add(8 * time.Hour))).To(BeNil())
+	})
+})
+
+var _ = Describe("MaintenanceWindow", func() {
+	var (
+		plan              *upgradeapiv1.Plan
+		maintenanceWindow *upgradeapiv1.MaintenanceWindow
+		start             time.Time
+	)
+
+	BeforeEach(func() {
+		// a Saturday
+		start = time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade"},
+			Spec: upgradeapiv1.PlanSpec{
+				Concurrency: 1,
+				Version:     "v1.0.0",
+				Upgrade:     &upgradeapiv1.ContainerSpec{Image: "test/image"},
+				WindowRef:   "weekends",
+				Blackouts: []upgradeapiv1.BlackoutSpec{{
+					Start: metav1.NewTime(start.Add(3 * time.Hour)),
+					End:   metav1.NewTime(start.Add(4 * time.Hour)),
+				}},
+			},
+		}
+		maintenanceWindow = &upgradeapiv1.MaintenanceWindow{
+			ObjectMeta: metav1.ObjectMeta{Name: "weekends"},
+			Spec: upgradeapiv1.MaintenanceWindowSpec{
+				Windows: []upgradeapiv1.TimeWindowSpec{
+					{Days: []upgradeapiv1.Day{"saturday"}, StartTime: "01:00", EndTime: "05:00"},
+					{Days: []upgradeapiv1.Day{"sunday"}, StartTime: "01:00", EndTime: "05:00", TimeZone: "UTC"},
+				},
+				Blackouts: []upgradeapiv1.BlackoutSpec{{
+					Start:  metav1.NewTime(start.Add(24 * time.Hour)),
+					End:    metav1.NewTime(start.Add(48 * time.Hour)),
+					Reason: "change freeze",
+				}},
+				TimeZone: "America/New_York",
+			},
+		}
+	})
+
+	It("should resolve the windows of the referenced MaintenanceWindow, in its time zone", func() {
+		Expect(upgradeplan.Validate(plan, nil)).To(Succeed())
+		schedule := upgradeplan.NewSchedule(plan, maintenanceWindow)
+		Expect(schedule.Windows).To(HaveLen(2))
+		Expect(schedule.Windows[0].TimeZone).To(Equal("America/New_York"))
+		Expect(schedule.Windows[1].TimeZone).To(Equal("UTC"))
+		// the referenced MaintenanceWindow is not modified
+		Expect(maintenanceWindow.Spec.Windows[0].TimeZone).To(BeEmpty())
+
+		// 01:00 UTC on Saturday is 20:00 on Friday in New York
+		Expect(schedule.Open(start.Add(time.Hour))).To(BeFalse())
+		Expect(schedule.Open(start.Add(7 * time.Hour))).To(BeTrue())
+		Expect(schedule.Open(start.Add(25 * time.Hour))).To(BeTrue())
+	})
+
+	It("should apply the blackouts of both the plan and the MaintenanceWindow", func() {
+		schedule := upgradeplan.NewSchedule(plan, maintenanceWindow)
+		Expect(schedule.Blackouts).To(HaveLen(2))
+		Expect(schedule.ActiveBlackout(start.Add(3*time.Hour + 30*time.Minute))).To(Equal(&plan.Spec.Blackouts[0]))
+		Expect(schedule.ActiveBlackout(start.Add(25 * time.Hour)).Reason).To(Equal("change freeze"))
+		Expect(schedule.ActiveBlackout(start.Add(49 * time.Hour))).To(BeNil())
+	})
+
+	It("should use the windows of the plan if it does not refer to a MaintenanceWindow", func() {
+		plan.Spec.WindowRef = ""
+		plan.Spec.Window = &upgradeapiv1.TimeWindowSpec{Days: []upgradeapiv1.Day{"monday"}, StartTime: "01:00", EndTime: "05:00"}
+		schedule := upgradeplan.NewSchedule(plan, nil)
+		Expect(schedule.Windows).To(Equal([]upgradeapiv1.TimeWindowSpec{*plan.Spec.Window}))
+		Expect(schedule.Blackouts).To(Equal(plan.Spec.Blackouts))
+	})
+
+	It("should reject plans that refer to a MaintenanceWindow and set windows", func() {
+		plan.Spec.Windows = []upgradeapiv1.TimeWindowSpec{{Days: []upgradeapiv1.Day{"monday"}, StartTime: "01:00", EndTime: "05:00"}}
+		Expect(upgradeplan.Validate(plan, nil)).To(MatchError(upgradeplan.ErrWindowRefConflict))
+	})
+
+	It("should accept a valid MaintenanceWindow", func() {
+		Expect(upgradeplan.ValidateMaintenanceWindow(maintenanceWindow)).To(Succeed())
+		Expect(upgradeplan.ValidateMaintenanceWindow(&upgradeapiv1.MaintenanceWindow{})).To(Succeed())
+	})
+
+	It("should reject a MaintenanceWindow with an invalid time zone", func() {
+		maintenanceWindow.Spec.TimeZone = "Invalid/Zone"
+		err := upgradeplan.ValidateMaintenanceWindow(maintenanceWindow)
+		Expect(err).To(MatchError(ContainSubstring(upgradeplan.ErrInvalidMaintenanceWindow.Error())))
+		Expect(err).To(MatchError(ContainSubstring("spec.timeZone")))
+	})
+
+	It("should reject a MaintenanceWindow with an invalid window", func() {
+		maintenanceWindow.Spec.Windows[1].EndTime = "not a time"
+		Expect(upgradeplan.ValidateMaintenanceWindow(maintenanceWindow)).To(MatchError(ContainSubstring("spec.windows[1]")))
+
+		maintenanceWindow.Spec.Windows[1].EndTime = "05:00"
+		maintenanceWindow.Spec.Windows[1].TimeZone = "Invalid/Zone"
+		Expect(upgradeplan.ValidateMaintenanceWindow(maintenanceWindow)).To(MatchError(ContainSubstring("spec.windows[1]")))
+	})
+
+	It("should reject a MaintenanceWindow with a blackout that does not end after it starts", func() {
+		maintenanceWindow.Spec.Blackouts[0].End = maintenanceWindow.Spec.Blackouts[0].Start
+		Expect(upgradeplan.ValidateMaintenanceWindow(maintenanceWindow)).To(MatchError(ContainSubstring("spec.blackouts[0]")))
+	})
+})
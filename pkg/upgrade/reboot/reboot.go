@@ -0,0 +1,142 @@
+package reboot
+
+import (
+	"sort"
+	"time"
+
+	upgradeapi "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradejob "github.com/rancher/system-upgrade-controller/pkg/upgrade/job"
+	"github.com/rancher/wrangler/v3/pkg/name"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// checkScript idles until terminated; the sentinel is checked by the readiness probe.
+	checkScript = `trap "exit 0" TERM; while true; do sleep 3600 & wait; done`
+
+	checkPeriodSeconds = int32(60)
+)
+
+// NewCheck returns a DaemonSet that runs a reboot check Pod on each Node selected by the Plan.
+// The check Pod is ready if the reboot sentinel exists on the host.
+func NewCheck(plan *upgradeapiv1.Plan, controllerName string) *appsv1.DaemonSet {
+	hostPathDirectory := corev1.HostPathDirectory
+	checkLabels := labels.Set{
+		upgradeapi.LabelController:  controllerName,
+		upgradeapi.LabelRebootCheck: plan.Name,
+	}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.SafeConcatName("reboot-check", plan.Name),
+			Namespace: plan.Namespace,
+			Labels:    checkLabels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: checkLabels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: checkLabels,
+				},
+				Spec: corev1.PodSpec{
+					AutomountServiceAccountToken: pointer.Bool(false),
+					PriorityClassName:            plan.Spec.PriorityClassName,
+					Affinity: &corev1.Affinity{
+						NodeAffinity: &corev1.NodeAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+								NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+									MatchExpressions: nodeSelectorRequirements(plan.Spec.NodeSelector),
+								}},
+							},
+						},
+					},
+					Tolerations: append([]corev1.Toleration{{
+						Key:      corev1.TaintNodeUnschedulable,
+						Operator: corev1.TolerationOpExists,
+						Effect:   corev1.TaintEffectNoSchedule,
+					}}, plan.Spec.Tolerations...),
+					Volumes: []corev1.Volume{{
+						Name: `host-root`,
+						VolumeSource: corev1.VolumeSource{
+							HostPath: &corev1.HostPathVolumeSource{
+								Path: "/", Type: &hostPathDirectory,
+							},
+						},
+					}},
+					Containers: []corev1.Container{{
+						Name:            "check",
+						Image:           upgradejob.RebootImage,
+						ImagePullPolicy: upgradejob.ImagePullPolicy,
+						Command:         []string{"sh", "-c", checkScript},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name: "host-root", MountPath: "/host", ReadOnly: true,
+						}},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								Exec: &corev1.ExecAction{
+									Command: []string{"test", "-e", "/host" + upgradejob.RebootSentinel(plan)},
+								},
+							},
+							PeriodSeconds: checkPeriodSeconds,
+						},
+					}},
+					ImagePullSecrets: plan.Spec.ImagePullSecrets,
+				},
+			},
+		},
+	}
+}
+
+// IsRequired returns true if the reboot check Pod reports that the Node requires a reboot, and has become ready since the
+// given time. Readiness from before the Node was last rebooted is stale, as the Pod may not have been probed since.
+func IsRequired(pod *corev1.Pod, since time.Time) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue && condition.LastTransitionTime.Time.After(since)
+		}
+	}
+	return false
+}
+
+// nodeSelectorRequirements converts the Plan's node label selector to node selector requirements,
+// restricted to Linux nodes.
+func nodeSelectorRequirements(selector *metav1.LabelSelector) []corev1.NodeSelectorRequirement {
+	requirements := []corev1.NodeSelectorRequirement{{
+		Key:      corev1.LabelOSStable,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"linux"},
+	}}
+	if selector == nil {
+		return requirements
+	}
+	keys := make([]string, 0, len(selector.MatchLabels))
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{selector.MatchLabels[key]},
+		})
+	}
+	for _, expression := range selector.MatchExpressions {
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      expression.Key,
+			Operator: corev1.NodeSelectorOperator(expression.Operator),
+			Values:   expression.Values,
+		})
+	}
+	return requirements
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type idsKey struct{}
+
+// ids are the trace and span IDs that the next span started with a context is given.
+type ids struct {
+	traceID trace.TraceID
+	spanID  trace.SpanID
+}
+
+func withIDs(ctx context.Context, traceID trace.TraceID, spanID trace.SpanID) context.Context {
+	return context.WithValue(ctx, idsKey{}, ids{traceID: traceID, spanID: spanID})
+}
+
+// idGenerator generates the IDs set in the context that a span is started with, or random IDs if none are set.
+type idGenerator struct{}
+
+// IDGenerator returns an IDGenerator that allows the Tracer to set deterministic trace and span IDs.
+func IDGenerator() sdktrace.IDGenerator {
+	return idGenerator{}
+}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if ids, ok := ctx.Value(idsKey{}).(ids); ok && ids.traceID.IsValid() && ids.spanID.IsValid() {
+		return ids.traceID, ids.spanID
+	}
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	rand.Read(traceID[:])
+	rand.Read(spanID[:])
+	return traceID, spanID
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, _ trace.TraceID) trace.SpanID {
+	if ids, ok := ctx.Value(idsKey{}).(ids); ok && ids.spanID.IsValid() {
+		return ids.spanID
+	}
+	var spanID trace.SpanID
+	rand.Read(spanID[:])
+	return spanID
+}
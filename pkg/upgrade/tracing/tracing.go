@@ -0,0 +1,232 @@
+package tracing
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"sync"
+	"time"
+
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/rancher/system-upgrade-controller/pkg/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+const (
+	// instrumentationName is the name of the tracer that spans are recorded with.
+	instrumentationName = "github.com/rancher/system-upgrade-controller/pkg/upgrade"
+	// recordedTTL is how long the IDs of recorded spans are remembered, so that spans are not recorded
+	// more than once when handlers are called again for the same transition.
+	recordedTTL = 24 * time.Hour
+
+	// Outcomes of a rollout on a node, or of the whole rollout.
+	OutcomeComplete             = "complete"
+	OutcomeCompleteWithFailures = "complete-with-failures"
+	OutcomeFailed               = "failed"
+	OutcomeRebootTimeout        = "reboot-timeout"
+)
+
+// Attribute keys set on spans.
+const (
+	AttributePlanKind      = attribute.Key("upgrade.plan.kind")
+	AttributePlanNamespace = attribute.Key("upgrade.plan.namespace")
+	AttributePlanName      = attribute.Key("upgrade.plan.name")
+	AttributeVersion       = attribute.Key("upgrade.plan.version")
+	AttributeHash          = attribute.Key("upgrade.plan.hash")
+	AttributeResolvedFrom  = attribute.Key("upgrade.plan.resolved_from")
+	AttributeNode          = attribute.Key("k8s.node.name")
+	AttributeJob           = attribute.Key("k8s.job.name")
+	AttributeOutcome       = attribute.Key("upgrade.outcome")
+)
+
+// Tracer records spans for the rollout of each Plan hash. The rollout is a single trace: the root span covers the
+// rollout from start to completion, with child spans for resolving the version and for the Job on each node.
+//
+// The controller does not hold spans open across reconciles. Instead, each span is recorded once the transition that
+// ends it is observed, with the start time taken from the Plan or Job status, and with trace and span IDs derived
+// from the Plan UID, hash, and Job UID. This ensures that spans recorded by different reconciles, or by different
+// controller pods after a restart or leader election, join the same trace.
+type Tracer struct {
+	tracer trace.Tracer
+
+	mu       sync.Mutex
+	recorded map[trace.SpanID]time.Time
+}
+
+// New returns a Tracer that records spans with the given provider. Providers created by NewProvider use
+// deterministic IDs; with any other provider, the spans of a rollout are not joined into one trace.
+func New(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:   provider.Tracer(instrumentationName, trace.WithInstrumentationVersion(version.Version)),
+		recorded: map[trace.SpanID]time.Time{},
+	}
+}
+
+// Noop returns a Tracer that does not record spans.
+func Noop() *Tracer {
+	return New(noop.NewTracerProvider())
+}
+
+// NewProvider returns a TracerProvider that exports spans for the named service to an OTLP gRPC collector at the
+// given endpoint, which is either host:port or a URL. If insecure is set, TLS is not used for host:port endpoints;
+// http URLs never use TLS.
+func NewProvider(ctx context.Context, serviceName, endpoint string, insecure bool, opts ...sdktrace.TracerProviderOption) (*sdktrace.TracerProvider, error) {
+	exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if strings.Contains(endpoint, "://") {
+		exporterOpts = []otlptracegrpc.Option{otlptracegrpc.WithEndpointURL(endpoint)}
+	}
+	if insecure {
+		exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version.Version),
+	)
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithIDGenerator(IDGenerator()),
+	}, opts...)
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// Plan identifies the Plan or ClusterPlan that spans are recorded for.
+type Plan struct {
+	*upgradeapiv1.Plan
+	Kind string
+}
+
+// attributes returns the attributes of spans for the rollout of the given hash of the plan.
+func (p Plan) attributes(hash string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttributePlanKind.String(p.Kind),
+		AttributePlanName.String(p.Name),
+		AttributeVersion.String(p.Status.LatestVersion),
+		AttributeHash.String(hash),
+	}
+	if p.Kind != "ClusterPlan" {
+		attrs = append(attrs, AttributePlanNamespace.String(p.Namespace))
+	}
+	return attrs
+}
+
+// Resolved records a span for resolving the latest version of the plan, which is the first step of the rollout of
+// the latest hash. It should be called once the latest version and hash have been set in the plan status.
+func (t *Tracer) Resolved(plan Plan, resolvedFrom string, start, end time.Time) {
+	spanID := deriveSpanID("resolve", string(plan.UID), plan.Status.LatestHash)
+	t.record(rolloutContext(plan.Plan, plan.Status.LatestHash, spanID), "Resolve "+plan.Kind, start, end,
+		append(plan.attributes(plan.Status.LatestHash), AttributeResolvedFrom.String(resolvedFrom)), nil)
+}
+
+// Rollout records the root span for the rollout of the latest hash of the plan, from the given start time until now.
+// If the start time is not known, the span covers only the completion of the rollout.
+func (t *Tracer) Rollout(plan Plan, outcome string, start time.Time) {
+	end := time.Now()
+	if start.IsZero() || start.After(end) {
+		start = end
+	}
+	ctx := withIDs(context.Background(), traceID(plan.Plan, plan.Status.LatestHash), rootSpanID(plan.Plan, plan.Status.LatestHash))
+	t.record(ctx, "Rollout "+plan.Kind, start, end, append(plan.attributes(plan.Status.LatestHash), AttributeOutcome.String(outcome)), nil, trace.WithNewRoot())
+}
+
+// Job records a span for the Job that applied the hash of the plan on the node, from the creation of the Job until
+// the given end time. The hash is that of the Job, which may not be the latest hash of the plan. Events are added to
+// the span at the given times, in order. If the outcome is not complete, the span status is set to the message.
+func (t *Tracer) Job(plan Plan, job *batchv1.Job, node, hash, outcome, message string, end time.Time, events ...Event) {
+	attrs := append(plan.attributes(hash),
+		AttributeNode.String(node),
+		AttributeJob.String(job.Name),
+		AttributeOutcome.String(outcome),
+	)
+	annotate := func(span trace.Span) {
+		for _, event := range events {
+			span.AddEvent(event.Name, trace.WithTimestamp(event.Time))
+		}
+		if outcome != OutcomeComplete {
+			span.SetStatus(codes.Error, message)
+		}
+	}
+	ctx := rolloutContext(plan.Plan, hash, deriveSpanID("job", string(job.UID)))
+	t.record(ctx, "Job "+node, job.CreationTimestamp.Time, end, attrs, annotate)
+}
+
+// Event is a named point in time on a span.
+type Event struct {
+	Name string
+	Time time.Time
+}
+
+// record records a span with the IDs set in the context, unless it has already been recorded.
+func (t *Tracer) record(ctx context.Context, name string, start, end time.Time, attrs []attribute.KeyValue, annotate func(trace.Span), opts ...trace.SpanStartOption) {
+	if ids, ok := ctx.Value(idsKey{}).(ids); ok && !t.markRecorded(ids.spanID, end) {
+		return
+	}
+	if end.Before(start) {
+		end = start
+	}
+	opts = append(opts, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	_, span := t.tracer.Start(ctx, name, opts...)
+	if annotate != nil {
+		annotate(span)
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// markRecorded returns false if the span has already been recorded, and otherwise remembers that it has been.
+// Spans recorded more than recordedTTL ago are forgotten.
+func (t *Tracer) markRecorded(spanID trace.SpanID, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.recorded[spanID]; ok {
+		return false
+	}
+	for id, recorded := range t.recorded {
+		if now.Sub(recorded) > recordedTTL {
+			delete(t.recorded, id)
+		}
+	}
+	t.recorded[spanID] = now
+	return true
+}
+
+// rolloutContext returns a context for starting a span with the given ID, with the root span of the rollout
+// of the hash of the plan as the remote parent.
+func rolloutContext(plan *upgradeapiv1.Plan, hash string, spanID trace.SpanID) context.Context {
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID(plan, hash),
+		SpanID:     rootSpanID(plan, hash),
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+	return withIDs(ctx, traceID(plan, hash), spanID)
+}
+
+func traceID(plan *upgradeapiv1.Plan, hash string) trace.TraceID {
+	var id trace.TraceID
+	sum := sha256.Sum256([]byte("trace\x00" + string(plan.UID) + "\x00" + hash))
+	copy(id[:], sum[:])
+	return id
+}
+
+func rootSpanID(plan *upgradeapiv1.Plan, hash string) trace.SpanID {
+	return deriveSpanID("rollout", string(plan.UID), hash)
+}
+
+func deriveSpanID(parts ...string) trace.SpanID {
+	var id trace.SpanID
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	copy(id[:], sum[:])
+	return id
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Tracer", func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		tracer   *Tracer
+		plan     Plan
+	)
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		tracer = New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder), sdktrace.WithIDGenerator(IDGenerator())))
+		plan = Plan{
+			Kind: "Plan",
+			Plan: &upgradeapiv1.Plan{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "system-upgrade", Name: "test-plan", UID: "plan-uid"},
+				Status:     upgradeapiv1.PlanStatus{LatestVersion: "v1.0.0", LatestHash: "hash-1"},
+			},
+		}
+	})
+
+	newJob := func(uid string, created time.Time) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "apply-test-plan-on-node-1", UID: types.UID("job-" + uid), CreationTimestamp: metav1.NewTime(created)}}
+	}
+
+	It("should join the spans of a rollout into one trace under the root span", func() {
+		start := time.Now().Add(-time.Hour)
+		tracer.Resolved(plan, "Spec.Channel", start, start.Add(time.Second))
+		tracer.Job(plan, newJob("1", start.Add(time.Minute)), "node-1", "hash-1", OutcomeComplete, "", start.Add(10*time.Minute),
+			Event{Name: "JobComplete", Time: start.Add(9 * time.Minute)})
+		tracer.Rollout(plan, OutcomeComplete, start)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(3))
+		root := spans[2]
+		Expect(root.Name()).To(Equal("Rollout Plan"))
+		Expect(root.Parent().IsValid()).To(BeFalse())
+		Expect(root.StartTime()).To(BeTemporally("==", start))
+		for _, span := range spans[:2] {
+			Expect(span.SpanContext().TraceID()).To(Equal(root.SpanContext().TraceID()))
+			Expect(span.Parent().SpanID()).To(Equal(root.SpanContext().SpanID()))
+		}
+
+		job := spans[1]
+		Expect(job.Name()).To(Equal("Job node-1"))
+		Expect(job.StartTime()).To(BeTemporally("==", start.Add(time.Minute)))
+		Expect(job.EndTime()).To(BeTemporally("==", start.Add(10*time.Minute)))
+		Expect(job.Attributes()).To(ContainElements(
+			AttributeVersion.String("v1.0.0"),
+			AttributeNode.String("node-1"),
+			AttributeOutcome.String(OutcomeComplete),
+		))
+		Expect(job.Events()).To(HaveLen(1))
+		Expect(job.Status().Code).To(Equal(codes.Unset))
+	})
+
+	It("should use the same IDs for the same rollout, and different IDs for a new hash", func() {
+		tracer.Rollout(plan, OutcomeComplete, time.Now())
+		other := New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder), sdktrace.WithIDGenerator(IDGenerator())))
+		other.Rollout(plan, OutcomeComplete, time.Now())
+		plan.Status.LatestHash = "hash-2"
+		other.Rollout(plan, OutcomeComplete, time.Now())
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(3))
+		Expect(spans[1].SpanContext().TraceID()).To(Equal(spans[0].SpanContext().TraceID()))
+		Expect(spans[1].SpanContext().SpanID()).To(Equal(spans[0].SpanContext().SpanID()))
+		Expect(spans[2].SpanContext().TraceID()).ToNot(Equal(spans[0].SpanContext().TraceID()))
+	})
+
+	It("should record each span once", func() {
+		job := newJob("1", time.Now().Add(-time.Minute))
+		tracer.Job(plan, job, "node-1", "hash-1", OutcomeFailed, "BackoffLimitExceeded", time.Now())
+		tracer.Job(plan, job, "node-1", "hash-1", OutcomeFailed, "BackoffLimitExceeded", time.Now())
+		tracer.Rollout(plan, OutcomeComplete, time.Now())
+		tracer.Rollout(plan, OutcomeComplete, time.Now())
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+		Expect(spans[0].Status().Description).To(Equal("BackoffLimitExceeded"))
+	})
+
+	It("should not set the namespace of ClusterPlans", func() {
+		plan.Kind = "ClusterPlan"
+		tracer.Rollout(plan, OutcomeComplete, time.Time{})
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name()).To(Equal("Rollout ClusterPlan"))
+		Expect(spans[0].StartTime()).To(Equal(spans[0].EndTime()))
+		for _, attr := range spans[0].Attributes() {
+			Expect(attr.Key).ToNot(Equal(AttributePlanNamespace))
+		}
+	})
+})
@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	admissionregistrationv1 "k8s.io/client-go/kubernetes/typed/admissionregistration/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// CertFile is the name of the serving certificate in the certificate directory.
+	CertFile = "tls.crt"
+	// KeyFile is the name of the serving certificate key in the certificate directory.
+	KeyFile = "tls.key"
+
+	// selfSignedValidity is how long self-signed certificates are valid for.
+	selfSignedValidity = time.Hour * 24 * 365
+	// selfSignedRenewal is how long before they expire that self-signed certificates are renewed.
+	selfSignedRenewal = time.Hour * 24 * 30
+)
+
+// LoadCert loads the serving certificate and key from the certificate directory, such as a mounted Secret.
+// If they do not exist, the returned error wraps fs.ErrNotExist.
+func LoadCert(dir string) (tls.Certificate, error) {
+	return tls.LoadX509KeyPair(filepath.Join(dir, CertFile), filepath.Join(dir, KeyFile))
+}
+
+// EnsureCertSecret returns the PEM-encoded serving certificate and key stored in the named Secret. If the Secret does
+// not exist, or its certificate expires within selfSignedRenewal, a self-signed certificate for the given DNS names
+// is created and stored in it. The Secret is shared by all replicas of the controller, and retained across restarts,
+// so that the certificate only changes when it is renewed.
+func EnsureCertSecret(ctx context.Context, secrets typedcorev1.SecretInterface, name string, dnsNames ...string) (certPEM, keyPEM []byte, err error) {
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = nil
+	case err != nil:
+		return nil, nil, err
+	case !expiring(secret.Data[CertFile], time.Now().Add(selfSignedRenewal)):
+		return secret.Data[CertFile], secret.Data[KeyFile], nil
+	}
+	if certPEM, keyPEM, err = SelfSignedCert(dnsNames...); err != nil {
+		return nil, nil, err
+	}
+	if secret == nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Type:       corev1.SecretTypeTLS,
+		}
+		secret.Data = map[string][]byte{CertFile: certPEM, KeyFile: keyPEM}
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		secret = secret.DeepCopy()
+		secret.Data = map[string][]byte{CertFile: certPEM, KeyFile: keyPEM}
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	// another replica stored a certificate first; use that one
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		return EnsureCertSecret(ctx, secrets, name, dnsNames...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	logrus.Warnf("Created self-signed webhook certificate in Secret %s for %v", name, dnsNames)
+	return certPEM, keyPEM, nil
+}
+
+// expiring returns true if the PEM-encoded certificate cannot be parsed, or is not valid at the given time.
+func expiring(certPEM []byte, at time.Time) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	return err != nil || at.After(cert.NotAfter)
+}
+
+// PatchCABundle sets the caBundle of the webhooks in the named mutating and validating webhook configurations to the
+// given PEM-encoded certificate, where it is not already set to it. Configurations that do not exist are skipped.
+func PatchCABundle(ctx context.Context, client admissionregistrationv1.AdmissionregistrationV1Interface, name string, caPEM []byte) error {
+	mutating, err := client.MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		changed := false
+		for i := range mutating.Webhooks {
+			if !bytes.Equal(mutating.Webhooks[i].ClientConfig.CABundle, caPEM) {
+				mutating.Webhooks[i].ClientConfig.CABundle = caPEM
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := client.MutatingWebhookConfigurations().Update(ctx, mutating, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+			logrus.Infof("Updated caBundle of MutatingWebhookConfiguration %s", name)
+		}
+	}
+	validating, err := client.ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		changed := false
+		for i := range validating.Webhooks {
+			if !bytes.Equal(validating.Webhooks[i].ClientConfig.CABundle, caPEM) {
+				validating.Webhooks[i].ClientConfig.CABundle = caPEM
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := client.ValidatingWebhookConfigurations().Update(ctx, validating, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+			logrus.Infof("Updated caBundle of ValidatingWebhookConfiguration %s", name)
+		}
+	}
+	return nil
+}
+
+// SelfSignedCert returns a PEM-encoded self-signed certificate and key for the given DNS names.
+func SelfSignedCert(dnsNames ...string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:              dnsNames,
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
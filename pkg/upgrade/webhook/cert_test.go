@@ -0,0 +1,108 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/webhook"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Certificates", func() {
+	var (
+		ctx       context.Context
+		clientset *fake.Clientset
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clientset = fake.NewSimpleClientset()
+	})
+
+	Describe("LoadCert", func() {
+		It("should report a missing certificate as not existing", func() {
+			_, err := webhook.LoadCert(GinkgoT().TempDir())
+			Expect(err).To(MatchError(fs.ErrNotExist))
+		})
+
+		It("should load a mounted certificate", func() {
+			dir := GinkgoT().TempDir()
+			certPEM, keyPEM, err := webhook.SelfSignedCert("system-upgrade-controller.system-upgrade.svc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dir, webhook.CertFile), certPEM, 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, webhook.KeyFile), keyPEM, 0600)).To(Succeed())
+			_, err = webhook.LoadCert(dir)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("EnsureCertSecret", func() {
+		It("should create a certificate once, and return it on later calls", func() {
+			secrets := clientset.CoreV1().Secrets("system-upgrade")
+			certPEM, keyPEM, err := webhook.EnsureCertSecret(ctx, secrets, "system-upgrade-controller-webhook-tls", "system-upgrade-controller.system-upgrade.svc")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tls.X509KeyPair(certPEM, keyPEM)
+			Expect(err).ToNot(HaveOccurred())
+
+			// a restarted or other replica uses the same certificate
+			certPEM2, keyPEM2, err := webhook.EnsureCertSecret(ctx, secrets, "system-upgrade-controller-webhook-tls", "system-upgrade-controller.system-upgrade.svc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(certPEM2).To(Equal(certPEM))
+			Expect(keyPEM2).To(Equal(keyPEM))
+
+			secret, err := secrets.Get(ctx, "system-upgrade-controller-webhook-tls", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secret.Type).To(Equal(corev1.SecretTypeTLS))
+		})
+
+		It("should replace a certificate that cannot be parsed", func() {
+			secrets := clientset.CoreV1().Secrets("system-upgrade")
+			_, err := secrets.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "system-upgrade-controller-webhook-tls"},
+				Data:       map[string][]byte{webhook.CertFile: []byte("invalid"), webhook.KeyFile: []byte("invalid")},
+			}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			certPEM, keyPEM, err := webhook.EnsureCertSecret(ctx, secrets, "system-upgrade-controller-webhook-tls", "system-upgrade-controller.system-upgrade.svc")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = tls.X509KeyPair(certPEM, keyPEM)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("PatchCABundle", func() {
+		It("should set the caBundle of the webhook configurations", func() {
+			admission := clientset.AdmissionregistrationV1()
+			_, err := admission.MutatingWebhookConfigurations().Create(ctx, &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "system-upgrade-controller"},
+				Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "plans.upgrade.cattle.io"}},
+			}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admission.ValidatingWebhookConfigurations().Create(ctx, &admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "system-upgrade-controller"},
+				Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "plans.upgrade.cattle.io"}},
+			}, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(webhook.PatchCABundle(ctx, admission, "system-upgrade-controller", []byte("ca"))).To(Succeed())
+			mutating, err := admission.MutatingWebhookConfigurations().Get(ctx, "system-upgrade-controller", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(mutating.Webhooks[0].ClientConfig.CABundle).To(Equal([]byte("ca")))
+			validating, err := admission.ValidatingWebhookConfigurations().Get(ctx, "system-upgrade-controller", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(validating.Webhooks[0].ClientConfig.CABundle).To(Equal([]byte("ca")))
+		})
+
+		It("should skip webhook configurations that do not exist", func() {
+			Expect(webhook.PatchCABundle(ctx, clientset.AdmissionregistrationV1(), "system-upgrade-controller", []byte("ca"))).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/docker/distribution/reference"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	upgradeplan "github.com/rancher/system-upgrade-controller/pkg/upgrade/plan"
+	corectlv1 "github.com/rancher/wrangler/v3/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// MutatePath is the path that the defaulting webhook is served at.
+	MutatePath = "/mutate"
+	// ValidatePath is the path that the validating webhook is served at.
+	ValidatePath = "/validate"
+	// ValidateMaintenanceWindowPath is the path that the validating webhook for MaintenanceWindows is served at.
+	ValidateMaintenanceWindowPath = "/validate-maintenancewindow"
+
+	// maxRequestBytes limits the size of AdmissionReview requests read by the webhook.
+	maxRequestBytes = 3 * 1024 * 1024
+)
+
+var (
+	ErrInvalidConcurrency = errors.New("spec.concurrency must be greater than 0")
+	ErrInvalidImage       = errors.New("invalid image reference")
+	ErrUnsupportedKind    = errors.New("unsupported kind")
+)
+
+// NamespaceFunc returns true if Plans in the given namespace are handled by the controller.
+type NamespaceFunc func(namespace string) bool
+
+// Handler serves admission webhooks that default and validate Plans and ClusterPlans, and validate MaintenanceWindows.
+type Handler struct {
+	namespace        string
+	watchesNamespace NamespaceFunc
+	secretCache      corectlv1.SecretCache
+}
+
+// New returns a Handler that validates Plans in namespaces handled by the controller, and ClusterPlans as Plans in the
+// controller namespace. Secrets referenced by Plans are resolved from the cache.
+func New(namespace string, watchesNamespace NamespaceFunc, secretCache corectlv1.SecretCache) *Handler {
+	return &Handler{
+		namespace:        namespace,
+		watchesNamespace: watchesNamespace,
+		secretCache:      secretCache,
+	}
+}
+
+// ServeMux returns a ServeMux that serves the defaulting and validating webhooks.
+func (h *Handler) ServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MutatePath, h.serve(func(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		return h.admit(request, h.mutate)
+	}))
+	mux.HandleFunc(ValidatePath, h.serve(func(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+		return h.admit(request, h.validate)
+	}))
+	mux.HandleFunc(ValidateMaintenanceWindowPath, h.serve(h.validateMaintenanceWindow))
+	return mux
+}
+
+// PatchOperation is a JSON patch operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Default returns JSON patch operations that add default values for fields of the PlanSpec that are unset. Only unset
+// fields are added, so that fields not known to the webhook are kept as they are. The concurrency is not defaulted, as
+// the controller does not apply a Plan with no concurrency on any node; it is instead required to be set by Validate.
+func Default(spec *upgradeapiv1.PlanSpec) []PatchOperation {
+	var patch []PatchOperation
+	if spec.Approval == "" {
+		patch = append(patch, PatchOperation{Op: "add", Path: "/spec/approval", Value: upgradeapiv1.ApprovalAutomatic})
+	}
+	if spec.Reboot != nil && spec.Reboot.Policy == "" {
+		patch = append(patch, PatchOperation{Op: "add", Path: "/spec/reboot/policy", Value: upgradeapiv1.RebootNever})
+	}
+	return patch
+}
+
+// Validate runs the same validation as the controller, and additionally checks the concurrency and image references.
+// Warnings are returned for fields that are ignored by the controller.
+func Validate(plan *upgradeapiv1.Plan, secretCache corectlv1.SecretCache) (warnings []string, err error) {
+	if err := upgradeplan.Validate(plan, secretCache); err != nil {
+		return nil, err
+	}
+	if plan.Spec.Concurrency <= 0 {
+		return nil, ErrInvalidConcurrency
+	}
+	var fields, images []string
+	if plan.Spec.Prepare != nil {
+		fields, images = append(fields, "spec.prepare.image"), append(images, plan.Spec.Prepare.Image)
+	}
+	for i, step := range plan.Spec.Steps {
+		fields, images = append(fields, fmt.Sprintf("spec.steps[%d].image", i)), append(images, step.Image)
+	}
+	if plan.Spec.Upgrade != nil {
+		fields, images = append(fields, "spec.upgrade.image"), append(images, plan.Spec.Upgrade.Image)
+	}
+	for i, image := range images {
+		if _, err := reference.ParseNormalizedNamed(image); err != nil {
+			return nil, fmt.Errorf("%w in %s: %v", ErrInvalidImage, fields[i], err)
+		}
+	}
+	if plan.Spec.Cordon && plan.Spec.Drain != nil {
+		warnings = append(warnings, "spec.cordon is ignored when spec.drain is specified")
+	}
+	return warnings, nil
+}
+
+// admitFunc handles an AdmissionRequest for a Plan, returning the response.
+type admitFunc func(request *admissionv1.AdmissionRequest, plan *upgradeapiv1.Plan) *admissionv1.AdmissionResponse
+
+func (h *Handler) serve(respond func(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, "malformed AdmissionReview", http.StatusBadRequest)
+			return
+		}
+		review.Response = respond(review.Request)
+		review.Response.UID = review.Request.UID
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			logrus.Errorf("Failed to write AdmissionReview response: %v", err)
+		}
+	}
+}
+
+// admit decodes the Plan or ClusterPlan from the request, and passes it to the admitFunc. ClusterPlans
+// are admitted as Plans in the controller namespace.
+func (h *Handler) admit(request *admissionv1.AdmissionRequest, admit admitFunc) *admissionv1.AdmissionResponse {
+	switch request.Kind.Kind {
+	case "Plan":
+		plan := &upgradeapiv1.Plan{}
+		if err := json.Unmarshal(request.Object.Raw, plan); err != nil {
+			return deny(err)
+		}
+		plan.Namespace = request.Namespace
+		if !h.watchesNamespace(plan.Namespace) {
+			return &admissionv1.AdmissionResponse{Allowed: true}
+		}
+		return admit(request, plan)
+	case "ClusterPlan":
+		clusterPlan := &upgradeapiv1.ClusterPlan{}
+		if err := json.Unmarshal(request.Object.Raw, clusterPlan); err != nil {
+			return deny(err)
+		}
+		plan := upgradeplan.FromClusterPlan(clusterPlan, h.namespace)
+		return admit(request, plan)
+	default:
+		return deny(fmt.Errorf("%w: %s", ErrUnsupportedKind, request.Kind.Kind))
+	}
+}
+
+func (h *Handler) mutate(_ *admissionv1.AdmissionRequest, plan *upgradeapiv1.Plan) *admissionv1.AdmissionResponse {
+	operations := Default(&plan.Spec)
+	if len(operations) == 0 {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	patch, err := json.Marshal(operations)
+	if err != nil {
+		return deny(err)
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+func (h *Handler) validate(request *admissionv1.AdmissionRequest, plan *upgradeapiv1.Plan) *admissionv1.AdmissionResponse {
+	warnings, err := Validate(plan, h.secretCache)
+	if err != nil {
+		logrus.Debugf("Denied %s of %s %s/%s: %v", request.Operation, request.Kind.Kind, plan.Namespace, plan.Name, err)
+		return deny(err)
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: warnings,
+	}
+}
+
+// validateMaintenanceWindow runs the same validation of MaintenanceWindows as the controller does for the Plans that
+// refer to them.
+func (h *Handler) validateMaintenanceWindow(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if request.Kind.Kind != "MaintenanceWindow" {
+		return deny(fmt.Errorf("%w: %s", ErrUnsupportedKind, request.Kind.Kind))
+	}
+	maintenanceWindow := &upgradeapiv1.MaintenanceWindow{}
+	if err := json.Unmarshal(request.Object.Raw, maintenanceWindow); err != nil {
+		return deny(err)
+	}
+	if err := upgradeplan.ValidateMaintenanceWindow(maintenanceWindow); err != nil {
+		logrus.Debugf("Denied %s of MaintenanceWindow %s: %v", request.Operation, maintenanceWindow.Name, err)
+		return deny(err)
+	}
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+func deny(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
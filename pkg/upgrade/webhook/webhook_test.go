@@ -0,0 +1,165 @@
+package webhook_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	upgradeapiv1 "github.com/rancher/system-upgrade-controller/pkg/apis/upgrade.cattle.io/v1"
+	"github.com/rancher/system-upgrade-controller/pkg/upgrade/webhook"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Webhook", func() {
+	var plan *upgradeapiv1.Plan
+	BeforeEach(func() {
+		plan = &upgradeapiv1.Plan{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-plan", Namespace: "system-upgrade"},
+			Spec: upgradeapiv1.PlanSpec{
+				Version: "v1.0.0",
+				Upgrade: &upgradeapiv1.ContainerSpec{Image: "test/image"},
+			},
+		}
+	})
+
+	Context("Default", func() {
+		It("should default approval, but not concurrency", func() {
+			Expect(webhook.Default(&plan.Spec)).To(Equal([]webhook.PatchOperation{
+				{Op: "add", Path: "/spec/approval", Value: upgradeapiv1.ApprovalAutomatic},
+			}))
+		})
+		It("should default the reboot policy", func() {
+			plan.Spec.Approval = upgradeapiv1.ApprovalManual
+			plan.Spec.Reboot = &upgradeapiv1.RebootSpec{}
+			Expect(webhook.Default(&plan.Spec)).To(Equal([]webhook.PatchOperation{
+				{Op: "add", Path: "/spec/reboot/policy", Value: upgradeapiv1.RebootNever},
+			}))
+		})
+		It("should not patch fields that are set", func() {
+			plan.Spec.Approval = upgradeapiv1.ApprovalManual
+			plan.Spec.Reboot = &upgradeapiv1.RebootSpec{Policy: upgradeapiv1.RebootAlways}
+			Expect(webhook.Default(&plan.Spec)).To(BeEmpty())
+		})
+	})
+
+	Context("Validate", func() {
+		BeforeEach(func() {
+			plan.Spec.Concurrency = 1
+		})
+		It("should accept a valid plan", func() {
+			warnings, err := webhook.Validate(plan, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+		It("should reject a plan without concurrency", func() {
+			plan.Spec.Concurrency = 0
+			_, err := webhook.Validate(plan, nil)
+			Expect(err).To(MatchError(webhook.ErrInvalidConcurrency))
+		})
+		It("should reject an invalid image reference", func() {
+			plan.Spec.Steps = []upgradeapiv1.StepSpec{{Name: "test", ContainerSpec: upgradeapiv1.ContainerSpec{Image: "Invalid//Image"}}}
+			_, err := webhook.Validate(plan, nil)
+			Expect(err).To(MatchError(webhook.ErrInvalidImage))
+			Expect(err.Error()).To(ContainSubstring("spec.steps[0].image"))
+		})
+		It("should reject an invalid retry policy", func() {
+			plan.Spec.Retry = &upgradeapiv1.RetrySpec{On: []upgradeapiv1.RetryOn{"Prepare"}}
+			_, err := webhook.Validate(plan, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.retry is invalid"))
+		})
+		It("should warn if cordon is specified with drain", func() {
+			plan.Spec.Cordon = true
+			plan.Spec.Drain = &upgradeapiv1.DrainSpec{}
+			warnings, err := webhook.Validate(plan, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+		})
+	})
+
+	Context("Handler", func() {
+		var server *httptest.Server
+		BeforeEach(func() {
+			handler := webhook.New("system-upgrade", func(namespace string) bool { return namespace == "system-upgrade" }, nil)
+			server = httptest.NewServer(handler.ServeMux())
+			DeferCleanup(server.Close)
+		})
+		review := func(path, kind, namespace string, obj runtime.Object) *admissionv1.AdmissionResponse {
+			raw, err := json.Marshal(obj)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := json.Marshal(&admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					UID:       types.UID("test-uid"),
+					Kind:      metav1.GroupVersionKind{Group: "upgrade.cattle.io", Version: "v1", Kind: kind},
+					Namespace: namespace,
+					Operation: admissionv1.Create,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := http.Post(server.URL+path, "application/json", bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			result := &admissionv1.AdmissionReview{}
+			Expect(json.NewDecoder(resp.Body).Decode(result)).To(Succeed())
+			Expect(result.Response).ToNot(BeNil())
+			Expect(result.Response.UID).To(BeEquivalentTo("test-uid"))
+			return result.Response
+		}
+		It("should patch defaults into unset fields of the spec", func() {
+			plan.Spec.Reboot = &upgradeapiv1.RebootSpec{}
+			response := review(webhook.MutatePath, "Plan", "system-upgrade", plan)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(*response.PatchType).To(Equal(admissionv1.PatchTypeJSONPatch))
+			var patch []webhook.PatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			Expect(patch).To(ConsistOf(
+				webhook.PatchOperation{Op: "add", Path: "/spec/approval", Value: "Automatic"},
+				webhook.PatchOperation{Op: "add", Path: "/spec/reboot/policy", Value: "Never"},
+			))
+		})
+		It("should not patch a spec without unset defaults", func() {
+			plan.Spec.Approval = upgradeapiv1.ApprovalAutomatic
+			response := review(webhook.MutatePath, "Plan", "system-upgrade", plan)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+		})
+		It("should deny an invalid plan", func() {
+			response := review(webhook.ValidatePath, "Plan", "system-upgrade", plan)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal(webhook.ErrInvalidConcurrency.Error()))
+		})
+		It("should validate cluster plans", func() {
+			clusterPlan := &upgradeapiv1.ClusterPlan{ObjectMeta: metav1.ObjectMeta{Name: "test-plan"}, Spec: plan.Spec}
+			response := review(webhook.ValidatePath, "ClusterPlan", "", clusterPlan)
+			Expect(response.Allowed).To(BeFalse())
+		})
+		It("should allow plans in namespaces that are not watched", func() {
+			response := review(webhook.ValidatePath, "Plan", "other", plan)
+			Expect(response.Allowed).To(BeTrue())
+		})
+		It("should validate maintenance windows", func() {
+			maintenanceWindow := &upgradeapiv1.MaintenanceWindow{
+				ObjectMeta: metav1.ObjectMeta{Name: "weekends"},
+				Spec: upgradeapiv1.MaintenanceWindowSpec{
+					Windows:  []upgradeapiv1.TimeWindowSpec{{Days: []upgradeapiv1.Day{"saturday", "sunday"}, StartTime: "01:00", EndTime: "05:00"}},
+					TimeZone: "Europe/Berlin",
+				},
+			}
+			response := review(webhook.ValidateMaintenanceWindowPath, "MaintenanceWindow", "", maintenanceWindow)
+			Expect(response.Allowed).To(BeTrue())
+
+			maintenanceWindow.Spec.TimeZone = "Invalid/Zone"
+			response = review(webhook.ValidateMaintenanceWindowPath, "MaintenanceWindow", "", maintenanceWindow)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("spec.timeZone"))
+		})
+	})
+})